@@ -0,0 +1,114 @@
+package main
+
+import (
+	"city_os/cmd/app/configs"
+	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/dbconnectors"
+	"city_os/src/models"
+	"context"
+	"flag"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// API key administration, mainly to mint the first admin key of a deployment:
+//
+//	apikeys mint -name ci -scopes files:read,files:write
+//	apikeys list
+//	apikeys revoke <key id>
+//
+// Every command takes -tenant to work on a tenant other than the default one.
+
+func main() {
+	// config flags come before the command, e.g. apikeys -config ./appConfig.json list
+	global := flag.NewFlagSet("apikeys", flag.ExitOnError)
+	configFlags := configs.RegisterFlags(global)
+	_ = global.Parse(os.Args[1:])
+	if global.NArg() < 1 {
+		usage()
+	}
+	command, args := global.Arg(0), global.Args()[1:]
+
+	configs.LoadConfig(configFlags)
+	logger.InitLogger()
+
+	mongoClient := dbconnectors.MongoDBClient{}
+	mongoClient.InitConnection(
+		&dbconnectors.MongoDBSettings{
+			URI:               configs.Config.DB.URI,
+			PoolSize:          configs.Config.DB.PoolSize,
+			VideoCatalogueDB:  configs.Config.DB.DBs.VideoCatalogueDB,
+			APIKeysCollection: configs.Config.DB.Collections.APIKeysColl,
+			TenantIsolation:   configs.Config.DB.TenantIsolation,
+		})
+	defer mongoClient.GetConnection().(*mongo.Client).Disconnect(context.Background())
+
+	apiKeyDBWrapper := dbconnectors.APIKeyDBWrapper{}
+	apiKeyDBWrapper.InitDatabase(&mongoClient)
+	apiKeyManager := controllers.APIKeyManager{APIKeyDBWrapper: &apiKeyDBWrapper}
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	tenant := flags.String("tenant", models.DefaultTenant, "tenant of the keys")
+
+	switch command {
+	case "mint":
+		name := flags.String("name", "", "name of the key owner")
+		scopes := flags.String("scopes", "", "comma separated scopes: files:read,files:write,files:delete,admin")
+		_ = flags.Parse(args)
+
+		apiKey, plaintextKey, err := apiKeyManager.MintKey(*tenant, *name, strings.Split(*scopes, ","))
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("id:     %s\ntenant: %s\nscopes: %s\nkey:    %s\n\nStore the key now, it can't be shown again.\n",
+			apiKey.KeyId, apiKey.Tenant, strings.Join(apiKey.Scopes, ","), plaintextKey)
+	case "list":
+		_ = flags.Parse(args)
+		apiKeys, err := apiKeyManager.GetKeysList(*tenant)
+		if err != nil {
+			fail(err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tSCOPES\tCREATED\tREVOKED")
+		for _, apiKey := range apiKeys {
+			revoked := "-"
+			if apiKey.RevokedAt != 0 {
+				revoked = apiKey.RevokedAt.Time().Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", apiKey.KeyId, apiKey.Name, strings.Join(apiKey.Scopes, ","),
+				apiKey.CreatedAt.Time().Format(time.RFC3339), revoked)
+		}
+		_ = writer.Flush()
+	case "revoke":
+		_ = flags.Parse(args)
+		if flags.NArg() != 1 {
+			usage()
+		}
+		keyId := flags.Arg(0)
+		revoked, err := apiKeyManager.RevokeKey(*tenant, keyId)
+		if err != nil {
+			fail(err)
+		}
+		if !revoked {
+			fail(fmt.Errorf("no active key with id %s in tenant %s", keyId, *tenant))
+		}
+		fmt.Printf("Key %s revoked\n", keyId)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apikeys [-config <file>] [-set <key=value>]... mint [-tenant <tenant>] -name <name> -scopes <scope,...> | list [-tenant <tenant>] | revoke [-tenant <tenant>] <key id>")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+	os.Exit(1)
+}
@@ -1,14 +1,22 @@
 package configs
 
 import (
+	"city_os/src/models"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"os"
+	"strings"
+	"time"
 )
 
+// AppConfig, settings of the server, the worker and the admin commands. Every field has a default, see
+// defaults.go, and is read from the config file, CITY_OS_<KEY> environment variables and -set flags.
+
 type AppConfig struct {
 	DB struct {
-		URI      string
+		URI      string `secret:"url"` // MONGODB_URI env
 		PoolSize uint64
 		DBs      struct {
 			VideoCatalogueDB string
@@ -16,22 +24,144 @@ type AppConfig struct {
 		Collections struct {
 			VideoCatalogueColl string
 			VideoFilesColl     string
+			JobsColl           string
+			WebhooksColl       string
+			DeliveriesColl     string
+			EventsColl         string
+			CountersColl       string
+			APIKeysColl        string
+			UsageColl          string
+			SharesColl         string
+			DataKeysColl       string
+		}
+		UseTransactions bool
+		TenantIsolation string // "bucket": shared catalogue filtered by tenant, "prefix": catalogue collection per tenant
+		Timeouts        struct {
+			Read     time.Duration // Deadline of single finds and counts, none when 0
+			Write    time.Duration // Deadline of single inserts, updates, deletes and transactions
+			Upload   time.Duration // Deadline of storing a file in GridFS
+			Download time.Duration // Deadline of reading a file from GridFS
 		}
 	}
 	Logger struct {
 		OutFile string
 		Level   string
 	}
+	Ingest struct {
+		FastStart bool // Relocate MP4 moov atom in front of mdat before storing
+	}
+	Scanner struct {
+		Enabled  bool
+		Address  string // clamd address, tcp://host:port or unix:///path/to/clamd.sock
+		Timeout  time.Duration
+		FailOpen bool // Accept uploads unscanned when clamd fails instead of answering 503
+	}
+	Encryption struct {
+		Enabled     bool   // Envelope encryption of new files, existing files stay readable either way
+		Provider    string // "static": MasterKeys, "localkms": master keys in KeyringFile
+		MasterKeys  string `secret:"true"` // ENCRYPTION_MASTER_KEYS env, "<id>:<base64 key>,..." incl. keys still being rotated away from
+		ActiveKeyId string // Master key of the static provider sealing new data keys
+		KeyringFile string
+		ChunkSize   int
+	}
+	Jobs struct {
+		Workers       int // Workers running inside the API server, 0 leaves processing to cmd/worker
+		LeaseDuration time.Duration
+		PollInterval  time.Duration
+		MaxAttempts   int
+		BackoffBase   time.Duration
+		BackoffMax    time.Duration
+		PostUpload    []string // Job types enqueued after every upload
+	}
+	Webhooks struct {
+		MaxAttempts      int
+		Timeout          time.Duration
+		DeliveryLogLimit int64 // Deliveries returned by the delivery log endpoint
+		AllowPrivate     bool  // Deliveries to loopback, link-local and private addresses, for local setups
+	}
+	Auth struct {
+		Enabled bool // Requests to /v1 (except health) need credentials
+		JWT     struct {
+			Enabled      bool
+			JWKSURL      string
+			JWKSFile     string // Used instead of JWKSURL when set, for offline testing
+			JWKSRefresh  time.Duration
+			Issuer       string
+			Audience     string
+			Leeway       time.Duration
+			SubjectClaim string
+			NameClaim    string
+			RolesClaim   string // Dotted path, e.g. "realm_access.roles"
+			ScopeClaim   string
+			GroupsClaim  string
+			TenantClaim  string
+			RoleScopes   map[string][]string // Scopes granted per role
+		}
+	}
+	Health struct {
+		CheckTimeout     time.Duration // Time every readiness check gets
+		MinFreeTempBytes uint64        // Readiness fails when the temp directory has less space left, 0 disables the check
+	}
+	Server struct {
+		Host           string        // Domain name the server is reached at, HOST env
+		Port           int           // Port the server listens on, PORT env
+		PublicBaseURL  string        // e.g. https://videos.example.com behind a TLS proxy, URLs in responses follow the request when empty
+		TrustedProxies []string      // Addresses or CIDRs of proxies whose X-Forwarded-For/Proto/Host/Prefix headers are used
+		DrainPeriod    time.Duration // Health check fails this long before the listener closes on shutdown
+		GracePeriod    time.Duration // Time requests in flight get to finish on shutdown before connections are closed
+	}
+	Uploads struct {
+		ProgressRetention time.Duration // How long progress of finished (or never started) uploads is kept
+		MediaTypes        []string      // Media types accepted for upload, reloaded when the config file changes
+	}
+	Quotas     models.QuotaLimits // Storage quotas, 0 limits are unlimited
+	RateLimits models.RateLimits  // Reloaded when the config file changes
+	BodyLimits models.BodyLimits  // Reloaded when the config file changes
+	CORS       models.CORSPolicy  // Reloaded when the config file changes
+	Shares     struct {
+		Enabled    bool   // Signed download and upload URLs, needs a SigningKey of minShareSigningKeyLength
+		SigningKey string `secret:"true"` // HMAC key of signed URLs, SHARE_SIGNING_KEY env, must be the same on every instance
+		DefaultTTL time.Duration
+		MaxTTL     time.Duration
+	}
+	Metrics struct {
+		Enabled bool
+		Path    string // Served without credentials, outside of /v1
+	}
+	Tracing struct {
+		Enabled     bool
+		ServiceName string
+		Exporter    string  // otlp or stdout
+		Endpoint    string  // host:port of the OTLP collector, OTEL_EXPORTER_OTLP_ENDPOINT when empty
+		Insecure    bool    // OTLP without TLS
+		SampleRatio float64 // Share of new traces recorded, requests of a sampled caller are always recorded
+	}
+	Events struct {
+		PollInterval time.Duration // Outbox polling of the relay and of change feed long-polls
+		ClaimFor     time.Duration // Time the relay gets to publish a claimed event before another instance may retry it
+	}
 }
 
 var Config *AppConfig
 
+const (
+	// configFileEnv, environment variable naming the config file when -config isn't given
+	configFileEnv = "CITY_OS_CONFIG"
+	// envPrefix, prefix of the environment variables overriding config keys, e.g. CITY_OS_JOBS_WORKERS
+	envPrefix = "CITY_OS"
+)
+
+// configSearchPaths, directories searched for appConfig.json (or .yaml) when no config file is named
+var configSearchPaths = []string{"./cmd/app/configs", ".", "/etc/city_os"}
+
 func (ac *AppConfig) GetLogLevel() log.Level {
 	switch ac.Logger.Level {
 	case "info":
 		return log.InfoLevel
 	case "debug":
 		return log.DebugLevel
+	case "warn":
+		return log.WarnLevel
 	case "error":
 		return log.ErrorLevel
 	default:
@@ -40,31 +170,336 @@ func (ac *AppConfig) GetLogLevel() log.Level {
 
 }
 
-func (ac *AppConfig) GetLogFileIO() (file *os.File) {
-	if ac.Logger.OutFile != "" {
-		file, _ = os.OpenFile(ac.Logger.OutFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+// GetLogFileIO, the log file opened for appending, nil when logging to stderr
+func (ac *AppConfig) GetLogFileIO() (*os.File, error) {
+	if ac.Logger.OutFile == "" {
+		return nil, nil
 	}
-	return
+	return os.OpenFile(ac.Logger.OutFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
 }
 
-func LoadConfig() {
-	// Loading application configs, it is env specific. Right now, there is only one type of config
+// BaseURL, URL the server is reached at by clients, the public base URL when configured
+func (ac *AppConfig) BaseURL() string {
+	if ac.Server.PublicBaseURL != "" {
+		return strings.TrimSuffix(ac.Server.PublicBaseURL, "/")
+	}
+	return fmt.Sprintf("http://%s:%d", ac.Server.Host, ac.Server.Port)
+}
 
-	if Config == nil {
-		viper.AddConfigPath("./cmd/app/configs")
-		viper.SetConfigName("appConfig") // Register configs file name (no extension)
-		viper.SetConfigType("json")      // Look for specific type
+// LoadConfig, loading Config once from the defaults, the config file, the environment and flags, each
+// overriding the one before. flags may be nil. Exits listing every problem when the config is invalid.
+func LoadConfig(flags *Flags) {
+	if Config != nil {
+		return
+	}
+	config, err := Load(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	Config = config
+	current.Store(config)
+}
+
+// Load, the config as LoadConfig loads it, without setting Config. An invalid config is returned along
+// with a *ValidationError, other errors (e.g. an unreadable config file) come without config.
+func Load(flags *Flags) (*AppConfig, error) {
+	if flags == nil {
+		flags = &Flags{}
+	}
+	for key, value := range defaults {
+		viper.SetDefault(key, value)
+	}
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	for key, alias := range envAliases {
+		if err := viper.BindEnv(key, envName(key), alias); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := readConfigFile(flags.ConfigFile); err != nil {
+		return nil, err
+	}
+	for _, key := range unknownKeys(viper.AllKeys()) {
+		log.Warn(fmt.Sprintf("Unknown config key ignored!! key: %s, file: %s", key, viper.ConfigFileUsed()))
+	}
+
+	var problems []string
+	for _, override := range flags.Overrides {
+		key, value, _ := strings.Cut(override, "=")
+		key = strings.TrimSpace(key)
+		if isMapEntry(strings.ToLower(key)) {
+			problems = append(problems, fmt.Sprintf("%s: map entries can only be set in the config file", key))
+			continue
+		}
+		if len(unknownKeys([]string{key})) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: unknown config key given to -set", key))
+			continue
+		}
+		viper.Set(key, value)
+	}
+	problems = append(problems, checkTypes()...)
+
+	config, readProblems := readConfig()
+	problems = append(problems, readProblems...)
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return config, &ValidationError{Problems: problems}
+	}
+	return config, nil
+}
+
+// ConfigFileUsed, path of the config file Config was read from, empty when none was found
+func ConfigFileUsed() string {
+	return viper.ConfigFileUsed()
+}
+
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// readConfigFile, reading the named config file or, without a name, the first appConfig file found in
+// configSearchPaths. Without either the config comes from the defaults and the environment alone.
+func readConfigFile(path string) error {
+	if path == "" {
+		path = os.Getenv(configFileEnv)
+	}
+	if path != "" {
+		viper.SetConfigFile(path)
 		if err := viper.ReadInConfig(); err != nil {
-			log.Fatal("Config loading failed!!")
+			return fmt.Errorf("reading config file %s failed: %w", path, err)
+		}
+		return nil
+	}
+
+	viper.SetConfigName("appConfig") // Register configs file name (no extension)
+	for _, dir := range configSearchPaths {
+		viper.AddConfigPath(dir)
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return fmt.Errorf("reading config file failed: %w", err)
 		}
-		Config = &AppConfig{}
-		Config.DB.URI = os.Getenv("MONGODB_URI")
-		Config.DB.PoolSize = uint64(viper.Get("db.mongoDB.poolSize").(float64))
-		Config.DB.Collections.VideoFilesColl = viper.Get("db.mongoDB.collections.videFilesCollection").(string)
-		Config.DB.Collections.VideoCatalogueColl = viper.Get("db.mongoDB.collections.videoCatalogueCollection").(string)
-		Config.DB.DBs.VideoCatalogueDB = viper.Get("db.mongoDB.dbs.videoCatalogueDB").(string)
-		Config.Logger.OutFile = viper.Get("logger.outfile").(string)
-		Config.Logger.Level = viper.Get("logger.level").(string)
+		log.Warn(fmt.Sprintf("No config file found, using defaults and environment!! searched: %s",
+			strings.Join(configSearchPaths, ", ")))
 	}
+	return nil
+}
+
+// readConfig, the typed config of the merged sources, values are known to convert after checkTypes. Every
+// section is read by a function of its own, in the order of AppConfig.
+func readConfig() (*AppConfig, []string) {
+	var problems []string
+	config := &AppConfig{}
+	readDB(config)
+	readLogger(config)
+	config.Ingest.FastStart = viper.GetBool("ingest.fastStart")
+	readScanner(config)
+	readEncryption(config)
+	readJobs(config)
+	readWebhooks(config)
+	readAuth(config)
+	readHealth(config)
+	readServer(config)
+	readUploads(config)
+	problems = append(problems, readQuotas(config)...)
+	config.RateLimits = readRateLimits()
+	config.BodyLimits = readBodyLimits()
+	config.CORS = readCORSPolicy()
+	readShares(config)
+	readMetrics(config)
+	readTracing(config)
+	readEvents(config)
+	return config, problems
+}
+
+// readDB, the Mongo connection, its databases, collections and operation deadlines
+func readDB(config *AppConfig) {
+	config.DB.URI = viper.GetString("db.mongoDB.uri")
+	config.DB.PoolSize = viper.GetUint64("db.mongoDB.poolSize")
+	config.DB.DBs.VideoCatalogueDB = viper.GetString("db.mongoDB.dbs.videoCatalogueDB")
+	config.DB.Collections.VideoCatalogueColl = viper.GetString("db.mongoDB.collections.videoCatalogueCollection")
+	config.DB.Collections.VideoFilesColl = viper.GetString("db.mongoDB.collections.videFilesCollection")
+	config.DB.Collections.JobsColl = viper.GetString("db.mongoDB.collections.jobsCollection")
+	config.DB.Collections.WebhooksColl = viper.GetString("db.mongoDB.collections.webhooksCollection")
+	config.DB.Collections.DeliveriesColl = viper.GetString("db.mongoDB.collections.webhookDeliveriesCollection")
+	config.DB.Collections.EventsColl = viper.GetString("db.mongoDB.collections.eventsCollection")
+	config.DB.Collections.CountersColl = viper.GetString("db.mongoDB.collections.countersCollection")
+	config.DB.Collections.APIKeysColl = viper.GetString("db.mongoDB.collections.apiKeysCollection")
+	config.DB.Collections.UsageColl = viper.GetString("db.mongoDB.collections.usageCollection")
+	config.DB.Collections.SharesColl = viper.GetString("db.mongoDB.collections.sharesCollection")
+	config.DB.Collections.DataKeysColl = viper.GetString("db.mongoDB.collections.dataKeysCollection")
+	config.DB.UseTransactions = viper.GetBool("db.mongoDB.transactions")
+	config.DB.TenantIsolation = viper.GetString("db.mongoDB.tenantIsolation")
+	config.DB.Timeouts.Read = time.Duration(viper.GetInt("db.mongoDB.timeouts.readMs")) * time.Millisecond
+	config.DB.Timeouts.Write = time.Duration(viper.GetInt("db.mongoDB.timeouts.writeMs")) * time.Millisecond
+	config.DB.Timeouts.Upload = time.Duration(viper.GetInt("db.mongoDB.timeouts.uploadMs")) * time.Millisecond
+	config.DB.Timeouts.Download = time.Duration(viper.GetInt("db.mongoDB.timeouts.downloadMs")) * time.Millisecond
+}
+
+func readLogger(config *AppConfig) {
+	config.Logger.OutFile = viper.GetString("logger.outfile")
+	config.Logger.Level = viper.GetString("logger.level")
+}
+
+func readScanner(config *AppConfig) {
+	config.Scanner.Enabled = viper.GetBool("scanner.enabled")
+	config.Scanner.Address = viper.GetString("scanner.address")
+	config.Scanner.Timeout = time.Duration(viper.GetInt("scanner.timeoutSeconds")) * time.Second
+	config.Scanner.FailOpen = viper.GetBool("scanner.failOpen")
+}
+
+func readEncryption(config *AppConfig) {
+	config.Encryption.Enabled = viper.GetBool("encryption.enabled")
+	config.Encryption.Provider = viper.GetString("encryption.provider")
+	config.Encryption.MasterKeys = viper.GetString("encryption.masterKeys")
+	config.Encryption.ActiveKeyId = viper.GetString("encryption.activeKeyId")
+	config.Encryption.KeyringFile = viper.GetString("encryption.keyringFile")
+	config.Encryption.ChunkSize = viper.GetInt("encryption.chunkSize")
+}
+
+func readJobs(config *AppConfig) {
+	config.Jobs.Workers = viper.GetInt("jobs.workers")
+	config.Jobs.LeaseDuration = time.Duration(viper.GetInt("jobs.leaseSeconds")) * time.Second
+	config.Jobs.PollInterval = time.Duration(viper.GetInt("jobs.pollIntervalMs")) * time.Millisecond
+	config.Jobs.MaxAttempts = viper.GetInt("jobs.maxAttempts")
+	config.Jobs.BackoffBase = time.Duration(viper.GetInt("jobs.backoffBaseSeconds")) * time.Second
+	config.Jobs.BackoffMax = time.Duration(viper.GetInt("jobs.backoffMaxSeconds")) * time.Second
+	config.Jobs.PostUpload = viper.GetStringSlice("jobs.postUpload")
+}
+
+func readWebhooks(config *AppConfig) {
+	config.Webhooks.MaxAttempts = viper.GetInt("webhooks.maxAttempts")
+	config.Webhooks.Timeout = time.Duration(viper.GetInt("webhooks.timeoutSeconds")) * time.Second
+	config.Webhooks.DeliveryLogLimit = viper.GetInt64("webhooks.deliveryLogLimit")
+	config.Webhooks.AllowPrivate = viper.GetBool("webhooks.allowPrivateNetworks")
+}
+
+func readAuth(config *AppConfig) {
+	config.Auth.Enabled = viper.GetBool("auth.enabled")
+	config.Auth.JWT.Enabled = viper.GetBool("auth.jwt.enabled")
+	config.Auth.JWT.JWKSURL = viper.GetString("auth.jwt.jwksURL")
+	config.Auth.JWT.JWKSFile = viper.GetString("auth.jwt.jwksFile")
+	config.Auth.JWT.JWKSRefresh = time.Duration(viper.GetInt("auth.jwt.jwksRefreshMinutes")) * time.Minute
+	config.Auth.JWT.Issuer = viper.GetString("auth.jwt.issuer")
+	config.Auth.JWT.Audience = viper.GetString("auth.jwt.audience")
+	config.Auth.JWT.Leeway = time.Duration(viper.GetInt("auth.jwt.leewaySeconds")) * time.Second
+	config.Auth.JWT.SubjectClaim = viper.GetString("auth.jwt.claims.subject")
+	config.Auth.JWT.NameClaim = viper.GetString("auth.jwt.claims.name")
+	config.Auth.JWT.RolesClaim = viper.GetString("auth.jwt.claims.roles")
+	config.Auth.JWT.ScopeClaim = viper.GetString("auth.jwt.claims.scope")
+	config.Auth.JWT.GroupsClaim = viper.GetString("auth.jwt.claims.groups")
+	config.Auth.JWT.TenantClaim = viper.GetString("auth.jwt.claims.tenant")
+	config.Auth.JWT.RoleScopes = viper.GetStringMapStringSlice("auth.jwt.roleScopes")
+}
+
+func readHealth(config *AppConfig) {
+	config.Health.CheckTimeout = time.Duration(viper.GetInt("health.checkTimeoutMs")) * time.Millisecond
+	config.Health.MinFreeTempBytes = viper.GetUint64("health.minFreeTempBytes")
+}
 
+func readServer(config *AppConfig) {
+	config.Server.Host = viper.GetString("server.host")
+	config.Server.Port = viper.GetInt("server.port")
+	config.Server.PublicBaseURL = viper.GetString("server.publicBaseURL")
+	config.Server.TrustedProxies = viper.GetStringSlice("server.trustedProxies")
+	config.Server.DrainPeriod = time.Duration(viper.GetInt("server.drainSeconds")) * time.Second
+	config.Server.GracePeriod = time.Duration(viper.GetInt("server.gracePeriodSeconds")) * time.Second
+}
+
+func readUploads(config *AppConfig) {
+	config.Uploads.ProgressRetention = time.Duration(viper.GetInt("uploads.progressRetentionSeconds")) * time.Second
+	config.Uploads.MediaTypes = viper.GetStringSlice("uploads.mediaTypes")
+}
+
+// readQuotas, the default limits and the ones of single tenants, whose map can fail to parse
+func readQuotas(config *AppConfig) []string {
+	config.Quotas.Tenant.MaxBytes = viper.GetInt64("quotas.tenant.maxBytes")
+	config.Quotas.Tenant.MaxFiles = viper.GetInt64("quotas.tenant.maxFiles")
+	config.Quotas.User.MaxBytes = viper.GetInt64("quotas.user.maxBytes")
+	config.Quotas.User.MaxFiles = viper.GetInt64("quotas.user.maxFiles")
+	if err := viper.UnmarshalKey("quotas.tenants", &config.Quotas.Tenants); err != nil {
+		return []string{fmt.Sprintf("quotas.tenants: %s", err.Error())}
+	}
+	return nil
+}
+
+func readShares(config *AppConfig) {
+	config.Shares.Enabled = viper.GetBool("shares.enabled")
+	config.Shares.SigningKey = viper.GetString("shares.signingKey")
+	config.Shares.DefaultTTL = time.Duration(viper.GetInt("shares.defaultTTLSeconds")) * time.Second
+	config.Shares.MaxTTL = time.Duration(viper.GetInt("shares.maxTTLSeconds")) * time.Second
+}
+
+func readMetrics(config *AppConfig) {
+	config.Metrics.Enabled = viper.GetBool("metrics.enabled")
+	config.Metrics.Path = viper.GetString("metrics.path")
+}
+
+func readTracing(config *AppConfig) {
+	config.Tracing.Enabled = viper.GetBool("tracing.enabled")
+	config.Tracing.ServiceName = viper.GetString("tracing.serviceName")
+	config.Tracing.Exporter = viper.GetString("tracing.exporter")
+	config.Tracing.Endpoint = viper.GetString("tracing.endpoint")
+	config.Tracing.Insecure = viper.GetBool("tracing.insecure")
+	config.Tracing.SampleRatio = viper.GetFloat64("tracing.sampleRatio")
+}
+
+func readEvents(config *AppConfig) {
+	config.Events.PollInterval = time.Duration(viper.GetInt("events.pollIntervalMs")) * time.Millisecond
+	config.Events.ClaimFor = time.Duration(viper.GetInt("events.claimSeconds")) * time.Second
+}
+
+// readRateLimits, rate limits of the merged config sources
+func readRateLimits() models.RateLimits {
+	return models.RateLimits{
+		RequestsPerSecond:       viper.GetFloat64("rateLimits.requestsPerSecond"),
+		RequestBurst:            viper.GetInt("rateLimits.requestBurst"),
+		ClientRequestsPerSecond: viper.GetFloat64("rateLimits.clientRequestsPerSecond"),
+		ClientRequestBurst:      viper.GetInt("rateLimits.clientRequestBurst"),
+		BytesPerSecond:          viper.GetInt64("rateLimits.bytesPerSecond"),
+		BytesBurst:              viper.GetInt64("rateLimits.bytesBurst"),
+		MaxConcurrentUploads:    viper.GetInt("rateLimits.maxConcurrentUploads"),
+		UploadRetryAfter:        time.Duration(viper.GetInt("rateLimits.uploadRetryAfterSeconds")) * time.Second,
+	}
+}
+
+// readBodyLimits, request body limits of the merged config sources
+func readBodyLimits() models.BodyLimits {
+	bodyLimits := models.BodyLimits{
+		Default:      viper.GetInt64("bodyLimits.defaultBytes"),
+		Routes:       readLimits("bodyLimits.routes"),
+		MimeTypes:    readLimits("bodyLimits.mimeTypes"),
+		MinFileBytes: viper.GetInt64("bodyLimits.minFileBytes"),
+	}
+	return bodyLimits
+}
+
+// readCORSPolicy, CORS policy of the merged config sources
+func readCORSPolicy() models.CORSPolicy {
+	return models.CORSPolicy{
+		AllowedOrigins:   viper.GetStringSlice("cors.allowedOrigins"),
+		AllowedMethods:   viper.GetStringSlice("cors.allowedMethods"),
+		AllowedHeaders:   viper.GetStringSlice("cors.allowedHeaders"),
+		ExposedHeaders:   viper.GetStringSlice("cors.exposedHeaders"),
+		MaxAge:           time.Duration(viper.GetInt("cors.maxAgeSeconds")) * time.Second,
+		AllowCredentials: viper.GetBool("cors.allowCredentials"),
+	}
+}
+
+// readLimits, limits of the map under key by lower-cased name. The names are taken from the map as a
+// whole, looked up one by one viper would read the dots of names like "video/vnd.dlna.mpeg-tts" as nesting.
+func readLimits(key string) map[string]int64 {
+	limits := map[string]int64{}
+	if err := viper.UnmarshalKey(key, &limits); err != nil {
+		log.Error(fmt.Sprintf("Parsing %s failed!! Error: %s", key, err.Error()))
+	}
+	lowered := make(map[string]int64, len(limits))
+	for name, limit := range limits {
+		lowered[strings.ToLower(name)] = limit
+	}
+	return lowered
 }
@@ -0,0 +1,42 @@
+package configs
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+)
+
+func TestReadBodyLimitsKeepsDottedNames(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("json")
+	err := viper.ReadConfig(strings.NewReader(`{
+		"bodyLimits" : {
+			"defaultBytes" : 100,
+			"routes" : { "POST /v1/files" : 2000, "PUT /v1/files/:fileid/acl.json" : 300 },
+			"mimeTypes" : { "video/mp4" : 1000, "Video/vnd.dlna.mpeg-tts" : 500 }
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bodyLimits := readBodyLimits()
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"route", bodyLimits.RouteLimit("POST", "/v1/files"), 2000},
+		{"route with dot", bodyLimits.RouteLimit("PUT", "/v1/files/:fileid/acl.json"), 300},
+		{"route without limit", bodyLimits.RouteLimit("GET", "/v1/files"), 100},
+		{"media type", bodyLimits.MimeTypeLimit("video/mp4"), 1000},
+		{"media type with dots", bodyLimits.MimeTypeLimit("video/vnd.dlna.mpeg-tts"), 500},
+		{"media type without limit", bodyLimits.MimeTypeLimit("video/mpeg"), 0},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: limit %d, expected %d", test.name, test.got, test.want)
+		}
+	}
+}
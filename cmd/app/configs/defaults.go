@@ -0,0 +1,132 @@
+package configs
+
+// defaults, every config key with the value it has when neither the config file, the environment nor a
+// flag sets it. A key missing here can't be set from the environment or with -set, and its type is what
+// the value found in those sources is checked against.
+var defaults = map[string]interface{}{
+	"db.mongoDB.uri":                                     "",
+	"db.mongoDB.poolSize":                                5,
+	"db.mongoDB.dbs.videoCatalogueDB":                    "VideoCatalogueDB",
+	"db.mongoDB.collections.videoCatalogueCollection":    "VideoCatalogueColl",
+	"db.mongoDB.collections.videFilesCollection":         "fs.files",
+	"db.mongoDB.collections.jobsCollection":              "Jobs",
+	"db.mongoDB.collections.webhooksCollection":          "Webhooks",
+	"db.mongoDB.collections.webhookDeliveriesCollection": "WebhookDeliveries",
+	"db.mongoDB.collections.eventsCollection":            "Events",
+	"db.mongoDB.collections.countersCollection":          "Counters",
+	"db.mongoDB.collections.apiKeysCollection":           "APIKeys",
+	"db.mongoDB.collections.usageCollection":             "Usage",
+	"db.mongoDB.collections.sharesCollection":            "Shares",
+	"db.mongoDB.collections.dataKeysCollection":          "DataKeys",
+	"db.mongoDB.transactions":                            true,
+	"db.mongoDB.tenantIsolation":                         "bucket",
+	"db.mongoDB.timeouts.readMs":                         5000,
+	"db.mongoDB.timeouts.writeMs":                        10000,
+	"db.mongoDB.timeouts.uploadMs":                       120000,
+	"db.mongoDB.timeouts.downloadMs":                     120000,
+	"health.checkTimeoutMs":                              2000,
+	"health.minFreeTempBytes":                            512 << 20,
+	"server.host":                                        "localhost",
+	"server.port":                                        8080,
+	"server.publicBaseURL":                               "",
+	"server.trustedProxies":                              []string{},
+	"server.drainSeconds":                                5,
+	"server.gracePeriodSeconds":                          30,
+	"logger.outfile":                                     "",
+	"logger.level":                                       "info",
+	"ingest.fastStart":                                   false,
+	"scanner.enabled":                                    false,
+	"scanner.address":                                    "",
+	"scanner.timeoutSeconds":                             120,
+	"scanner.failOpen":                                   false,
+	"encryption.enabled":                                 false,
+	"encryption.provider":                                "static",
+	"encryption.masterKeys":                              "",
+	"encryption.activeKeyId":                             "",
+	"encryption.keyringFile":                             "",
+	"encryption.chunkSize":                               64 << 10,
+	"jobs.workers":                                       0,
+	"jobs.leaseSeconds":                                  60,
+	"jobs.pollIntervalMs":                                1000,
+	"jobs.maxAttempts":                                   5,
+	"jobs.backoffBaseSeconds":                            5,
+	"jobs.backoffMaxSeconds":                             600,
+	"jobs.postUpload":                                    []string{},
+	"webhooks.maxAttempts":                               8,
+	"webhooks.timeoutSeconds":                            10,
+	"webhooks.deliveryLogLimit":                          100,
+	"webhooks.allowPrivateNetworks":                      false,
+	"auth.enabled":                                       true,
+	"auth.jwt.enabled":                                   false,
+	"auth.jwt.jwksURL":                                   "",
+	"auth.jwt.jwksFile":                                  "",
+	"auth.jwt.jwksRefreshMinutes":                        60,
+	"auth.jwt.issuer":                                    "",
+	"auth.jwt.audience":                                  "",
+	"auth.jwt.leewaySeconds":                             30,
+	"auth.jwt.claims.subject":                            "sub",
+	"auth.jwt.claims.name":                               "preferred_username",
+	"auth.jwt.claims.roles":                              "roles",
+	"auth.jwt.claims.scope":                              "scope",
+	"auth.jwt.claims.groups":                             "groups",
+	"auth.jwt.claims.tenant":                             "tenant",
+	"uploads.progressRetentionSeconds":                   120,
+	"uploads.mediaTypes":                                 []string{"video/mp4", "video/mpeg"},
+	"cors.allowedOrigins":                                []string{"*"},
+	"cors.allowedMethods":                                []string{"GET", "POST", "PUT", "DELETE"},
+	"cors.allowedHeaders":                                corsAllowedHeaders,
+	"cors.exposedHeaders":                                []string{"Location", "Content-Disposition", "X-Request-ID", "Retry-After"},
+	"cors.maxAgeSeconds":                                 600,
+	"cors.allowCredentials":                              false,
+	"quotas.tenant.maxBytes":                             0,
+	"quotas.tenant.maxFiles":                             0,
+	"quotas.user.maxBytes":                               0,
+	"quotas.user.maxFiles":                               0,
+	"shares.enabled":                                     false,
+	"shares.signingKey":                                  "",
+	"shares.defaultTTLSeconds":                           3600,
+	"shares.maxTTLSeconds":                               604800,
+	"rateLimits.requestsPerSecond":                       0.0,
+	"rateLimits.requestBurst":                            1,
+	"rateLimits.clientRequestsPerSecond":                 0.0,
+	"rateLimits.clientRequestBurst":                      0,
+	"rateLimits.bytesPerSecond":                          0,
+	"rateLimits.bytesBurst":                              0,
+	"rateLimits.maxConcurrentUploads":                    0,
+	"rateLimits.uploadRetryAfterSeconds":                 5,
+	"bodyLimits.defaultBytes":                            1 << 20,
+	"bodyLimits.minFileBytes":                            0,
+	"metrics.enabled":                                    false,
+	"metrics.path":                                       "/metrics",
+	"tracing.enabled":                                    false,
+	"tracing.serviceName":                                "city_os",
+	"tracing.exporter":                                   "stdout",
+	"tracing.endpoint":                                   "",
+	"tracing.insecure":                                   false,
+	"tracing.sampleRatio":                                1.0,
+	"events.pollIntervalMs":                              500,
+	"events.claimSeconds":                                30,
+}
+
+// corsAllowedHeaders, request headers the API reads besides the CORS-safelisted ones
+var corsAllowedHeaders = []string{"Content-Type", "Authorization", "X-API-Key", "X-Upload-ID", "X-Request-ID",
+	"Content-Disposition", "Cache-Control", "X-Requested-With", "X-CSRF-Token", "traceparent"}
+
+// mapKeys, keys holding maps whose entries are named by the config file, e.g. quotas.tenants.<tenant>.
+// Their entries are only read from the file.
+var mapKeys = []string{
+	"quotas.tenants",
+	"auth.jwt.roleScopes",
+	"bodyLimits.routes",
+	"bodyLimits.mimeTypes",
+}
+
+// envAliases, environment variables read on top of the CITY_OS_<KEY> ones, kept from before the config
+// could be set from the environment. Secrets are meant to come from here rather than from the file.
+var envAliases = map[string]string{
+	"db.mongoDB.uri":        "MONGODB_URI",
+	"shares.signingKey":     "SHARE_SIGNING_KEY",
+	"encryption.masterKeys": "ENCRYPTION_MASTER_KEYS",
+	"server.host":           "HOST",
+	"server.port":           "PORT",
+}
@@ -0,0 +1,28 @@
+package configs
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Flags, command line flags of the config, taking precedence over the config file and the environment
+type Flags struct {
+	ConfigFile string   // Path of the config file, CITY_OS_CONFIG env when empty
+	Overrides  []string // key=value pairs of -set
+}
+
+// RegisterFlags, adding -config and -set to fs, the values are read by LoadConfig once fs is parsed
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{}
+	fs.StringVar(&flags.ConfigFile, "config", "", "path of the config file (json or yaml), CITY_OS_CONFIG env when empty")
+	fs.Func("set", "config key=value overriding the file and environment, e.g. -set jobs.workers=4, repeatable",
+		func(value string) error {
+			if key, _, found := strings.Cut(value, "="); !found || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("expected key=value, got %q", value)
+			}
+			flags.Overrides = append(flags.Overrides, value)
+			return nil
+		})
+	return flags
+}
@@ -0,0 +1,69 @@
+package configs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"text/tabwriter"
+)
+
+// redacted, printed instead of secrets that are set
+const redacted = "<redacted>"
+
+// Print, writing every setting of the config with its effective value, one per line. Fields tagged
+// `secret` are redacted, connection strings keep everything but their password.
+func (ac *AppConfig) Print(w io.Writer) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range ac.settings() {
+		fmt.Fprintf(writer, "%s\t%s\n", s.name, s.value)
+	}
+	return writer.Flush()
+}
+
+// setting, a leaf field of the config named by its path, e.g. DB.Timeouts.Read, with its redacted value
+type setting struct {
+	name  string
+	value string
+	raw   interface{} // compared on reload, secrets included
+}
+
+func (ac *AppConfig) settings() []setting {
+	return appendSettings(nil, "", reflect.ValueOf(ac).Elem())
+}
+
+func appendSettings(settings []setting, prefix string, value reflect.Value) []setting {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := prefix + field.Name
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Struct {
+			settings = appendSettings(settings, name+".", fieldValue)
+			continue
+		}
+		settings = append(settings, setting{
+			name:  name,
+			value: formatValue(fieldValue, field.Tag.Get("secret")),
+			raw:   fieldValue.Interface(),
+		})
+	}
+	return settings
+}
+
+func formatValue(value reflect.Value, secret string) string {
+	switch {
+	case secret == "" && value.Kind() == reflect.String:
+		return fmt.Sprintf("%q", value.String())
+	case secret == "":
+		return fmt.Sprintf("%v", value.Interface())
+	case value.String() == "":
+		return `""`
+	case secret == "url":
+		if uri, err := url.Parse(value.String()); err == nil {
+			return fmt.Sprintf("%q", uri.Redacted())
+		}
+		return redacted
+	default:
+		return redacted
+	}
+}
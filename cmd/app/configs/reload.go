@@ -0,0 +1,128 @@
+package configs
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// reloadable, settings applied to running components on reload, named as in Print. A name ending in a
+// dot covers the whole section.
+var reloadable = []string{"Logger.Level", "Uploads.MediaTypes", "CORS.", "RateLimits.", "BodyLimits."}
+
+// current, Config with the reloadable settings of the last applied reload
+var current atomic.Pointer[AppConfig]
+
+// Current, the config in effect: Config with the reloadable settings of the last applied reload. A reload
+// replaces it as a whole, so the settings of one Current call are always consistent with each other.
+func Current() *AppConfig {
+	if config := current.Load(); config != nil {
+		return config
+	}
+	return Config
+}
+
+// Reload, outcome of re-reading the config file
+type Reload struct {
+	Config  *AppConfig // Config in effect afterwards
+	Applied []string   // Reloadable settings that changed, "<setting>: <old> -> <new>"
+	Ignored []string   // Other settings that changed, they take effect on restart
+	Err     error      // Why the reload was rejected, nothing was applied then
+}
+
+var (
+	reloadMu sync.Mutex
+	onReload func(Reload)
+)
+
+// WatchConfig, reloading the config whenever the config file is written and on SIGHUP. Every reload is
+// passed to onChange, applied or not. A config file that fails to read or validate is rejected as a
+// whole and the config in effect stays as it is.
+func WatchConfig(onChange func(Reload)) {
+	reloadMu.Lock()
+	onReload = onChange
+	reloadMu.Unlock()
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+	viper.OnConfigChange(func(fsnotify.Event) {
+		reloadConfig()
+	})
+	viper.WatchConfig()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reloadConfig()
+		}
+	}()
+}
+
+// reloadConfig, re-reading the config file and passing the outcome to onReload
+func reloadConfig() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	result := reload()
+	if onReload != nil {
+		onReload(result)
+	}
+}
+
+func reload() Reload {
+	inEffect := Current()
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return Reload{Config: inEffect, Err: fmt.Errorf("reading config file %s failed: %w", viper.ConfigFileUsed(), err)}
+		}
+	}
+	problems := checkTypes()
+	config, readProblems := readConfig()
+	problems = append(problems, readProblems...)
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return Reload{Config: inEffect, Err: &ValidationError{Problems: problems}}
+	}
+
+	result := Reload{Config: inEffect}
+	previous, startup := inEffect.settings(), Config.settings()
+	for i, s := range config.settings() {
+		switch {
+		case isReloadable(s.name):
+			if !reflect.DeepEqual(previous[i].raw, s.raw) {
+				result.Applied = append(result.Applied, fmt.Sprintf("%s: %s -> %s", s.name, previous[i].value, s.value))
+			}
+		case !reflect.DeepEqual(startup[i].raw, s.raw):
+			result.Ignored = append(result.Ignored, s.name)
+		}
+	}
+	if len(result.Applied) == 0 {
+		return result
+	}
+
+	next := *Config
+	next.Logger.Level = config.Logger.Level
+	next.Uploads.MediaTypes = config.Uploads.MediaTypes
+	next.CORS = config.CORS
+	next.RateLimits = config.RateLimits
+	next.BodyLimits = config.BodyLimits
+	current.Store(&next)
+	result.Config = &next
+	return result
+}
+
+func isReloadable(name string) bool {
+	for _, r := range reloadable {
+		if name == r || strings.HasSuffix(r, ".") && strings.HasPrefix(name, r) {
+			return true
+		}
+	}
+	return false
+}
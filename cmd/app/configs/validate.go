@@ -0,0 +1,313 @@
+package configs
+
+import (
+	"city_os/src/models"
+	"fmt"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minShareSigningKeyLength, shortest shares.signingKey accepted, 32 characters of a random key are
+// 128 bits or more
+const minShareSigningKeyLength = 32
+
+// ValidationError, every problem found in the config, reported together so that they can be fixed at once
+type ValidationError struct {
+	Problems []string // "<config key>: <what is wrong>"
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// checkTypes, problems of values that can't be converted to the type of their default. viper reads them
+// as the zero value otherwise, e.g. a typo in CITY_OS_JOBS_WORKERS would silently disable the workers.
+func checkTypes() []string {
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		value := viper.Get(key)
+		var err error
+		var expected string
+		switch defaults[key].(type) {
+		case int:
+			_, err = cast.ToInt64E(value)
+			expected = "an integer"
+		case float64:
+			_, err = cast.ToFloat64E(value)
+			expected = "a number"
+		case bool:
+			_, err = cast.ToBoolE(value)
+			expected = "true or false"
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v is not %s", key, value, expected))
+		}
+	}
+	return problems
+}
+
+// unknownKeys, keys set in the config file or with -set that no setting reads, mostly typos
+func unknownKeys(keys []string) []string {
+	known := map[string]bool{}
+	for key := range defaults {
+		known[strings.ToLower(key)] = true
+	}
+	for _, key := range mapKeys {
+		known[strings.ToLower(key)] = true
+	}
+	var unknown []string
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if known[key] || isMapEntry(key) {
+			continue
+		}
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func isMapEntry(key string) bool {
+	for _, mapKey := range mapKeys {
+		if strings.HasPrefix(key, strings.ToLower(mapKey)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// validate, problems of settings the services can't start with
+func (ac *AppConfig) validate() []string {
+	var problems []string
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(value string, allowed ...string) bool {
+		for _, a := range allowed {
+			if value == a {
+				return true
+			}
+		}
+		return false
+	}
+	notNegative := func(key string, d time.Duration) {
+		check(d >= 0, key, "must not be negative")
+	}
+
+	check(ac.DB.URI != "", "db.mongoDB.uri", "required, set MONGODB_URI")
+	if ac.DB.URI != "" {
+		uri, err := url.Parse(ac.DB.URI)
+		check(err == nil && (uri.Scheme == "mongodb" || uri.Scheme == "mongodb+srv"), "db.mongoDB.uri",
+			"must be a mongodb:// or mongodb+srv:// connection string")
+	}
+	check(ac.DB.PoolSize > 0, "db.mongoDB.poolSize", "must be at least 1")
+	check(ac.DB.DBs.VideoCatalogueDB != "", "db.mongoDB.dbs.videoCatalogueDB", "required")
+	for key, collection := range map[string]string{
+		"videoCatalogueCollection":    ac.DB.Collections.VideoCatalogueColl,
+		"videFilesCollection":         ac.DB.Collections.VideoFilesColl,
+		"jobsCollection":              ac.DB.Collections.JobsColl,
+		"webhooksCollection":          ac.DB.Collections.WebhooksColl,
+		"webhookDeliveriesCollection": ac.DB.Collections.DeliveriesColl,
+		"eventsCollection":            ac.DB.Collections.EventsColl,
+		"countersCollection":          ac.DB.Collections.CountersColl,
+		"apiKeysCollection":           ac.DB.Collections.APIKeysColl,
+		"usageCollection":             ac.DB.Collections.UsageColl,
+		"sharesCollection":            ac.DB.Collections.SharesColl,
+		"dataKeysCollection":          ac.DB.Collections.DataKeysColl,
+	} {
+		check(collection != "", "db.mongoDB.collections."+key, "required")
+	}
+	// Without transactions outbox events can become visible out of sequence order and a change feed
+	// consumer resuming from its cursor would skip the late ones
+	check(ac.DB.UseTransactions, "db.mongoDB.transactions", "false is not supported, the event outbox needs a replica set with transactions")
+	check(oneOf(ac.DB.TenantIsolation, "bucket", "prefix"), "db.mongoDB.tenantIsolation",
+		"must be bucket or prefix, got %q", ac.DB.TenantIsolation)
+	notNegative("db.mongoDB.timeouts.readMs", ac.DB.Timeouts.Read)
+	notNegative("db.mongoDB.timeouts.writeMs", ac.DB.Timeouts.Write)
+	notNegative("db.mongoDB.timeouts.uploadMs", ac.DB.Timeouts.Upload)
+	notNegative("db.mongoDB.timeouts.downloadMs", ac.DB.Timeouts.Download)
+
+	check(oneOf(ac.Logger.Level, "debug", "info", "warn", "error"), "logger.level",
+		"must be debug, info, warn or error, got %q", ac.Logger.Level)
+
+	check(ac.Server.Host != "", "server.host", "required, set HOST")
+	check(ac.Server.Port > 0 && ac.Server.Port <= 65535, "server.port", "must be between 1 and 65535, got %d", ac.Server.Port)
+	if ac.Server.PublicBaseURL != "" {
+		baseURL, err := url.Parse(ac.Server.PublicBaseURL)
+		check(err == nil && (baseURL.Scheme == "http" || baseURL.Scheme == "https") && baseURL.Host != "" &&
+			baseURL.RawQuery == "" && baseURL.Fragment == "", "server.publicBaseURL",
+			"must be an http(s)://host[:port][/path] URL, got %q", ac.Server.PublicBaseURL)
+	}
+	for _, proxy := range ac.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "server.trustedProxies", "%q is not an IP address or CIDR", proxy)
+	}
+	notNegative("server.drainSeconds", ac.Server.DrainPeriod)
+	notNegative("server.gracePeriodSeconds", ac.Server.GracePeriod)
+	check(ac.Health.CheckTimeout > 0, "health.checkTimeoutMs", "must be positive")
+
+	if ac.Scanner.Enabled {
+		check(strings.HasPrefix(ac.Scanner.Address, "tcp://") || strings.HasPrefix(ac.Scanner.Address, "unix://"),
+			"scanner.address", "must be tcp://host:port or unix:///path when the scanner is enabled")
+		check(ac.Scanner.Timeout > 0, "scanner.timeoutSeconds", "must be positive")
+	}
+
+	check(oneOf(ac.Encryption.Provider, "static", "localkms"), "encryption.provider",
+		"must be static or localkms, got %q", ac.Encryption.Provider)
+	check(ac.Encryption.ChunkSize > 0, "encryption.chunkSize", "must be positive")
+	if ac.Encryption.Enabled && ac.Encryption.Provider == "static" {
+		check(ac.Encryption.MasterKeys != "", "encryption.masterKeys", "required by the static provider, set ENCRYPTION_MASTER_KEYS")
+		check(ac.Encryption.ActiveKeyId != "", "encryption.activeKeyId", "required by the static provider")
+	}
+	if ac.Encryption.Provider == "localkms" {
+		check(ac.Encryption.KeyringFile != "", "encryption.keyringFile", "required by the localkms provider")
+	}
+
+	check(ac.Jobs.Workers >= 0, "jobs.workers", "must not be negative")
+	check(ac.Jobs.LeaseDuration > 0, "jobs.leaseSeconds", "must be positive")
+	check(ac.Jobs.PollInterval > 0, "jobs.pollIntervalMs", "must be positive")
+	check(ac.Jobs.MaxAttempts > 0, "jobs.maxAttempts", "must be at least 1")
+	notNegative("jobs.backoffBaseSeconds", ac.Jobs.BackoffBase)
+	check(ac.Jobs.BackoffMax >= ac.Jobs.BackoffBase, "jobs.backoffMaxSeconds", "must not be less than jobs.backoffBaseSeconds")
+	for _, jobType := range ac.Jobs.PostUpload {
+		check(oneOf(jobType, models.JobTypeProbe, models.JobTypeRehash), "jobs.postUpload",
+			"unknown job type %q, expected %s or %s", jobType, models.JobTypeProbe, models.JobTypeRehash)
+	}
+
+	check(ac.Webhooks.MaxAttempts > 0, "webhooks.maxAttempts", "must be at least 1")
+	check(ac.Webhooks.Timeout > 0, "webhooks.timeoutSeconds", "must be positive")
+	check(ac.Webhooks.DeliveryLogLimit > 0, "webhooks.deliveryLogLimit", "must be positive")
+
+	if ac.Auth.JWT.Enabled {
+		check(ac.Auth.JWT.JWKSURL != "" || ac.Auth.JWT.JWKSFile != "", "auth.jwt.jwksURL", "jwksURL or jwksFile required when JWT is enabled")
+		check(ac.Auth.JWT.JWKSRefresh > 0, "auth.jwt.jwksRefreshMinutes", "must be positive")
+		notNegative("auth.jwt.leewaySeconds", ac.Auth.JWT.Leeway)
+	}
+
+	notNegative("uploads.progressRetentionSeconds", ac.Uploads.ProgressRetention)
+	check(len(ac.Uploads.MediaTypes) > 0, "uploads.mediaTypes", "at least one media type required")
+	for _, mediaType := range ac.Uploads.MediaTypes {
+		mainType, subType, found := strings.Cut(mediaType, "/")
+		check(found && mainType != "" && subType != "", "uploads.mediaTypes", "%q is not a type/subtype media type", mediaType)
+	}
+	for _, origin := range ac.CORS.AllowedOrigins {
+		check(origin == "*" || isOrigin(origin) || isOriginPattern(origin), "cors.allowedOrigins",
+			"%q is not *, a scheme://host[:port] origin or a scheme://*.host[:port] pattern", origin)
+	}
+	check(!ac.CORS.AllowCredentials || !ac.CORS.AnyOrigin(), "cors.allowCredentials",
+		"browsers reject credentials with the * origin, list the allowed origins instead")
+	check(len(ac.CORS.AllowedMethods) > 0, "cors.allowedMethods", "at least one method required")
+	for _, method := range ac.CORS.AllowedMethods {
+		check(isToken(method) && method == strings.ToUpper(method), "cors.allowedMethods", "%q is not an upper case method", method)
+	}
+	for _, header := range append(append([]string{}, ac.CORS.AllowedHeaders...), ac.CORS.ExposedHeaders...) {
+		check(isToken(header), "cors", "%q is not a header name", header)
+	}
+	notNegative("cors.maxAgeSeconds", ac.CORS.MaxAge)
+
+	check(ac.Quotas.Tenant.MaxBytes >= 0 && ac.Quotas.Tenant.MaxFiles >= 0, "quotas.tenant", "limits must not be negative")
+	check(ac.Quotas.User.MaxBytes >= 0 && ac.Quotas.User.MaxFiles >= 0, "quotas.user", "limits must not be negative")
+	for tenant, limits := range ac.Quotas.Tenants {
+		check(limits.MaxBytes >= 0 && limits.MaxFiles >= 0, "quotas.tenants."+tenant, "limits must not be negative")
+	}
+
+	if ac.Shares.Enabled {
+		// anyone knowing the key can mint URLs for every file
+		check(len(ac.Shares.SigningKey) >= minShareSigningKeyLength, "shares.signingKey",
+			"at least %d characters required when shares are enabled, set SHARE_SIGNING_KEY or disable shares.enabled", minShareSigningKeyLength)
+	}
+	check(ac.Shares.DefaultTTL > 0, "shares.defaultTTLSeconds", "must be positive")
+	check(ac.Shares.MaxTTL >= ac.Shares.DefaultTTL, "shares.maxTTLSeconds", "must not be less than shares.defaultTTLSeconds")
+
+	problems = append(problems, validateRateLimits(ac)...)
+	problems = append(problems, validateBodyLimits(ac)...)
+
+	if ac.Metrics.Enabled {
+		check(strings.HasPrefix(ac.Metrics.Path, "/"), "metrics.path", "must start with /")
+	}
+	if ac.Tracing.Enabled {
+		check(oneOf(ac.Tracing.Exporter, "otlp", "stdout"), "tracing.exporter", "must be otlp or stdout, got %q", ac.Tracing.Exporter)
+		check(ac.Tracing.ServiceName != "", "tracing.serviceName", "required")
+	}
+	check(ac.Tracing.SampleRatio >= 0 && ac.Tracing.SampleRatio <= 1, "tracing.sampleRatio", "must be between 0 and 1")
+
+	check(ac.Events.PollInterval > 0, "events.pollIntervalMs", "must be positive")
+	check(ac.Events.ClaimFor > 0, "events.claimSeconds", "must be positive")
+
+	sort.Strings(problems)
+	return problems
+}
+
+func validateRateLimits(ac *AppConfig) []string {
+	var problems []string
+	limits := ac.RateLimits
+	if limits.RequestsPerSecond < 0 {
+		problems = append(problems, "rateLimits.requestsPerSecond: must not be negative")
+	}
+	if limits.RequestBurst < 0 {
+		problems = append(problems, "rateLimits.requestBurst: must not be negative")
+	}
+	if limits.BytesPerSecond < 0 {
+		problems = append(problems, "rateLimits.bytesPerSecond: must not be negative")
+	}
+	if limits.BytesBurst < 0 {
+		problems = append(problems, "rateLimits.bytesBurst: must not be negative")
+	}
+	if limits.MaxConcurrentUploads < 0 {
+		problems = append(problems, "rateLimits.maxConcurrentUploads: must not be negative")
+	}
+	return problems
+}
+
+func validateBodyLimits(ac *AppConfig) []string {
+	var problems []string
+	limits := ac.BodyLimits
+	if limits.Default < 0 {
+		problems = append(problems, "bodyLimits.defaultBytes: must not be negative")
+	}
+	if limits.MinFileBytes < 0 {
+		problems = append(problems, "bodyLimits.minFileBytes: must not be negative")
+	}
+	for route, limit := range limits.Routes {
+		if limit < 0 {
+			problems = append(problems, fmt.Sprintf("bodyLimits.routes.%s: must not be negative", route))
+		}
+	}
+	for mimeType, limit := range limits.MimeTypes {
+		if limit < 0 {
+			problems = append(problems, fmt.Sprintf("bodyLimits.mimeTypes.%s: must not be negative", mimeType))
+		}
+	}
+	return problems
+}
+
+// isOriginPattern, whether s is an origin with "*." in front of the host, matching its subdomains
+func isOriginPattern(s string) bool {
+	scheme, host, found := strings.Cut(s, "://*.")
+	return found && isOrigin(scheme+"://"+host) && !strings.Contains(host, "*")
+}
+
+// isToken, whether s is a non-empty HTTP token, as method and header names are
+func isToken(s string) bool {
+	return s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&'*+-.^_`|~") == ""
+}
+
+// isOrigin, whether s is an origin as browsers send it: scheme, host and optional port, nothing else
+func isOrigin(s string) bool {
+	origin, err := url.Parse(s)
+	return err == nil && (origin.Scheme == "http" || origin.Scheme == "https") && origin.Host != "" && !strings.Contains(s, "*") &&
+		origin.Path == "" && origin.RawQuery == "" && origin.Fragment == "" && origin.User == nil
+}
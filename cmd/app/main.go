@@ -5,19 +5,40 @@ import (
 	logger "city_os/src/common"
 	"city_os/src/controllers"
 	"city_os/src/dbconnectors"
+	"city_os/src/encryption"
 	"city_os/src/handlers"
+	"city_os/src/health"
+	"city_os/src/interfaces"
+	"city_os/src/jobs"
+	"city_os/src/jwt"
+	"city_os/src/metrics"
 	"city_os/src/middlewares"
+	"city_os/src/models"
+	"city_os/src/progress"
+	"city_os/src/ratelimit"
+	"city_os/src/scanner"
+	"city_os/src/shutdown"
+	"city_os/src/tracing"
 	"context"
+	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
 
-	// Loading application configs
-	configs.LoadConfig()
+	// Loading application configs, -config names the config file and -set overrides single keys
+	configFlags := configs.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	configs.LoadConfig(configFlags)
 
 	// Init global logger instance with set of common rules
 	logger.InitLogger()
@@ -26,6 +47,33 @@ func main() {
 
 	logger.Logger.Info("Logger initiated!!")
 
+	// Tracing, spans are no-ops until the exporter is installed
+	shutdownTracing := func(context.Context) error { return nil }
+	if configs.Config.Tracing.Enabled {
+		var err error
+		shutdownTracing, err = tracing.Init(context.Background(), tracing.Settings{
+			ServiceName: configs.Config.Tracing.ServiceName,
+			Exporter:    configs.Config.Tracing.Exporter,
+			Endpoint:    configs.Config.Tracing.Endpoint,
+			Insecure:    configs.Config.Tracing.Insecure,
+			SampleRatio: configs.Config.Tracing.SampleRatio,
+		})
+		if err != nil {
+			logger.Logger.Fatal(fmt.Sprintf("Tracing init failed!! Error: %s", err.Error()))
+		}
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Logger.Error(fmt.Sprintf("Flushing spans failed!! Error: %v", err))
+		}
+	}()
+
+	// Metrics, Prometheus collectors on a registry of their own, nil when disabled
+	var appMetrics *metrics.Metrics
+	if configs.Config.Metrics.Enabled {
+		appMetrics = metrics.New(prometheus.NewRegistry())
+	}
+
 	// MongoDBClient contains  db connection object and connection settings
 	mongoClient := dbconnectors.MongoDBClient{}
 	mongoClient.InitConnection(
@@ -35,23 +83,25 @@ func main() {
 			VideoCatalogueDB:         configs.Config.DB.DBs.VideoCatalogueDB,
 			VideoFilesCollection:     configs.Config.DB.Collections.VideoFilesColl,
 			VideoCatalogueCollection: configs.Config.DB.Collections.VideoCatalogueColl,
+			JobsCollection:           configs.Config.DB.Collections.JobsColl,
+
+			WebhooksCollection:          configs.Config.DB.Collections.WebhooksColl,
+			WebhookDeliveriesCollection: configs.Config.DB.Collections.DeliveriesColl,
+
+			EventsCollection:   configs.Config.DB.Collections.EventsColl,
+			CountersCollection: configs.Config.DB.Collections.CountersColl,
+			UseTransactions:    configs.Config.DB.UseTransactions,
+			APIKeysCollection:  configs.Config.DB.Collections.APIKeysColl,
+			TenantIsolation:    configs.Config.DB.TenantIsolation,
+			UsageCollection:    configs.Config.DB.Collections.UsageColl,
+			DataKeysCollection: configs.Config.DB.Collections.DataKeysColl,
+			SharesCollection:   configs.Config.DB.Collections.SharesColl,
+			PoolMonitor:        appMetrics.PoolMonitor(),
+			Timeouts:           dbconnectors.OperationTimeouts(configs.Config.DB.Timeouts),
 		})
 
 	logger.Logger.Info("Mongo Client connected....")
 
-	// Defer function to handle panics and closing of open connections
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Logger.Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
-			// Closing all open connections to MongoDB, as the server is shutting down.
-			if conn := mongoClient.GetConnection(); conn != nil {
-				if err = conn.(*mongo.Client).Disconnect(context.Background()); err != nil {
-					logger.Logger.Error(fmt.Sprintf("Error occurred while closing MongoDB connections!! Error: %v", err))
-				}
-			}
-		}
-	}()
-
 	// VideoCatalogueDBWrapper, an abstraction Catalogue DB level methods/function,
 	// so that we can replace DB in future with ease and minimum code changes if needed.
 	videoCatalogueDBWrapper := dbconnectors.VideoCatalogueDBWrapper{}
@@ -61,43 +111,330 @@ func main() {
 
 	// VideoFilesDBWrapper, an abstraction Files DB level methods/function,
 	// so that we can replace DB in future with ease and minimum code changes if needed.
-	videoFilesDBWrapper := dbconnectors.VideoFilesDBWrapper{}
+	videoFilesDBWrapper := dbconnectors.VideoFilesDBWrapper{Metrics: appMetrics}
 
 	// Initialising Mongo DB level connection object for Catalogue DB for files.
 	videoFilesDBWrapper.InitDatabase(&mongoClient)
 
+	// EncryptedFileManager, envelope encryption of the video bytes around the files backend
+	var videoFilesManager interfaces.IFileManagerDBWrapper = &videoFilesDBWrapper
+	if configs.Config.Encryption.Enabled {
+		keyProvider, err := encryption.NewKeyProvider(
+			configs.Config.Encryption.Provider,
+			configs.Config.Encryption.MasterKeys,
+			configs.Config.Encryption.ActiveKeyId,
+			configs.Config.Encryption.KeyringFile,
+		)
+		if err != nil {
+			logger.Logger.Fatal(fmt.Sprintf("Encryption key provider failed!! Error: %s", err.Error()))
+		}
+		dataKeyDBWrapper := dbconnectors.DataKeyDBWrapper{}
+		dataKeyDBWrapper.InitDatabase(&mongoClient)
+		videoFilesManager = &encryption.EncryptedFileManager{
+			Files:     &videoFilesDBWrapper,
+			DataKeys:  &dataKeyDBWrapper,
+			Keys:      keyProvider,
+			ChunkSize: configs.Config.Encryption.ChunkSize,
+		}
+	}
+
+	// JobQueueDBWrapper, Mongo backed queue for asynchronous post-upload processing
+	jobQueueDBWrapper := dbconnectors.JobQueueDBWrapper{}
+	jobQueueDBWrapper.InitDatabase(&mongoClient)
+
+	// OutboxDBWrapper, catalogue change events, written in the same transaction as the change
+	outboxDBWrapper := dbconnectors.OutboxDBWrapper{}
+	outboxDBWrapper.InitDatabase(&mongoClient)
+
+	// WebhookManager, webhook registry and publisher of file lifecycle events,
+	// deliveries are sent and retried by the job workers.
+	webhookDBWrapper := dbconnectors.WebhookDBWrapper{}
+	webhookDBWrapper.InitDatabase(&mongoClient)
+	webhookManagerObj := controllers.WebhookManager{
+		WebhookDBWrapper: &webhookDBWrapper,
+		JobQueue:         &jobQueueDBWrapper,
+		HTTPClient:       controllers.NewWebhookHTTPClient(configs.Config.Webhooks.Timeout, configs.Config.Webhooks.AllowPrivate),
+		AllowPrivate:     configs.Config.Webhooks.AllowPrivate,
+		MaxAttempts:      configs.Config.Webhooks.MaxAttempts,
+		DeliveryLogLimit: configs.Config.Webhooks.DeliveryLogLimit,
+	}
+
+	// UsageDBWrapper, usage counters checked against the storage quotas on upload
+	usageDBWrapper := dbconnectors.UsageDBWrapper{}
+	usageDBWrapper.InitDatabase(&mongoClient)
+
 	// VideoCatalogueManager, an abstraction for Video Catalogue related Methods/Functions,
 	// which will also contains business logics.
 	videoCatalogueManagerObj := controllers.VideoCatalogueManager{
-		&videoCatalogueDBWrapper,
-		&videoFilesDBWrapper,
+		VideoCatalogueDBWrapper: &videoCatalogueDBWrapper,
+		VideoFilesDBWrapper:     videoFilesManager,
+		FastStartOnIngest:       configs.Config.Ingest.FastStart,
+		JobQueue:                &jobQueueDBWrapper,
+		PostUploadJobs:          configs.Config.Jobs.PostUpload,
+		JobMaxAttempts:          configs.Config.Jobs.MaxAttempts,
+		Outbox:                  &outboxDBWrapper,
+		Usage:                   &usageDBWrapper,
+		Quotas:                  configs.Config.Quotas,
+		ScanFailOpen:            configs.Config.Scanner.FailOpen,
+	}
+
+	// ClamdScanner, malware scan of every upload before it is stored
+	if configs.Config.Scanner.Enabled {
+		clamdScanner, err := scanner.NewClamdScanner(configs.Config.Scanner.Address, configs.Config.Scanner.Timeout)
+		if err != nil {
+			logger.Logger.Fatal(err)
+		}
+		videoCatalogueManagerObj.Scanner = clamdScanner
+	}
+
+	// EventManager, change feed and relay of outbox events to the webhooks
+	eventManagerObj := controllers.EventManager{
+		Outbox:       &outboxDBWrapper,
+		Publisher:    &webhookManagerObj,
+		PollInterval: configs.Config.Events.PollInterval,
+		ClaimFor:     configs.Config.Events.ClaimFor,
+	}
+	// Background work runs until shutdown cancels it, after the server stopped taking requests
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		eventManagerObj.RunRelay(backgroundCtx)
+	}()
+
+	// Job workers inside the API server, processing can also be scaled out with cmd/worker
+	if configs.Config.Jobs.Workers > 0 {
+		workerPool := jobs.WorkerPool{
+			Queue:         &jobQueueDBWrapper,
+			Workers:       configs.Config.Jobs.Workers,
+			LeaseDuration: configs.Config.Jobs.LeaseDuration,
+			PollInterval:  configs.Config.Jobs.PollInterval,
+			BackoffBase:   configs.Config.Jobs.BackoffBase,
+			BackoffMax:    configs.Config.Jobs.BackoffMax,
+		}
+		videoCatalogueManagerObj.RegisterJobProcessors(&workerPool)
+		webhookManagerObj.RegisterJobProcessors(&workerPool)
+		workerPool.Start(backgroundCtx)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			workerPool.Wait()
+		}()
+	}
+
+	// APIKeyManager, API keys hashed at rest, also the authenticator of API key credentials
+	apiKeyDBWrapper := dbconnectors.APIKeyDBWrapper{}
+	apiKeyDBWrapper.InitDatabase(&mongoClient)
+	apiKeyManagerObj := controllers.APIKeyManager{APIKeyDBWrapper: &apiKeyDBWrapper}
+	authenticators := []interfaces.IAuthenticator{&apiKeyManagerObj}
+
+	// JWTAuthenticator, OIDC bearer tokens verified against the provider's JWKS
+	if configs.Config.Auth.JWT.Enabled {
+		authenticators = append(authenticators, &controllers.JWTAuthenticator{
+			Verifier: &jwt.Verifier{
+				Keys: &jwt.KeySet{
+					URL:             configs.Config.Auth.JWT.JWKSURL,
+					File:            configs.Config.Auth.JWT.JWKSFile,
+					HTTPClient:      &http.Client{Timeout: 10 * time.Second},
+					RefreshInterval: configs.Config.Auth.JWT.JWKSRefresh,
+				},
+				Issuer:   configs.Config.Auth.JWT.Issuer,
+				Audience: configs.Config.Auth.JWT.Audience,
+				Leeway:   configs.Config.Auth.JWT.Leeway,
+			},
+			SubjectClaim: configs.Config.Auth.JWT.SubjectClaim,
+			NameClaim:    configs.Config.Auth.JWT.NameClaim,
+			RolesClaim:   configs.Config.Auth.JWT.RolesClaim,
+			ScopeClaim:   configs.Config.Auth.JWT.ScopeClaim,
+			GroupsClaim:  configs.Config.Auth.JWT.GroupsClaim,
+			TenantClaim:  configs.Config.Auth.JWT.TenantClaim,
+			RoleScopes:   configs.Config.Auth.JWT.RoleScopes,
+		})
+	}
+
+	// ShareManager, signed download and upload URLs, the last authenticator as it only looks at query params
+	var shareManager interfaces.IShareManager
+	if configs.Config.Shares.Enabled {
+		shareDBWrapper := dbconnectors.ShareDBWrapper{}
+		shareDBWrapper.InitDatabase(&mongoClient)
+		shareManagerObj := controllers.ShareManager{
+			ShareDBWrapper:        &shareDBWrapper,
+			VideoCatalogueManager: &videoCatalogueManagerObj,
+			SigningKey:            []byte(configs.Config.Shares.SigningKey),
+			BaseURL:               fmt.Sprintf("http://%s:%s", os.Getenv("HOST"), os.Getenv("PORT")),
+			DefaultTTL:            configs.Config.Shares.DefaultTTL,
+			MaxTTL:                configs.Config.Shares.MaxTTL,
+		}
+		authenticators = append(authenticators, &shareManagerObj)
+		shareManager = &shareManagerObj
 	}
 
+	// Limiter, request rate, bandwidth and concurrent upload limits, reloaded with the config file
+	limiter := ratelimit.NewLimiter(configs.Config.RateLimits)
+
+	// Reloads of the config file, on change and on SIGHUP. Log level and rate limits are applied here,
+	// media types, body limits and the CORS policy are read from configs.Current per request.
+	configs.WatchConfig(func(reload configs.Reload) {
+		if logger.ApplyReload(reload) {
+			limiter.Configure(reload.Config.RateLimits)
+		}
+	})
+
 	// Handler, router handler object, which contains all the common Object instances required to server
 	// response for a given request, such as db connections, app config etc
-	handler := handlers.Handler{VideoCatalogueManager: &videoCatalogueManagerObj}
+	drain := shutdown.NewDrain()
+	readinessChecks := []health.Check{
+		{Name: "mongodb", Check: mongoClient.Ping},
+		{Name: "catalogue", Check: videoCatalogueDBWrapper.CheckCollections},
+		{Name: "gridfs", Check: videoFilesDBWrapper.CheckCollections},
+	}
+	if configs.Config.Health.MinFreeTempBytes > 0 {
+		readinessChecks = append(readinessChecks, health.Check{
+			Name:  "temp_disk",
+			Check: health.FreeDiskSpace(os.TempDir(), configs.Config.Health.MinFreeTempBytes),
+		})
+	}
+	handler := handlers.Handler{
+		Config:                configs.Current,
+		VideoCatalogueManager: &videoCatalogueManagerObj,
+		UploadProgress:        progress.NewRegistry(configs.Config.Uploads.ProgressRetention),
+		WebhookManager:        &webhookManagerObj,
+		EventManager:          &eventManagerObj,
+		APIKeyManager:         &apiKeyManagerObj,
+		ShareManager:          shareManager,
+		Metrics:               appMetrics,
+		Drain:                 drain,
+		Health:                &health.Checker{Checks: readinessChecks, Timeout: configs.Config.Health.CheckTimeout},
+	}
 
 	logger.Logger.Info("Router Handler initiated....")
 
-	router := gin.Default()
+	// gin's own request logging is replaced by the JSON access log
+	router := gin.New()
+	router.Use(gin.Recovery())
+	if configs.Config.Tracing.Enabled {
+		router.Use(middlewares.TracingMiddleware(configs.Config.Tracing.ServiceName))
+	}
+	router.Use(middlewares.RequestIDMiddleware(), middlewares.AccessLogMiddleware())
+	router.Use(middlewares.MetricsMiddleware(appMetrics))
+	if appMetrics != nil {
+		router.GET(configs.Config.Metrics.Path, gin.WrapH(appMetrics.Handler()))
+	}
+	// Client addresses are taken from X-Forwarded-For only when a trusted proxy sent it
+	if err := router.SetTrustedProxies(configs.Config.Server.TrustedProxies); err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Trusted proxies invalid!! Error: %s", err.Error()))
+	}
 
 	// applying CORS rules here
-	router.Use(middlewares.CORSMiddleware())
+	router.Use(middlewares.CORSMiddleware(func() *models.CORSPolicy {
+		return &configs.Current().CORS
+	}))
 
 	v1 := router.Group("/v1")
+	v1.GET("/health", handler.HealthCheck)
+	v1.GET("/health/live", handler.LivenessHandler)
+	v1.GET("/health/ready", handler.ReadinessHandler)
+
+	// Every other route needs a principal with the route's scope. Client addresses are limited before
+	// authentication, principals and their bandwidth after it.
+	authenticated := v1.Group("",
+		middlewares.ClientRateLimitMiddleware(limiter),
+		middlewares.AuthMiddleware(configs.Config.Auth.Enabled, authenticators...),
+		middlewares.RateLimitMiddleware(limiter),
+		middlewares.BodyLimitMiddleware(func() *models.BodyLimits {
+			return &configs.Current().BodyLimits
+		}),
+	)
+	read := authenticated.Group("", middlewares.RequireScope(models.ScopeFilesRead))
 	{
-		v1.GET("/health", handler.HealthCheck)
-		v1.GET("/files/:fileid", handler.GetFileByIdHandler)
-		v1.GET("/files/locate/:fileid", handler.LocateFileByIdHandler)
-		v1.DELETE("/files/:fileid", handler.DeleteFileByIdHandler)
-		v1.POST("/files", handler.PostSingleFileHandler)
-		v1.GET("/files", handler.GetFilesListHandler)
+		read.GET("/files/:fileid", handler.GetFileByIdHandler)
+		read.GET("/files/locate/:fileid", handler.LocateFileByIdHandler)
+		read.GET("/files", handler.GetFilesListHandler)
+		read.GET("/files/:fileid/acl", handler.GetFileACLHandler)
+		read.GET("/jobs/:id", handler.GetJobByIdHandler)
+		read.GET("/events", handler.GetEventsHandler)
+		read.GET("/usage", handler.GetUsageHandler)
+	}
+	write := authenticated.Group("", middlewares.RequireScope(models.ScopeFilesWrite))
+	{
+		write.POST("/files", middlewares.ConcurrentUploadsMiddleware(limiter), handler.PostSingleFileHandler)
+		if shareManager != nil {
+			write.POST("/files/:fileid/share", handler.ShareFileHandler)
+			write.POST("/uploads/share", handler.ShareUploadHandler)
+		}
+		write.PUT("/files/:fileid/acl", handler.UpdateFileACLHandler)
+		write.GET("/uploads/:id/progress", handler.GetUploadProgressHandler)
+	}
+	remove := authenticated.Group("", middlewares.RequireScope(models.ScopeFilesDelete))
+	{
+		remove.DELETE("/files/:fileid", handler.DeleteFileByIdHandler)
+	}
+	admin := authenticated.Group("", middlewares.RequireScope(models.ScopeAdmin))
+	{
+		admin.POST("/webhooks", handler.CreateWebhookHandler)
+		admin.GET("/webhooks", handler.GetWebhooksListHandler)
+		admin.GET("/webhooks/:id", handler.GetWebhookByIdHandler)
+		admin.PUT("/webhooks/:id", handler.UpdateWebhookHandler)
+		admin.DELETE("/webhooks/:id", handler.DeleteWebhookHandler)
+		admin.GET("/webhooks/:id/deliveries", handler.GetWebhookDeliveriesHandler)
+		admin.POST("/webhooks/:id/deliveries/:deliveryid/redeliver", handler.RedeliverWebhookHandler)
+		admin.POST("/admin/keys", handler.CreateAPIKeyHandler)
+		admin.GET("/admin/keys", handler.GetAPIKeysListHandler)
+		admin.DELETE("/admin/keys/:id", handler.RevokeAPIKeyHandler)
 	}
 
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", configs.Config.Server.Port),
+		Handler:           router,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
 	logger.Logger.Info("Server Starting up.....")
-	if err := router.Run(fmt.Sprintf(":%s", os.Getenv("PORT"))); err != nil {
-		logger.Logger.Error(fmt.Printf("Server Startup failed.... Error:%s", err.Error()))
-	} else {
-		logger.Logger.Info("Server Started successfully.....")
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		logger.Logger.Error(fmt.Sprintf("Server Startup failed.... Error:%s", err.Error()))
+	case <-signals.Done():
+		// a second signal terminates right away
+		stopSignals()
+		shutdownServer(server, drain, configs.Config.Server.DrainPeriod, configs.Config.Server.GracePeriod)
+	}
+
+	logger.Logger.Info("Stopping background workers, waiting for running jobs.....")
+	stopBackground()
+	background.Wait()
+
+	disconnectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.GetConnection().(*mongo.Client).Disconnect(disconnectCtx); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Error occurred while closing MongoDB connections!! Error: %v", err))
+	}
+	logger.Logger.Info("Server stopped")
+}
+
+// shutdownServer, failing the health check for drainPeriod so load balancers stop routing to the
+// instance, then closing the listener and giving requests in flight gracePeriod to finish. Connections
+// still open after that are closed, which cancels their requests' contexts and with them the
+// database operations and uploads in progress.
+func shutdownServer(server *http.Server, drain *shutdown.Drain, drainPeriod time.Duration, gracePeriod time.Duration) {
+	logger.Logger.Info(fmt.Sprintf("Server draining for %s.....", drainPeriod))
+	drain.Start()
+	time.Sleep(drainPeriod)
+
+	logger.Logger.Info(fmt.Sprintf("Server shutting down, waiting up to %s for requests in flight.....", gracePeriod))
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Grace period over, closing remaining connections!! Error: %v", err))
+		if err = server.Close(); err != nil {
+			logger.Logger.Error(fmt.Sprintf("Closing connections failed!! Error: %v", err))
+		}
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"city_os/cmd/app/configs"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Config inspection, loading the config the way the server and the worker do:
+//
+//	config print [-config <file>] [-set key=value]...
+//
+// print writes the effective value of every setting after defaults, config file, environment and flags
+// were applied, secrets redacted, followed by the problems that would stop the server from starting.
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	configFlags := configs.RegisterFlags(flags)
+
+	switch os.Args[1] {
+	case "print":
+		_ = flags.Parse(os.Args[2:])
+		config, err := configs.Load(configFlags)
+		var invalid *configs.ValidationError
+		if err != nil && !errors.As(err, &invalid) {
+			fail(err)
+		}
+
+		configFile := configs.ConfigFileUsed()
+		if configFile == "" {
+			configFile = "none"
+		}
+		fmt.Printf("# config file: %s\n", configFile)
+		if err := config.Print(os.Stdout); err != nil {
+			fail(err)
+		}
+		if invalid != nil {
+			fmt.Fprintln(os.Stderr)
+			fail(invalid)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: config print [-config <file>] [-set <key=value>]...")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"city_os/cmd/app/configs"
+	logger "city_os/src/common"
+	"city_os/src/dbconnectors"
+	"city_os/src/encryption"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+)
+
+// Master key administration of the envelope encryption:
+//
+//	masterkeys create
+//	masterkeys rotate [-batch 500]
+//
+// create adds a new active key to the localkms keyring, with the static provider it prints a key to add to
+// ENCRYPTION_MASTER_KEYS and to set as encryption.activeKeyId. rotate re-wraps the data keys of all files
+// with the active master key, the video bytes are not rewritten. Old master keys have to stay available
+// until rotate reports no failures.
+
+func main() {
+	// config flags come before the command, e.g. masterkeys -config ./appConfig.json list
+	global := flag.NewFlagSet("masterkeys", flag.ExitOnError)
+	configFlags := configs.RegisterFlags(global)
+	_ = global.Parse(os.Args[1:])
+	if global.NArg() < 1 {
+		usage()
+	}
+	command, args := global.Arg(0), global.Args()[1:]
+
+	configs.LoadConfig(configFlags)
+	logger.InitLogger()
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+
+	switch command {
+	case "create":
+		_ = flags.Parse(args)
+		if configs.Config.Encryption.Provider != encryption.ProviderLocalKMS {
+			keyId, masterKey, err := encryption.GenerateMasterKey()
+			if err != nil {
+				fail(err)
+			}
+			fmt.Printf("key: %s:%s\n\nAppend it to ENCRYPTION_MASTER_KEYS, set encryption.activeKeyId to %s and restart, then run rotate.\n",
+				keyId, base64.StdEncoding.EncodeToString(masterKey), keyId)
+			return
+		}
+		kms, err := encryption.OpenLocalKMS(configs.Config.Encryption.KeyringFile)
+		if err != nil {
+			fail(err)
+		}
+		keyId, err := kms.CreateKey()
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Master key %s created and active in %s, running instances seal the data keys of\n"+
+			"uploads starting a second from now with it. Run rotate to re-wrap the existing data keys.\n",
+			keyId, configs.Config.Encryption.KeyringFile)
+	case "rotate":
+		batch := flags.Int64("batch", 500, "data keys read per batch")
+		_ = flags.Parse(args)
+
+		keyProvider, err := encryption.NewKeyProvider(
+			configs.Config.Encryption.Provider,
+			configs.Config.Encryption.MasterKeys,
+			configs.Config.Encryption.ActiveKeyId,
+			configs.Config.Encryption.KeyringFile,
+		)
+		if err != nil {
+			fail(err)
+		}
+
+		mongoClient := dbconnectors.MongoDBClient{}
+		mongoClient.InitConnection(
+			&dbconnectors.MongoDBSettings{
+				URI:                configs.Config.DB.URI,
+				PoolSize:           configs.Config.DB.PoolSize,
+				VideoCatalogueDB:   configs.Config.DB.DBs.VideoCatalogueDB,
+				DataKeysCollection: configs.Config.DB.Collections.DataKeysColl,
+			})
+		defer mongoClient.GetConnection().(*mongo.Client).Disconnect(context.Background())
+
+		dataKeyDBWrapper := dbconnectors.DataKeyDBWrapper{}
+		dataKeyDBWrapper.InitDatabase(&mongoClient)
+
+		rotated, failed, err := encryption.RotateDataKeys(&dataKeyDBWrapper, keyProvider, *batch)
+		fmt.Printf("%d data keys re-wrapped with master key %s, %d failed\n", rotated, keyProvider.ActiveKeyId(), failed)
+		if err != nil {
+			fail(err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: masterkeys [-config <file>] [-set <key=value>]... create | rotate [-batch <data keys per batch>]")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+	os.Exit(1)
+}
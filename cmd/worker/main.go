@@ -0,0 +1,161 @@
+package main
+
+import (
+	"city_os/cmd/app/configs"
+	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/dbconnectors"
+	"city_os/src/encryption"
+	"city_os/src/interfaces"
+	"city_os/src/jobs"
+	"city_os/src/tracing"
+	"context"
+	"flag"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os/signal"
+	"syscall"
+)
+
+// Standalone job worker, processes the same queue as the workers embedded in the API server
+// so that heavy post-upload processing can be scaled independently.
+
+func main() {
+
+	// Loading application configs, shared with the API server
+	configFlags := configs.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	configs.LoadConfig(configFlags)
+
+	logger.InitLogger()
+
+	logger.Logger.Info("Worker config loaded successfully!!")
+
+	// Tracing, spans are no-ops until the exporter is installed
+	shutdownTracing := func(context.Context) error { return nil }
+	if configs.Config.Tracing.Enabled {
+		var err error
+		shutdownTracing, err = tracing.Init(context.Background(), tracing.Settings{
+			ServiceName: configs.Config.Tracing.ServiceName + "-worker",
+			Exporter:    configs.Config.Tracing.Exporter,
+			Endpoint:    configs.Config.Tracing.Endpoint,
+			Insecure:    configs.Config.Tracing.Insecure,
+			SampleRatio: configs.Config.Tracing.SampleRatio,
+		})
+		if err != nil {
+			logger.Logger.Fatal(fmt.Sprintf("Tracing init failed!! Error: %s", err.Error()))
+		}
+	}
+
+	mongoClient := dbconnectors.MongoDBClient{}
+	mongoClient.InitConnection(
+		&dbconnectors.MongoDBSettings{
+			URI:                      configs.Config.DB.URI,
+			PoolSize:                 configs.Config.DB.PoolSize,
+			VideoCatalogueDB:         configs.Config.DB.DBs.VideoCatalogueDB,
+			VideoFilesCollection:     configs.Config.DB.Collections.VideoFilesColl,
+			VideoCatalogueCollection: configs.Config.DB.Collections.VideoCatalogueColl,
+			JobsCollection:           configs.Config.DB.Collections.JobsColl,
+
+			WebhooksCollection:          configs.Config.DB.Collections.WebhooksColl,
+			WebhookDeliveriesCollection: configs.Config.DB.Collections.DeliveriesColl,
+
+			EventsCollection:   configs.Config.DB.Collections.EventsColl,
+			CountersCollection: configs.Config.DB.Collections.CountersColl,
+			UseTransactions:    configs.Config.DB.UseTransactions,
+			APIKeysCollection:  configs.Config.DB.Collections.APIKeysColl,
+			TenantIsolation:    configs.Config.DB.TenantIsolation,
+			UsageCollection:    configs.Config.DB.Collections.UsageColl,
+			DataKeysCollection: configs.Config.DB.Collections.DataKeysColl,
+			Timeouts:           dbconnectors.OperationTimeouts(configs.Config.DB.Timeouts),
+		})
+
+	logger.Logger.Info("Mongo Client connected....")
+
+	videoCatalogueDBWrapper := dbconnectors.VideoCatalogueDBWrapper{}
+	videoCatalogueDBWrapper.InitDatabase(&mongoClient)
+
+	videoFilesDBWrapper := dbconnectors.VideoFilesDBWrapper{}
+	videoFilesDBWrapper.InitDatabase(&mongoClient)
+
+	// Processing reads the files through the same envelope encryption as the API server
+	var videoFilesManager interfaces.IFileManagerDBWrapper = &videoFilesDBWrapper
+	if configs.Config.Encryption.Enabled {
+		keyProvider, err := encryption.NewKeyProvider(
+			configs.Config.Encryption.Provider,
+			configs.Config.Encryption.MasterKeys,
+			configs.Config.Encryption.ActiveKeyId,
+			configs.Config.Encryption.KeyringFile,
+		)
+		if err != nil {
+			logger.Logger.Fatal(fmt.Sprintf("Encryption key provider failed!! Error: %s", err.Error()))
+		}
+		dataKeyDBWrapper := dbconnectors.DataKeyDBWrapper{}
+		dataKeyDBWrapper.InitDatabase(&mongoClient)
+		videoFilesManager = &encryption.EncryptedFileManager{
+			Files:     &videoFilesDBWrapper,
+			DataKeys:  &dataKeyDBWrapper,
+			Keys:      keyProvider,
+			ChunkSize: configs.Config.Encryption.ChunkSize,
+		}
+	}
+
+	jobQueueDBWrapper := dbconnectors.JobQueueDBWrapper{}
+	jobQueueDBWrapper.InitDatabase(&mongoClient)
+
+	outboxDBWrapper := dbconnectors.OutboxDBWrapper{}
+	outboxDBWrapper.InitDatabase(&mongoClient)
+
+	webhookDBWrapper := dbconnectors.WebhookDBWrapper{}
+	webhookDBWrapper.InitDatabase(&mongoClient)
+	webhookManagerObj := controllers.WebhookManager{
+		WebhookDBWrapper: &webhookDBWrapper,
+		JobQueue:         &jobQueueDBWrapper,
+		HTTPClient:       controllers.NewWebhookHTTPClient(configs.Config.Webhooks.Timeout, configs.Config.Webhooks.AllowPrivate),
+		AllowPrivate:     configs.Config.Webhooks.AllowPrivate,
+		MaxAttempts:      configs.Config.Webhooks.MaxAttempts,
+	}
+
+	videoCatalogueManagerObj := controllers.VideoCatalogueManager{
+		VideoCatalogueDBWrapper: &videoCatalogueDBWrapper,
+		VideoFilesDBWrapper:     videoFilesManager,
+		JobQueue:                &jobQueueDBWrapper,
+		Outbox:                  &outboxDBWrapper,
+	}
+
+	workers := configs.Config.Jobs.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	workerPool := jobs.WorkerPool{
+		Queue:         &jobQueueDBWrapper,
+		Workers:       workers,
+		LeaseDuration: configs.Config.Jobs.LeaseDuration,
+		PollInterval:  configs.Config.Jobs.PollInterval,
+		BackoffBase:   configs.Config.Jobs.BackoffBase,
+		BackoffMax:    configs.Config.Jobs.BackoffMax,
+	}
+	videoCatalogueManagerObj.RegisterJobProcessors(&workerPool)
+	webhookManagerObj.RegisterJobProcessors(&workerPool)
+
+	// Reloads of the config file, on change and on SIGHUP, only the log level applies to the worker
+	configs.WatchConfig(func(reload configs.Reload) {
+		logger.ApplyReload(reload)
+	})
+
+	// Workers finish the job at hand and stop leasing new ones on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	workerPool.Start(ctx)
+	<-ctx.Done()
+	logger.Logger.Info("Worker shutting down, waiting for running jobs.....")
+	workerPool.Wait()
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Flushing spans failed!! Error: %v", err))
+	}
+
+	if err := mongoClient.GetConnection().(*mongo.Client).Disconnect(context.Background()); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Error occurred while closing MongoDB connections!! Error: %v", err))
+	}
+}
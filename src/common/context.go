@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+)
+
+// Field names shared by every package, so log lines of a request or job can be filtered by them
+const (
+	FieldRequestID = "request_id"
+	FieldTraceID   = "trace_id"
+	FieldMethod    = "method"
+	FieldRoute     = "route"
+	FieldFileID    = "file_id"
+	FieldPrincipal = "principal"
+	FieldTenant    = "tenant"
+	FieldJobID     = "job_id"
+	FieldJobType   = "job_type"
+)
+
+type entryContextKey struct{}
+
+// WithFields, ctx carrying the logger of ctx extended by fields, every later log line of the request
+// or job includes them
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return context.WithValue(ctx, entryContextKey{}, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext, logger of the request or job ctx belongs to, the plain Logger without any fields when
+// there is none
+func FromContext(ctx context.Context) *log.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(entryContextKey{}).(*log.Entry); ok {
+			return entry
+		}
+	}
+	return log.NewEntry(Logger)
+}
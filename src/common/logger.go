@@ -2,21 +2,47 @@ package logger
 
 import (
 	"city_os/cmd/app/configs"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"os"
 )
 
 var Logger *log.Logger
 
-// Initialising Logger  with set of flags/params from app config
+// Initialising Logger  with set of flags/params from app config, logging to stderr unless a log file is set
 
 func InitLogger() {
 	if Logger == nil {
 		Logger = &log.Logger{
+			Out:       os.Stderr,
 			Formatter: &log.JSONFormatter{},
 			Level:     configs.Config.GetLogLevel(),
 		}
-		if configs.Config.GetLogFileIO() != nil {
-			Logger.Out = configs.Config.GetLogFileIO()
+		logFile, err := configs.Config.GetLogFileIO()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Opening log file failed!! file: %s, Error: %s\n", configs.Config.Logger.OutFile, err.Error())
+			os.Exit(1)
 		}
+		if logFile != nil {
+			Logger.Out = logFile
+		}
+	}
+}
+
+// ApplyReload, logging the outcome of a config reload and applying its log level, false when the reload
+// was rejected and nothing changed
+
+func ApplyReload(reload configs.Reload) bool {
+	if reload.Err != nil {
+		Logger.Error(fmt.Sprintf("Config reload rejected, keeping the current config!! Error: %s", reload.Err.Error()))
+		return false
+	}
+	for _, change := range reload.Applied {
+		Logger.Info(fmt.Sprintf("Config reloaded!! %s", change))
+	}
+	for _, setting := range reload.Ignored {
+		Logger.Warn(fmt.Sprintf("Config change takes effect on restart!! setting: %s", setting))
 	}
+	Logger.SetLevel(reload.Config.GetLogLevel())
+	return true
 }
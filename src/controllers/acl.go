@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"city_os/src/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
+)
+
+var (
+	ErrAccessDenied = errors.New("access denied")
+	ErrInvalidACL   = errors.New("invalid acl")
+)
+
+// Grantee reference prefixes accepted in ACL grants
+var granteePrefixes = []string{models.PrincipalTypeUser + ":", models.PrincipalTypeAPIKey + ":", models.GroupRefPrefix}
+
+// canAccess, access rules of a Video File: callers without principal (auth disabled, internal callers)
+// and admins may do anything, the owner may do anything with the file, others need a grant of the
+// permission for themselves or one of their groups. Files stored before ownership existed stay open
+// to every caller with the route's scope. Holders of a signed URL may only read the shared file.
+
+func canAccess(principal *models.Principal, videoCatalogueData *models.VideoCatalogueData, permission string) bool {
+	if principal == nil || principal.HasScope(models.ScopeAdmin) {
+		return true
+	}
+	if principal.Type == models.PrincipalTypeShare {
+		return principal.FileId != "" && principal.FileId == videoCatalogueData.FileId && permission == models.PermissionRead
+	}
+	refs := principal.Refs()
+	if videoCatalogueData.Owner == "" || videoCatalogueData.Owner == refs[0] {
+		return true
+	}
+	for _, grant := range videoCatalogueData.ACL {
+		if containsString(refs, grant.Grantee) && containsString(grant.Permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// readableFilesFilter, catalogue query matching the files canAccess allows the principal to read
+
+func readableFilesFilter(principal *models.Principal) bson.D {
+	if principal == nil || principal.HasScope(models.ScopeAdmin) {
+		return bson.D{}
+	}
+	if principal.Type == models.PrincipalTypeShare {
+		// Only the shared file, upload shares match nothing
+		objectId, err := primitive.ObjectIDFromHex(principal.FileId)
+		if err != nil {
+			return bson.D{{Key: "_id", Value: bson.D{{Key: "$exists", Value: false}}}}
+		}
+		return bson.D{{Key: "_id", Value: objectId}}
+	}
+	refs := principal.Refs()
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "owner", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "owner", Value: refs[0]}},
+		bson.D{{Key: "acl", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+			{Key: "grantee", Value: bson.D{{Key: "$in", Value: refs}}},
+			{Key: "permissions", Value: models.PermissionRead},
+		}}}}},
+	}}}
+}
+
+// getAuthorizedFileData, catalogue data of the file when the principal has the permission on it
+
+func (db *VideoCatalogueManager) getAuthorizedFileData(ctx context.Context, fileId string, principal *models.Principal, permission string) (*models.VideoCatalogueData, error) {
+	videoCatalogueDataRaw, err := db.catalogue(models.TenantOf(principal)).GetDocumentById(ctx, fileId)
+	if err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("getDocumentById call failed!! Error:%s", err.Error()))
+		return nil, err
+	}
+
+	videoCatalogueData := videoCatalogueDataRaw.(*models.VideoCatalogueData)
+	if !canAccess(principal, videoCatalogueData, permission) {
+		logger.FromContext(ctx).Info(fmt.Sprintf("Access denied!! fileId: %s, principal: %s, permission: %s", fileId, principal.Ref(), permission))
+		return nil, fmt.Errorf("%w: %s permission required", ErrAccessDenied, permission)
+	}
+	return videoCatalogueData, nil
+}
+
+//GetFileACL, owner and grants of a file, visible to everyone who can read the file
+
+func (db *VideoCatalogueManager) GetFileACL(ctx context.Context, fileId string, principal *models.Principal) (_ *models.FileACL, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetFileACL", attribute.String("file.id", fileId))
+	defer func() { tracing.End(span, err) }()
+
+	videoCatalogueData, err := db.GetFilesDataById(ctx, fileId, principal)
+	if err != nil {
+		return nil, err
+	}
+	return toFileACL(videoCatalogueData), nil
+}
+
+//UpdateFileACL, replacing the grants of a file, needs write permission. Transferring the file to another
+//owner is reserved to its owner and admins.
+
+func (db *VideoCatalogueManager) UpdateFileACL(ctx context.Context, fileId string, request *models.FileACLRequest, principal *models.Principal) (_ *models.FileACL, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.UpdateFileACL", attribute.String("file.id", fileId))
+	defer func() { tracing.End(span, err) }()
+
+	grants, err := normalizeGrants(request.Grants)
+	if err != nil {
+		return nil, err
+	}
+	videoCatalogueData, err := db.getAuthorizedFileData(ctx, fileId, principal, models.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	setFields := bson.M{"acl": grants}
+	if request.Owner != nil && *request.Owner != videoCatalogueData.Owner {
+		isOwner := principal != nil && videoCatalogueData.Owner != "" && videoCatalogueData.Owner == principal.Ref()
+		if principal != nil && !isOwner && !principal.HasScope(models.ScopeAdmin) {
+			return nil, fmt.Errorf("%w: only the owner or an admin can transfer the file", ErrAccessDenied)
+		}
+		if !isValidGrantee(*request.Owner) || strings.HasPrefix(*request.Owner, models.GroupRefPrefix) {
+			return nil, fmt.Errorf("%w: owner must be a user or apikey reference", ErrInvalidACL)
+		}
+		setFields["owner"] = *request.Owner
+		videoCatalogueData.Owner = *request.Owner
+	}
+
+	if _, err = db.catalogue(models.TenantOf(principal)).UpdateDocumentById(ctx, fileId, setFields); err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Updating acl failed!! fileId: %s, Error: %s", fileId, err.Error()))
+		return nil, err
+	}
+	videoCatalogueData.ACL = grants
+	return toFileACL(videoCatalogueData), nil
+}
+
+// normalizeGrants, validating grants and merging the grants of the same grantee
+
+func normalizeGrants(grants []models.ACLGrant) ([]models.ACLGrant, error) {
+	normalized := make([]models.ACLGrant, 0, len(grants))
+	indexes := map[string]int{}
+	for _, grant := range grants {
+		if !isValidGrantee(grant.Grantee) {
+			return nil, fmt.Errorf("%w: grantee %q must be user:<id>, apikey:<id> or group:<name>", ErrInvalidACL, grant.Grantee)
+		}
+		index, found := indexes[grant.Grantee]
+		if !found {
+			index = len(normalized)
+			indexes[grant.Grantee] = index
+			normalized = append(normalized, models.ACLGrant{Grantee: grant.Grantee, Permissions: []string{}})
+		}
+		for _, permission := range grant.Permissions {
+			if !containsString(models.Permissions, permission) {
+				return nil, fmt.Errorf("%w: unknown permission %q", ErrInvalidACL, permission)
+			}
+			if !containsString(normalized[index].Permissions, permission) {
+				normalized[index].Permissions = append(normalized[index].Permissions, permission)
+			}
+		}
+	}
+	return normalized, nil
+}
+
+func isValidGrantee(grantee string) bool {
+	for _, prefix := range granteePrefixes {
+		if strings.HasPrefix(grantee, prefix) && len(grantee) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFileACL(videoCatalogueData *models.VideoCatalogueData) *models.FileACL {
+	grants := videoCatalogueData.ACL
+	if grants == nil {
+		grants = []models.ACLGrant{}
+	}
+	return &models.FileACL{FileId: videoCatalogueData.FileId, Owner: videoCatalogueData.Owner, Grants: grants}
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"city_os/src/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+const aclTestSharedFileId = "64b7f0c2a1b2c3d4e5f60718"
+
+var aclTestFiles = map[string]*models.VideoCatalogueData{
+	"legacy": {FileId: "legacy"},
+	"shared": {FileId: aclTestSharedFileId, Owner: "user:alice"},
+	"owned":  {FileId: "owned", Owner: "user:alice"},
+	"granted": {FileId: "granted", Owner: "user:alice", ACL: []models.ACLGrant{
+		{Grantee: "user:bob", Permissions: []string{models.PermissionRead}},
+		{Grantee: "apikey:k1", Permissions: []string{models.PermissionRead, models.PermissionWrite}},
+	}},
+	"group": {FileId: "group", Owner: "user:alice", ACL: []models.ACLGrant{
+		{Grantee: "group:editors", Permissions: []string{models.PermissionWrite}},
+		{Grantee: "group:viewers", Permissions: []string{models.PermissionRead}},
+	}},
+}
+
+func TestCanAccess(t *testing.T) {
+	alice := &models.Principal{Id: "alice", Type: models.PrincipalTypeUser, Scopes: []string{models.ScopeFilesRead}}
+	bob := &models.Principal{Id: "bob", Type: models.PrincipalTypeUser, Scopes: []string{models.ScopeFilesRead}}
+	key := &models.Principal{Id: "k1", Type: models.PrincipalTypeAPIKey, Scopes: []string{models.ScopeFilesWrite}}
+	viewer := &models.Principal{Id: "carol", Type: models.PrincipalTypeUser, Groups: []string{"viewers"}}
+	admin := &models.Principal{Id: "root", Type: models.PrincipalTypeAPIKey, Scopes: []string{models.ScopeAdmin}}
+	// An API key with the id of a user must not pass as that user
+	impostor := &models.Principal{Id: "alice", Type: models.PrincipalTypeAPIKey}
+	download := &models.Principal{Id: "s1", Type: models.PrincipalTypeShare, FileId: aclTestSharedFileId, Scopes: []string{models.ScopeFilesRead}}
+	upload := &models.Principal{Id: "s2", Type: models.PrincipalTypeShare, Scopes: []string{models.ScopeFilesWrite}}
+
+	tests := []struct {
+		name       string
+		principal  *models.Principal
+		file       string
+		permission string
+		want       bool
+	}{
+		{"no principal", nil, "owned", models.PermissionDelete, true},
+		{"admin", admin, "owned", models.PermissionDelete, true},
+		{"legacy file read", bob, "legacy", models.PermissionRead, true},
+		{"legacy file delete", bob, "legacy", models.PermissionDelete, true},
+		{"owner read", alice, "owned", models.PermissionRead, true},
+		{"owner delete", alice, "owned", models.PermissionDelete, true},
+		{"stranger read", bob, "owned", models.PermissionRead, false},
+		{"same id other type", impostor, "owned", models.PermissionRead, false},
+		{"user grant read", bob, "granted", models.PermissionRead, true},
+		{"user grant without write", bob, "granted", models.PermissionWrite, false},
+		{"apikey grant write", key, "granted", models.PermissionWrite, true},
+		{"apikey grant without delete", key, "granted", models.PermissionDelete, false},
+		{"group grant read", viewer, "group", models.PermissionRead, true},
+		{"group grant without write", viewer, "group", models.PermissionWrite, false},
+		{"group of other file", viewer, "granted", models.PermissionRead, false},
+		{"share read shared file", download, "shared", models.PermissionRead, true},
+		{"share write shared file", download, "shared", models.PermissionWrite, false},
+		{"share read other file", download, "owned", models.PermissionRead, false},
+		{"share read legacy file", download, "legacy", models.PermissionRead, false},
+		{"upload share read legacy file", upload, "legacy", models.PermissionRead, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := canAccess(test.principal, aclTestFiles[test.file], test.permission); got != test.want {
+				t.Fatalf("canAccess = %v, expected %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestReadableFilesFilterMatchesCanAccess, the list filter has to return exactly the files a principal
+// could read one by one
+func TestReadableFilesFilterMatchesCanAccess(t *testing.T) {
+	principals := []*models.Principal{
+		nil,
+		{Id: "alice", Type: models.PrincipalTypeUser},
+		{Id: "bob", Type: models.PrincipalTypeUser},
+		{Id: "k1", Type: models.PrincipalTypeAPIKey},
+		{Id: "carol", Type: models.PrincipalTypeUser, Groups: []string{"viewers"}},
+		{Id: "dave", Type: models.PrincipalTypeUser, Groups: []string{"editors"}},
+		{Id: "alice", Type: models.PrincipalTypeAPIKey},
+		{Id: "root", Type: models.PrincipalTypeAPIKey, Scopes: []string{models.ScopeAdmin}},
+		{Id: "s1", Type: models.PrincipalTypeShare, FileId: aclTestSharedFileId},
+		{Id: "s2", Type: models.PrincipalTypeShare},
+	}
+	for _, principal := range principals {
+		filter := readableFilesFilter(principal)
+		for name, file := range aclTestFiles {
+			want := canAccess(principal, file, models.PermissionRead)
+			if got := matchesFilter(t, file, filter); got != want {
+				ref := "none"
+				if principal != nil {
+					ref = principal.Ref()
+				}
+				t.Errorf("principal %s, file %s: filter matches %v, canAccess %v", ref, name, got, want)
+			}
+		}
+	}
+}
+
+// matchesFilter, evaluating the query operators used by the catalogue filters against a document
+func matchesFilter(t *testing.T, document interface{}, filter bson.D) bool {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := bson.M{}
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return matchDocument(t, doc, filter)
+}
+
+func matchDocument(t *testing.T, doc bson.M, filter bson.D) bool {
+	for _, element := range filter {
+		switch element.Key {
+		case "$or":
+			matched := false
+			for _, clause := range element.Value.(bson.A) {
+				matched = matched || matchDocument(t, doc, clause.(bson.D))
+			}
+			if !matched {
+				return false
+			}
+		case "$and":
+			for _, clause := range element.Value.(bson.A) {
+				if !matchDocument(t, doc, clause.(bson.D)) {
+					return false
+				}
+			}
+		default:
+			value, exists := doc[element.Key]
+			if !matchValue(t, value, exists, element.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchValue(t *testing.T, value interface{}, exists bool, condition interface{}) bool {
+	if objectId, isObjectId := condition.(primitive.ObjectID); isObjectId {
+		// Hex ids of the documents are stored as ObjectIDs
+		return exists && value == objectId.Hex()
+	}
+	operators, isOperator := condition.(bson.D)
+	if !isOperator {
+		if values, isArray := value.(bson.A); isArray {
+			for _, candidate := range values {
+				if candidate == condition {
+					return true
+				}
+			}
+			return false
+		}
+		return exists && value == condition
+	}
+
+	for _, operator := range operators {
+		switch operator.Key {
+		case "$exists":
+			if exists != operator.Value.(bool) {
+				return false
+			}
+		case "$in":
+			matched := false
+			switch candidates := operator.Value.(type) {
+			case []string:
+				for _, candidate := range candidates {
+					matched = matched || matchValue(t, value, exists, candidate)
+				}
+			case bson.A:
+				for _, candidate := range candidates {
+					matched = matched || matchValue(t, value, exists, candidate)
+				}
+			}
+			if !matched {
+				return false
+			}
+		case "$elemMatch":
+			matched := false
+			values, _ := value.(bson.A)
+			for _, element := range values {
+				matched = matched || matchDocument(t, toM(t, element), operator.Value.(bson.D))
+			}
+			if !matched {
+				return false
+			}
+		default:
+			t.Fatalf("operator %s not supported by the test matcher", operator.Key)
+		}
+	}
+	return true
+}
+
+func toM(t *testing.T, value interface{}) bson.M {
+	switch document := value.(type) {
+	case bson.M:
+		return document
+	case primitive.D:
+		return document.Map()
+	}
+	t.Fatalf("unexpected document type %T", value)
+	return nil
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const apiKeyPrefix = "vfu"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidAPIKey      = errors.New("invalid api key request")
+)
+
+// APIKeyManager, Controller minting and revoking API keys. It is also the IAuthenticator for
+// "Authorization: ApiKey <key>" and "X-API-Key: <key>" request headers.
+
+type APIKeyManager struct {
+	APIKeyDBWrapper interfaces.IAPIKeyDBWrapper
+}
+
+//MintKey, creating a key of the tenant with the given scopes, the returned plaintext key is not stored anywhere
+
+func (km *APIKeyManager) MintKey(tenant string, name string, scopes []string) (*models.APIKey, string, error) {
+	tenant = models.NormalizeTenant(tenant)
+	if err := models.ValidateTenant(tenant); err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrInvalidAPIKey, err.Error())
+	}
+	if strings.TrimSpace(name) == "" || len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: name and at least one scope are required", ErrInvalidAPIKey)
+	}
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return nil, "", fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKey, scope)
+		}
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	apiKey := models.APIKey{
+		Name:       name,
+		SecretHash: hashAPIKeySecret(secret),
+		Scopes:     scopes,
+		Tenant:     tenant,
+		CreatedAt:  primitive.NewDateTimeFromTime(time.Now()),
+	}
+	keyId, err := km.APIKeyDBWrapper.InsertAPIKey(&apiKey)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("API key insert failed!! Error: %s", err.Error()))
+		return nil, "", err
+	}
+	apiKey.KeyId = keyId
+	return &apiKey, fmt.Sprintf("%s_%s_%s", apiKeyPrefix, keyId, secret), nil
+}
+
+func (km *APIKeyManager) GetKeysList(tenant string) ([]*models.APIKey, error) {
+	return km.APIKeyDBWrapper.GetAPIKeysByTenant(tenant)
+}
+
+func (km *APIKeyManager) RevokeKey(tenant string, keyId string) (bool, error) {
+	apiKey, err := km.APIKeyDBWrapper.GetAPIKeyById(keyId)
+	if err != nil {
+		return false, err
+	}
+	if models.NormalizeTenant(apiKey.Tenant) != models.NormalizeTenant(tenant) {
+		return false, nil
+	}
+	revoked, err := km.APIKeyDBWrapper.RevokeAPIKeyById(keyId)
+	if err != nil {
+		return false, err
+	}
+	return revoked > 0, nil
+}
+
+func (km *APIKeyManager) Authenticate(request *http.Request, _ string) (*models.Principal, error) {
+	rawKey := request.Header.Get("X-API-Key")
+	if authorization := request.Header.Get("Authorization"); rawKey == "" && len(authorization) > 7 && strings.EqualFold(authorization[:7], "ApiKey ") {
+		rawKey = strings.TrimSpace(authorization[7:])
+	}
+	if rawKey == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(rawKey, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, ErrInvalidCredentials
+	}
+	apiKey, err := km.APIKeyDBWrapper.GetAPIKeyById(parts[1])
+	if err != nil {
+		if strings.Contains(err.Error(), "no document") || strings.Contains(err.Error(), "ObjectID") {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if apiKey.RevokedAt != 0 || subtle.ConstantTimeCompare([]byte(apiKey.SecretHash), []byte(hashAPIKeySecret(parts[2]))) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &models.Principal{
+		Id:     apiKey.KeyId,
+		Type:   models.PrincipalTypeAPIKey,
+		Name:   apiKey.Name,
+		Scopes: apiKey.Scopes,
+		Tenant: apiKey.Tenant,
+	}, nil
+}
+
+// hashAPIKeySecret, keys carry 256 bits of randomness so a plain SHA256 is enough at rest
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func isKnownScope(scope string) bool {
+	for _, knownScope := range models.Scopes {
+		if knownScope == scope {
+			return true
+		}
+	}
+	return false
+}
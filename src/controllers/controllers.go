@@ -1,13 +1,20 @@
 package controllers
 
 import (
+	"bytes"
 	logger "city_os/src/common"
 	"city_os/src/interfaces"
 	"city_os/src/models"
+	"city_os/src/tracing"
 	"city_os/src/utils"
+	"context"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	"strings"
 	"time"
 )
@@ -18,147 +25,387 @@ import (
 type VideoCatalogueManager struct {
 	VideoCatalogueDBWrapper interfaces.IDBWrapper
 	VideoFilesDBWrapper     interfaces.IFileManagerDBWrapper
+	FastStartOnIngest       bool // Rewrite MP4 uploads so that moov atom precedes mdat
+
+	JobQueue       interfaces.IJobQueue // Optional, post-upload processing is skipped without it
+	PostUploadJobs []string             // Job types enqueued for every stored Video file
+	JobMaxAttempts int
+
+	Outbox interfaces.IOutboxDBWrapper // Optional, records lifecycle events which aren't catalogue changes
+
+	Usage  interfaces.IUsageDBWrapper // Optional, usage is neither counted nor limited without it
+	Quotas models.QuotaLimits
+
+	Scanner      interfaces.IScanner // Optional, uploads are stored unscanned without it
+	ScanFailOpen bool                // Store uploads the scanner failed on instead of rejecting them
 }
 
 // GetVideoDocIdBySHAHash, to detect the duplicate video files,
 // it is first converting Video file bytes into SHA256 hash
-// and performing DB lookup for videos present in the system with the matching hash
+// and performing DB lookup for videos present in the caller's tenant with the matching hash
+
+func (db *VideoCatalogueManager) GetVideoDocIdBySHAHash(ctx context.Context, fileDataBytes []byte, principal *models.Principal) (_ string, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetVideoDocIdBySHAHash")
+	defer func() { tracing.End(span, err) }()
 
-func (db *VideoCatalogueManager) GetVideoDocIdBySHAHash(fileDataBytes []byte) (string, string, error) {
 	hash, err := utils.ToSHA256(fileDataBytes)
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("SHA conversion failed!! Error: %s", err.Error()))
+		logger.FromContext(ctx).Error(fmt.Sprintf("SHA conversion failed!! Error: %s", err.Error()))
 		return "", hash, err
 	}
 
-	doc, err := db.VideoCatalogueDBWrapper.GetSingleDocByFilter(bson.D{{"hash", hash}})
+	docId, err := db.GetVideoDocIdByHash(ctx, hash, principal)
+	return docId, hash, err
+}
+
+// GetVideoDocIdByHash, DB lookup for a video present in the caller's tenant with the given hash,
+// the same video uploaded by different tenants is stored once per tenant
+
+func (db *VideoCatalogueManager) GetVideoDocIdByHash(ctx context.Context, hash string, principal *models.Principal) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetVideoDocIdByHash")
+	defer func() { tracing.End(span, err) }()
+
+	tenant := models.TenantOf(principal)
+	doc, err := db.catalogue(tenant).GetSingleDocByFilter(ctx, bson.D{{Key: "hash", Value: hash}})
 	if err != nil && !strings.Contains(err.Error(), "no document") {
-		logger.Logger.Error(fmt.Printf("Fetching doc by SHA failed!! Error: %s", err.Error()))
-		return "", hash, err
+		logger.FromContext(ctx).Error(fmt.Sprintf("Fetching doc by SHA failed!! Error: %s", err.Error()))
+		return "", err
 	}
 
 	if doc != nil {
-		videoCatalogueData := doc.(*models.VideoCatalogueData)
-		return videoCatalogueData.FileId, hash, nil
+		span.SetAttributes(attribute.Bool("file.duplicate", true))
+		return doc.(*models.VideoCatalogueData).FileId, nil
 	}
-	return "", hash, nil
+	return "", nil
+}
+
+// RejectDuplicateUpload, recording the file.duplicate_rejected event of an upload rejected in favour of
+// the stored file with the same hash
+
+func (db *VideoCatalogueManager) RejectDuplicateUpload(ctx context.Context, fileId string, hash string, principal *models.Principal) {
+	_, span := tracing.Start(ctx, "VideoCatalogueManager.RejectDuplicateUpload", attribute.String("file.id", fileId))
+	defer tracing.End(span, nil)
+
+	db.publish(models.EventFileDuplicateRejected, models.TenantOf(principal), fileId, map[string]interface{}{
+		"fileid": fileId,
+		"hash":   hash,
+	})
+}
+
+// DuplicateFileError, a concurrent upload of the same file was stored while this one was being saved,
+// FileId is the stored file
+
+type DuplicateFileError struct {
+	FileId string
+}
+
+func (e *DuplicateFileError) Error() string {
+	return fmt.Sprintf("file exists: %s", e.FileId)
+}
+
+func (e *DuplicateFileError) Unwrap() error {
+	return models.ErrDuplicateFile
 }
 
 //SaveVideoFile, It is saving video files into the database,
-// first creating an entry into  Video Files Meta-Data Storing collection
-// then saving the video file bytes into  Video File Bytes Storing Collection in Bytes Chunks (255 KB by default)
+// first saving the video file bytes into  Video File Bytes Storing Collection in Bytes Chunks (255 KB by default)
+// then creating an entry into  Video Files Meta-Data Storing collection together with the file.created event,
+// so the event is never visible for a file whose bytes are missing.
+// The file of size bytes is streamed from fileData, read once for scanning and once for storing, hash is
+// the SHA-256 of its bytes.
+// MP4 files are optionally rewritten for fast-start playback, hash of the original bytes is kept for dedup.
+// The rewrite needs the whole file in memory, without it the file is never held in memory at once.
+// The file is charged to the usage of the tenant and the uploader first, an upload exceeding a quota is
+// rejected before any byte is stored. Uploads are scanned for malware before that, infected files are
+// stored quarantined with the file.quarantined event and returned with ErrFileQuarantined.
 
 func (db *VideoCatalogueManager) SaveVideoFile(
-	fileDataBytes []byte,
+	ctx context.Context,
+	fileData io.ReadSeeker,
+	size int64,
 	filename string,
 	fileMimeType string,
 	hash string,
-) (string, error) {
+	principal *models.Principal,
+) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.SaveVideoFile",
+		attribute.Int64("file.size", size),
+		attribute.String("file.mime_type", fileMimeType),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	scanResult, err := db.scan(ctx, fileData)
+	if err != nil {
+		return "", err
+	}
+	if _, err = fileData.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	quarantined := isInfected(scanResult)
+
+	fastStart, storedHash := false, ""
+	if db.FastStartOnIngest && fileMimeType == "video/mp4" && !quarantined {
+		_, fastStartSpan := tracing.Start(ctx, "VideoCatalogueManager.MP4FastStart")
+		fileDataBytes, err := io.ReadAll(fileData)
+		if err != nil {
+			return "", err
+		}
+		fileData = bytes.NewReader(fileDataBytes)
+		optimizedBytes, rewritten, err := utils.MP4FastStart(fileDataBytes)
+		fastStartSpan.End()
+		if err != nil {
+			logger.FromContext(ctx).Warn(fmt.Sprintf("Fast-start rewrite skipped, storing original!! Error: %s", err.Error()))
+		} else if rewritten {
+			fileData, size = bytes.NewReader(optimizedBytes), int64(len(optimizedBytes))
+			fastStart = true
+			if storedHash, err = utils.ToSHA256(optimizedBytes); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	processingStatus, eventType := models.ProcessingStatusReady, models.EventFileCreated
+	if quarantined {
+		processingStatus, eventType = models.ProcessingStatusQuarantined, models.EventFileQuarantined
+	} else if db.JobQueue != nil && len(db.PostUploadJobs) > 0 {
+		processingStatus = models.ProcessingStatusPending
+	}
 
 	videFileCatalogueObj := models.VideoCatalogueData{
+		FileId:    primitive.NewObjectID().Hex(),
 		Name:      filename,
-		Size:      len(fileDataBytes),
+		Size:      int(size),
 		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
 		FileType:  fileMimeType,
 		Hash:      hash,
+		FastStart: fastStart,
+
+		StoredHash:       storedHash,
+		ProcessingStatus: processingStatus,
+		Tenant:           models.TenantOf(principal),
+		Scan:             scanResult,
+	}
+	if principal != nil {
+		videFileCatalogueObj.Owner = principal.OwnerRef()
+		videFileCatalogueObj.Collection = principal.Collection
 	}
 
-	docId, err := db.VideoCatalogueDBWrapper.InsertDocument(videFileCatalogueObj)
+	if err := db.reserveUsage(ctx, &videFileCatalogueObj, principal); err != nil {
+		return "", err
+	}
 
+	docId, tenant := videFileCatalogueObj.FileId, videFileCatalogueObj.Tenant
+	span.SetAttributes(attribute.String("file.id", docId))
+	_, err = db.files(tenant).UploadFile(ctx, docId, fileData, size, filename)
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("Insert failed!! Error: %v", err.Error()))
+		logger.FromContext(ctx).Error(fmt.Sprintf("Upload file failed!! Error : %v", err.Error()))
+		db.releaseUsage(detach(ctx), &videFileCatalogueObj)
 		return "", err
 	}
 
-	_, err = db.VideoFilesDBWrapper.UploadFile(docId, fileDataBytes, filename)
+	event, err := newEvent(eventType, tenant, docId, &videFileCatalogueObj)
+	if err == nil {
+		_, err = db.catalogue(tenant).InsertDocumentWithEvent(ctx, videFileCatalogueObj, event)
+	}
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("Upload file failed!! Error : %v", err.Error()))
+		logger.FromContext(ctx).Error(fmt.Sprintf("Insert failed!! Error: %v", err.Error()))
+		if err := db.files(tenant).DeleteFileByFileId(detach(ctx), docId); err != nil {
+			logger.FromContext(ctx).Error(fmt.Sprintf("Removing orphaned file bytes failed!! fileId: %s, Error: %s", docId, err.Error()))
+		}
+		db.releaseUsage(detach(ctx), &videFileCatalogueObj)
+		if errors.Is(err, models.ErrDuplicateFile) {
+			// The upload lost the race against the same file, it is rejected like one found by the hash lookup
+			if existingId, lookupErr := db.GetVideoDocIdByHash(ctx, hash, principal); lookupErr == nil && existingId != "" {
+				return "", &DuplicateFileError{FileId: existingId}
+			}
+		}
 		return "", err
 	}
 
+	if processingStatus == models.ProcessingStatusPending {
+		if err = db.enqueuePostUploadJobs(tenant, docId); err != nil {
+			// File itself is stored, it just won't get processed
+			logger.FromContext(ctx).Error(fmt.Sprintf("Enqueueing post-upload jobs failed!! fileId: %s, Error: %s", docId, err.Error()))
+			if _, statusErr := db.transitionProcessingStatus(detach(ctx), tenant, docId, models.ProcessingStatusFailed,
+				models.ProcessingStatusPending); statusErr != nil {
+				logger.FromContext(ctx).Error(fmt.Sprintf("Updating processing status failed!! fileId: %s, Error: %s", docId, statusErr.Error()))
+			}
+		}
+	}
+
+	if quarantined {
+		logger.FromContext(ctx).Warn(fmt.Sprintf("Malware found, file quarantined!! fileId: %s, signature: %s", docId, scanResult.Signature))
+		return docId, fmt.Errorf("%w: %s", ErrFileQuarantined, scanResult.Signature)
+	}
 	return docId, nil
 }
 
 //GetFileByFileId, Fetching Video files data by Video file Id of Document ID of
-//Video Files Meta-Data storing collection
+//Video Files Meta-Data storing collection, the caller needs read permission on the file.
+//Quarantined files are not handed out. With a byteRange only the bytes of the range are read.
 
 func (db *VideoCatalogueManager) GetFileByFileId(
+	ctx context.Context,
 	fileId string,
-) (*models.VideoFileData, error) {
+	byteRange *models.ByteRange,
+	principal *models.Principal,
+) (_ *models.VideoFileData, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetFileByFileId", attribute.String("file.id", fileId))
+	defer func() { tracing.End(span, err) }()
 
-	videoCatalogueDataRaw, err := db.VideoCatalogueDBWrapper.GetDocumentById(fileId)
+	videoCatalogueData, err := db.GetFilesDataById(ctx, fileId, principal)
 	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("getDocumentById call failed!! Error:%s", err.Error()))
 		return nil, err
 	}
+	if videoCatalogueData.ProcessingStatus == models.ProcessingStatusQuarantined {
+		return nil, ErrFileQuarantined
+	}
 
-	videoCatalogueData := videoCatalogueDataRaw.(*models.VideoCatalogueData)
-	videoFileDataBytes, err := db.VideoFilesDBWrapper.DownloadFile(fileId, videoCatalogueData.Name)
-
-	if err != nil || len(videoFileDataBytes) == 0 {
-		logger.Logger.Error(fmt.Sprintf("getDocumentById call failed!! Error:%s", err.Error()))
+	totalSize := int64(videoCatalogueData.Size)
+	var offset int64
+	var videoFileDataBytes []byte
+	if byteRange != nil {
+		var length int64
+		var satisfiable bool
+		if offset, length, satisfiable = byteRange.Resolve(totalSize); !satisfiable {
+			return nil, &RangeNotSatisfiableError{Size: totalSize}
+		}
+		videoFileDataBytes, err = db.files(models.TenantOf(principal)).DownloadFileRange(ctx, fileId, videoCatalogueData.Name, offset, length)
+		if err == nil && int64(len(videoFileDataBytes)) != length {
+			err = fmt.Errorf("file %s has %d bytes in range %d-%d", fileId, len(videoFileDataBytes), offset, offset+length-1)
+		}
+	} else {
+		videoFileDataBytes, err = db.files(models.TenantOf(principal)).DownloadFile(ctx, fileId, videoCatalogueData.Name)
+		if err == nil && len(videoFileDataBytes) == 0 {
+			err = fmt.Errorf("file %s has no content", fileId)
+		}
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("getDocumentById call failed!! Error:%s", err.Error()))
 		return nil, err
 	}
 	fileData := models.VideoFileData{
-		videoCatalogueData.Name,
-		videoFileDataBytes,
-		videoCatalogueData.FileType,
+		Name:          videoCatalogueData.Name,
+		FileDataBytes: videoFileDataBytes,
+		FileMimeType:  videoCatalogueData.FileType,
+		Offset:        offset,
+		TotalSize:     totalSize,
 	}
 
 	return &fileData, nil
 }
 
-//GetFilesDataById, Fetching Video files meta data from Video Meta-Data storing Collection's Document ID
+// RangeNotSatisfiableError, the requested range is outside of the file of Size bytes
+type RangeNotSatisfiableError struct {
+	Size int64
+}
+
+func (e *RangeNotSatisfiableError) Error() string {
+	return fmt.Sprintf("range not satisfiable, file size is %d bytes", e.Size)
+}
 
-func (db *VideoCatalogueManager) GetFilesDataById(fileId string) (*models.VideoCatalogueData, error) {
-	videoCatalogueDataRaw, err := db.VideoCatalogueDBWrapper.GetDocumentById(fileId)
-	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("getDocumentById call failed!! Error:%s", err.Error()))
-		return nil, err
-	}
+//GetFilesDataById, Fetching Video files meta data from Video Meta-Data storing Collection's Document ID,
+//the caller needs read permission on the file
 
-	videoCatalogueData := videoCatalogueDataRaw.(*models.VideoCatalogueData)
-	return videoCatalogueData, nil
+func (db *VideoCatalogueManager) GetFilesDataById(ctx context.Context, fileId string, principal *models.Principal) (_ *models.VideoCatalogueData, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetFilesDataById", attribute.String("file.id", fileId))
+	defer func() { tracing.End(span, err) }()
+
+	return db.getAuthorizedFileData(ctx, fileId, principal, models.PermissionRead)
 }
 
-//GetVideoFilesList, Fetching video files list with meta information.
+//GetVideoFilesList, Fetching video files list with meta information, limited to the files the caller can read
+//and to the collection if one is given.
+
+func (db *VideoCatalogueManager) GetVideoFilesList(ctx context.Context, collection string, principal *models.Principal) (_ []*models.VideoFilesDataResponse, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetVideoFilesList")
+	defer func() { tracing.End(span, err) }()
 
-func (db *VideoCatalogueManager) GetVideoFilesList() ([]*models.VideoFilesDataResponse, error) {
-	videosListRaw, err := db.VideoCatalogueDBWrapper.GetAllDocuments()
+	filter := readableFilesFilter(principal)
+	if collection != "" {
+		filter = append(filter, bson.E{Key: "collection", Value: collection})
+	}
+	videosListRaw, err := db.catalogue(models.TenantOf(principal)).GetDocumentsByFilter(ctx, filter)
 	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("getAllDocuments call failed, Error: %s", err.Error()))
+		logger.FromContext(ctx).Error(fmt.Sprintf("getAllDocuments call failed, Error: %s", err.Error()))
 		return nil, err
 	}
 	videosList := make([]*models.VideoFilesDataResponse, 0)
 	for _, videoDataRaw := range videosListRaw {
 		videoData := videoDataRaw.(*models.VideoCatalogueData)
 		videosList = append(videosList, &models.VideoFilesDataResponse{
-			videoData.FileId,
-			videoData.Name,
-			videoData.Size,
-			videoData.CreatedAt,
+			FileId:     videoData.FileId,
+			Name:       videoData.Name,
+			Size:       videoData.Size,
+			CreatedAt:  videoData.CreatedAt,
+			Collection: videoData.Collection,
 		})
 	}
 	return videosList, nil
 }
 
-//DeleteVideoFile, Deleting video files by Video Meta-Data storing Collection
+//DeleteVideoFile, Deleting video files by Video Meta-Data storing Collection, the caller needs delete
+//permission on the file. Usage charged for the file is returned once its catalogue entry is gone.
+
+func (db *VideoCatalogueManager) DeleteVideoFile(ctx context.Context, fileid string, principal *models.Principal) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.DeleteVideoFile", attribute.String("file.id", fileid))
+	defer func() { tracing.End(span, err) }()
 
-func (db *VideoCatalogueManager) DeleteVideoFile(fileid string) (bool, error) {
-	_, err := db.VideoCatalogueDBWrapper.GetDocumentById(fileid)
+	videoCatalogueDataRaw, err := db.getAuthorizedFileData(ctx, fileid, principal, models.PermissionDelete)
 	if err != nil {
 		return false, err
 	}
 
-	_, err = db.VideoCatalogueDBWrapper.DeleteDocumentById(fileid)
+	tenant := models.TenantOf(principal)
+	event, err := newEvent(models.EventFileDeleted, tenant, fileid, videoCatalogueDataRaw)
 	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("Delete doc failed!! Error: %s", err.Error()))
 		return false, err
 	}
+	deletedCount, err := db.catalogue(tenant).DeleteDocumentByIdWithEvent(ctx, fileid, event)
+	if err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Delete doc failed!! Error: %s", err.Error()))
+		return false, err
+	}
+	// a concurrent delete of the same file has already returned its usage
+	if deletedCount == 1 {
+		db.releaseUsage(detach(ctx), videoCatalogueDataRaw)
+	}
 
-	if err := db.VideoFilesDBWrapper.DeleteFileByFileId(fileid); err != nil {
-		logger.Logger.Error(fmt.Sprintf("Doc partially deleted!! Error: %s", err.Error()))
+	// The document is gone, the bytes have to follow even when the client went away meanwhile
+	if err := db.files(tenant).DeleteFileByFileId(detach(ctx), fileid); err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Doc partially deleted!! Error: %s", err.Error()))
 		return false, err
 	}
 	return true, nil
 }
+
+// catalogue and files, storage of the tenant's catalogue documents and Video file bytes
+
+func (db *VideoCatalogueManager) catalogue(tenant string) interfaces.IDBWrapper {
+	return db.VideoCatalogueDBWrapper.ForTenant(tenant)
+}
+
+func (db *VideoCatalogueManager) files(tenant string) interfaces.IFileManagerDBWrapper {
+	return db.VideoFilesDBWrapper.ForTenant(tenant)
+}
+
+// publish, recording lifecycle events which are not part of a catalogue change, failures are only logged
+
+func (db *VideoCatalogueManager) publish(eventType string, tenant string, fileId string, data interface{}) {
+	if db.Outbox == nil {
+		return
+	}
+	event, err := newEvent(eventType, tenant, fileId, data)
+	if err == nil {
+		_, err = db.Outbox.AppendEvent(event)
+	}
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Recording event failed!! event: %s, fileId: %s, Error: %s", eventType, fileId, err.Error()))
+	}
+}
+
+// detach, context for finishing or undoing a change that was already started, it keeps the trace of
+// ctx but not its cancellation. Operations still get their own deadlines in dbconnectors.
+func detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"bytes"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"sync"
+	"testing"
+)
+
+// memoryCatalogue, catalogue with the unique hash index of the Mongo wrapper, shared by all tenants
+type memoryCatalogue struct {
+	mu        sync.Mutex
+	documents map[string]*models.VideoCatalogueData
+	insertErr error // returned by the next insert instead of storing
+
+	beforeDelete func() // called before the next delete, without the lock
+}
+
+func newMemoryCatalogue() *memoryCatalogue {
+	return &memoryCatalogue{documents: map[string]*models.VideoCatalogueData{}}
+}
+
+func (m *memoryCatalogue) GetDocumentById(_ context.Context, id string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if document, found := m.documents[id]; found {
+		copied := *document
+		return &copied, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryCatalogue) GetAllDocuments(ctx context.Context) ([]interface{}, error) {
+	return m.GetDocumentsByFilter(ctx, bson.D{})
+}
+
+func (m *memoryCatalogue) GetDocumentsByFilter(context.Context, interface{}) ([]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	documents := make([]interface{}, 0, len(m.documents))
+	for _, document := range m.documents {
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+func (m *memoryCatalogue) DeleteDocumentById(ctx context.Context, id string) (int64, error) {
+	return m.DeleteDocumentByIdWithEvent(ctx, id, nil)
+}
+
+func (m *memoryCatalogue) InsertDocument(ctx context.Context, insertData interface{}) (string, error) {
+	return m.InsertDocumentWithEvent(ctx, insertData, nil)
+}
+
+func (m *memoryCatalogue) GetSingleDocByFilter(_ context.Context, filterCondition interface{}) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash := filterCondition.(bson.D).Map()["hash"]
+	for _, document := range m.documents {
+		if document.Hash == hash {
+			return document, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryCatalogue) UpdateDocumentById(ctx context.Context, id string, setFields interface{}) (int64, error) {
+	return m.UpdateDocumentByIdAndFilter(ctx, id, nil, setFields)
+}
+
+func (m *memoryCatalogue) UpdateDocumentByIdAndFilter(_ context.Context, id string, _ interface{}, _ interface{}) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.documents[id]; found {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *memoryCatalogue) InsertDocumentWithEvent(_ context.Context, insertData interface{}, _ *models.Event) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.insertErr; err != nil {
+		m.insertErr = nil
+		return "", err
+	}
+	document := insertData.(models.VideoCatalogueData)
+	for _, stored := range m.documents {
+		if stored.Hash == document.Hash {
+			return "", fmt.Errorf("%w: E11000 duplicate key error", models.ErrDuplicateFile)
+		}
+	}
+	m.documents[document.FileId] = &document
+	return document.FileId, nil
+}
+
+func (m *memoryCatalogue) DeleteDocumentByIdWithEvent(_ context.Context, id string, _ *models.Event) (int64, error) {
+	if m.beforeDelete != nil {
+		m.beforeDelete()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.documents[id]; !found {
+		return 0, nil
+	}
+	delete(m.documents, id)
+	return 1, nil
+}
+
+func (m *memoryCatalogue) ForTenant(string) interfaces.IDBWrapper {
+	return m
+}
+
+// memoryFiles, file bytes store
+type memoryFiles struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemoryFiles() *memoryFiles {
+	return &memoryFiles{files: map[string][]byte{}}
+}
+
+func (m *memoryFiles) UploadFile(_ context.Context, fileID string, fileData io.Reader, _ int64, _ string) (int, error) {
+	fileDataBytes, err := io.ReadAll(fileData)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[fileID] = fileDataBytes
+	return len(fileDataBytes), nil
+}
+
+func (m *memoryFiles) DownloadFile(_ context.Context, fileID string, _ string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data, found := m.files[fileID]; found {
+		return data, nil
+	}
+	return nil, errors.New("file not found")
+}
+
+func (m *memoryFiles) DownloadFileRange(ctx context.Context, fileID string, filename string, offset int64, length int64) ([]byte, error) {
+	data, err := m.DownloadFile(ctx, fileID, filename)
+	if err != nil || offset >= int64(len(data)) {
+		return []byte{}, err
+	}
+	if offset+length > int64(len(data)) {
+		length = int64(len(data)) - offset
+	}
+	return data[offset : offset+length], nil
+}
+
+func (m *memoryFiles) DeleteFileByFileId(_ context.Context, fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, fileID)
+	return nil
+}
+
+func (m *memoryFiles) ForTenant(string) interfaces.IFileManagerDBWrapper {
+	return m
+}
+
+func (m *memoryFiles) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.files)
+}
+
+func TestSaveVideoFileLosingDuplicateRace(t *testing.T) {
+	catalogue, files := newMemoryCatalogue(), newMemoryFiles()
+	manager := &VideoCatalogueManager{VideoCatalogueDBWrapper: catalogue, VideoFilesDBWrapper: files}
+
+	storedId, err := manager.SaveVideoFile(context.Background(), bytes.NewReader([]byte("video")), 5, "first.mp4", "video/mp4", "hash-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second upload passed the hash lookup before the first one was stored
+	_, err = manager.SaveVideoFile(context.Background(), bytes.NewReader([]byte("video")), 5, "second.mp4", "video/mp4", "hash-1", nil)
+	var duplicate *DuplicateFileError
+	if !errors.As(err, &duplicate) {
+		t.Fatalf("expected DuplicateFileError, got %v", err)
+	}
+	if duplicate.FileId != storedId {
+		t.Fatalf("duplicate points to %s, expected %s", duplicate.FileId, storedId)
+	}
+	if !errors.Is(err, models.ErrDuplicateFile) {
+		t.Fatal("DuplicateFileError doesn't wrap ErrDuplicateFile")
+	}
+	if files.count() != 1 {
+		t.Fatalf("%d stored files, the bytes of the rejected upload were kept", files.count())
+	}
+}
+
+func TestSaveVideoFileRemovesBytesOfFailedInsert(t *testing.T) {
+	catalogue, files := newMemoryCatalogue(), newMemoryFiles()
+	catalogue.insertErr = errors.New("connection reset")
+	manager := &VideoCatalogueManager{VideoCatalogueDBWrapper: catalogue, VideoFilesDBWrapper: files}
+
+	_, err := manager.SaveVideoFile(context.Background(), bytes.NewReader([]byte("video")), 5, "video.mp4", "video/mp4", "hash-1", nil)
+	var duplicate *DuplicateFileError
+	if err == nil || errors.As(err, &duplicate) {
+		t.Fatalf("expected the insert error, got %v", err)
+	}
+	if files.count() != 0 {
+		t.Fatal("bytes of the file whose insert failed were kept")
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"city_os/src/utils"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"time"
+)
+
+// EventManager, serves the change feed from the outbox and relays outbox events to the publisher
+// (webhooks). An event is marked published only after the publisher accepted it, so events written
+// right before a crash are picked up by the relay after restart.
+
+type EventManager struct {
+	Outbox       interfaces.IOutboxDBWrapper
+	Publisher    interfaces.IEventPublisher
+	PollInterval time.Duration
+	ClaimFor     time.Duration
+}
+
+//GetEvents, long-polling the outbox for the tenant's events after the since cursor
+
+func (em *EventManager) GetEvents(tenant string, since int64, limit int64, wait time.Duration, done <-chan struct{}) ([]*models.Event, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		events, err := em.Outbox.GetEventsSince(tenant, since, limit)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Fetching events failed!! Error: %s", err.Error()))
+			return nil, err
+		}
+		if len(events) > 0 || !time.Now().Before(deadline) {
+			return events, nil
+		}
+
+		select {
+		case <-done:
+			return events, nil
+		case <-time.After(em.PollInterval):
+		}
+	}
+}
+
+//RunRelay, publishing outbox events in sequence order until ctx is cancelled. An event the publisher
+//fails on is retried with growing delays, later events wait for it.
+
+func (em *EventManager) RunRelay(ctx context.Context) {
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d-relay", hostname, os.Getpid())
+	logger.FromContext(ctx).Info("Outbox relay started")
+
+	// The claim is renewed on every retry, so the delay stays below it
+	retryDelay, maxRetryDelay := em.PollInterval, em.ClaimFor/2
+	for ctx.Err() == nil {
+		event, err := em.Outbox.ClaimNextUnpublishedEvent(owner, em.ClaimFor)
+		if err != nil {
+			logger.FromContext(ctx).Error(fmt.Sprintf("Claiming outbox event failed!! Error: %s", err.Error()))
+		}
+		if event == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(em.PollInterval):
+			}
+			continue
+		}
+
+		if err = em.Publisher.Publish(event); err != nil {
+			// The next claim returns the same event, still claimed by this relay
+			logger.FromContext(ctx).Error(fmt.Sprintf("Publishing outbox event failed, retrying in %s!! seq: %d, Error: %s", retryDelay, event.Seq, err.Error()))
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+			if retryDelay *= 2; retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+			continue
+		}
+		retryDelay = em.PollInterval
+		if err = em.Outbox.MarkEventPublished(event.Seq, owner); err != nil {
+			logger.FromContext(ctx).Error(fmt.Sprintf("Marking outbox event published failed!! seq: %d, Error: %s", event.Seq, err.Error()))
+		}
+	}
+}
+
+// newEvent, building the outbox envelope, data is stored with its bson field names
+
+func newEvent(eventType string, tenant string, fileId string, data interface{}) (*models.Event, error) {
+	eventData, err := utils.ToBsonM(data)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Event{
+		EventId:   primitive.NewObjectID().Hex(),
+		Type:      eventType,
+		FileId:    fileId,
+		Tenant:    tenant,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+		Data:      eventData,
+	}, nil
+}
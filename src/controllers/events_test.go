@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"city_os/src/models"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOutbox, outbox with the claim rules of the Mongo wrapper: only the lowest unpublished event can
+// be claimed and only by its owner until the claim runs out
+type memoryOutbox struct {
+	mu     sync.Mutex
+	events []*models.Event
+	now    func() time.Time
+}
+
+func (o *memoryOutbox) AppendEvent(event *models.Event) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	event.Seq = int64(len(o.events) + 1)
+	o.events = append(o.events, event)
+	return event.Seq, nil
+}
+
+func (o *memoryOutbox) GetEventsSince(tenant string, seq int64, limit int64) ([]*models.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	events := make([]*models.Event, 0)
+	for _, event := range o.events {
+		sameTenant := models.NormalizeTenant(event.Tenant) == models.NormalizeTenant(tenant)
+		if sameTenant && event.Seq > seq && int64(len(events)) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (o *memoryOutbox) ClaimNextUnpublishedEvent(owner string, claimFor time.Duration) (*models.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	if o.now != nil {
+		now = o.now()
+	}
+	for _, event := range o.events {
+		if event.Published {
+			continue
+		}
+		if event.ClaimOwner != "" && event.ClaimOwner != owner && event.ClaimUntil.Time().After(now) {
+			return nil, nil
+		}
+		event.ClaimOwner = owner
+		event.ClaimUntil = primitive.NewDateTimeFromTime(now.Add(claimFor))
+		claimed := *event
+		return &claimed, nil
+	}
+	return nil, nil
+}
+
+func (o *memoryOutbox) MarkEventPublished(seq int64, owner string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if event := o.events[seq-1]; event.ClaimOwner == owner {
+		event.Published = true
+	}
+	return nil
+}
+
+// flakyPublisher, failing the first failures[seq] attempts of an event
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failures  map[int64]int
+	published []int64
+	attempts  map[int64]int
+}
+
+func (p *flakyPublisher) Publish(event *models.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts[event.Seq]++
+	if p.attempts[event.Seq] <= p.failures[event.Seq] {
+		return errors.New("receiver down")
+	}
+	p.published = append(p.published, event.Seq)
+	return nil
+}
+
+func (p *flakyPublisher) publishedSeqs() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int64(nil), p.published...)
+}
+
+func appendEvents(t *testing.T, outbox *memoryOutbox, count int) {
+	for i := 0; i < count; i++ {
+		if _, err := outbox.AppendEvent(&models.Event{Type: models.EventFileCreated}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunRelayPublishesStrictlyInOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures map[int64]int
+	}{
+		{name: "no failures", failures: map[int64]int{}},
+		{name: "first event retried", failures: map[int64]int{1: 3}},
+		{name: "middle event retried", failures: map[int64]int{3: 2}},
+		{name: "several events retried", failures: map[int64]int{2: 1, 4: 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outbox := &memoryOutbox{}
+			appendEvents(t, outbox, 5)
+			publisher := &flakyPublisher{failures: test.failures, attempts: map[int64]int{}}
+			em := &EventManager{Outbox: outbox, Publisher: publisher, PollInterval: time.Millisecond, ClaimFor: time.Minute}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				em.RunRelay(ctx)
+				close(done)
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for len(publisher.publishedSeqs()) < 5 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			published := publisher.publishedSeqs()
+			if len(published) != 5 {
+				t.Fatalf("published %v, expected 5 events", published)
+			}
+			for i, seq := range published {
+				if seq != int64(i+1) {
+					t.Fatalf("published %v, expected sequence order", published)
+				}
+			}
+			for seq, failures := range test.failures {
+				if publisher.attempts[seq] != failures+1 {
+					t.Fatalf("event %d attempted %d times, expected %d", seq, publisher.attempts[seq], failures+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRelayWaitsForForeignClaim(t *testing.T) {
+	now := time.Now()
+	outbox := &memoryOutbox{now: func() time.Time { return now }}
+	appendEvents(t, outbox, 2)
+	if event, _ := outbox.ClaimNextUnpublishedEvent("other-relay", time.Minute); event == nil || event.Seq != 1 {
+		t.Fatalf("claim of other relay got %v, expected event 1", event)
+	}
+
+	publisher := &flakyPublisher{failures: map[int64]int{}, attempts: map[int64]int{}}
+	em := &EventManager{Outbox: outbox, Publisher: publisher, PollInterval: time.Millisecond, ClaimFor: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	em.RunRelay(ctx)
+
+	if published := publisher.publishedSeqs(); len(published) != 0 {
+		t.Fatalf("published %v while event 1 was claimed by another relay", published)
+	}
+}
+
+func TestGetEventsResumesWithoutGaps(t *testing.T) {
+	outbox := &memoryOutbox{}
+	var expected []int64
+	for i := 0; i < 14; i++ {
+		// Events of another tenant in between don't show up in the feed
+		event := &models.Event{Type: models.EventFileCreated}
+		if i%2 == 1 {
+			event.Tenant = "acme"
+		}
+		seq, _ := outbox.AppendEvent(event)
+		if i%2 == 0 {
+			expected = append(expected, seq)
+		}
+	}
+	em := &EventManager{Outbox: outbox, PollInterval: time.Millisecond}
+
+	var since int64
+	var seen []int64
+	for {
+		events, err := em.GetEvents("", since, 3, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, event := range events {
+			seen = append(seen, event.Seq)
+		}
+		since = events[len(events)-1].Seq
+	}
+	if len(seen) != len(expected) {
+		t.Fatalf("resumed reads returned %v, expected %v", seen, expected)
+	}
+	for i := range seen {
+		if seen[i] != expected[i] {
+			t.Fatalf("resumed reads returned %v, expected %v", seen, expected)
+		}
+	}
+}
+
+func TestGetEventsLongPollReturnsNewEvent(t *testing.T) {
+	outbox := &memoryOutbox{}
+	appendEvents(t, outbox, 2)
+	em := &EventManager{Outbox: outbox, PollInterval: time.Millisecond}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		outbox.AppendEvent(&models.Event{Type: models.EventFileCreated})
+	}()
+	events, err := em.GetEvents("", 2, 10, 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Seq != 3 {
+		t.Fatalf("long poll returned %v, expected event 3", events)
+	}
+}
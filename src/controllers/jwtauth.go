@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"city_os/src/jwt"
+	"city_os/src/models"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// JWTAuthenticator, IAuthenticator for "Authorization: Bearer <jwt>" tokens issued by the OIDC provider.
+// Claims are mapped to a user principal, scopes come from the token's scope claim and from RoleScopes
+// of the roles it carries.
+
+type JWTAuthenticator struct {
+	Verifier     *jwt.Verifier
+	SubjectClaim string
+	NameClaim    string
+	RolesClaim   string
+	ScopeClaim   string
+	GroupsClaim  string
+	TenantClaim  string // tokens without the claim belong to the default tenant
+	RoleScopes   map[string][]string
+}
+
+func (ja *JWTAuthenticator) Authenticate(request *http.Request, _ string) (*models.Principal, error) {
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) <= 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, nil
+	}
+
+	claims, err := ja.Verifier.Verify(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err.Error())
+	}
+	subject := claims.String(ja.SubjectClaim)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: token has no %s claim", ErrInvalidCredentials, ja.SubjectClaim)
+	}
+
+	tenant := claims.String(ja.TenantClaim)
+	if tenant != "" {
+		if err = models.ValidateTenant(tenant); err != nil {
+			return nil, fmt.Errorf("%w: %s claim: %s", ErrInvalidCredentials, ja.TenantClaim, err.Error())
+		}
+	}
+
+	principal := &models.Principal{
+		Id:     subject,
+		Type:   models.PrincipalTypeUser,
+		Name:   claims.String(ja.NameClaim),
+		Roles:  claims.Strings(ja.RolesClaim),
+		Groups: claims.Strings(ja.GroupsClaim),
+		Tenant: tenant,
+		Scopes: []string{},
+	}
+	grant := func(scope string) {
+		if isKnownScope(scope) && !principal.HasScope(scope) {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+	for _, scope := range claims.Strings(ja.ScopeClaim) {
+		grant(scope)
+	}
+	for _, role := range principal.Roles {
+		for _, scope := range ja.RoleScopes[strings.ToLower(role)] {
+			grant(scope)
+		}
+	}
+	return principal, nil
+}
@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = log.New()
+	logger.Logger.Out = io.Discard
+	os.Exit(m.Run())
+}
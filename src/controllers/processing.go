@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/jobs"
+	"city_os/src/models"
+	"city_os/src/tracing"
+	"city_os/src/utils"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
+)
+
+// enqueuePostUploadJobs, queueing the configured processing jobs for a freshly stored Video file
+
+func (db *VideoCatalogueManager) enqueuePostUploadJobs(tenant string, fileId string) error {
+	for _, jobType := range db.PostUploadJobs {
+		jobId, err := db.JobQueue.Enqueue(&models.Job{
+			Type:        jobType,
+			FileId:      fileId,
+			Tenant:      tenant,
+			MaxAttempts: db.JobMaxAttempts,
+		})
+		if err != nil {
+			return err
+		}
+		logger.Logger.Info(fmt.Sprintf("Job enqueued!! jobId: %s, type: %s, fileId: %s", jobId, jobType, fileId))
+	}
+	return nil
+}
+
+//GetJobById, Fetching the state of an asynchronous job, jobs of other tenants are not visible
+
+func (db *VideoCatalogueManager) GetJobById(ctx context.Context, jobId string, principal *models.Principal) (_ *models.Job, err error) {
+	_, span := tracing.Start(ctx, "VideoCatalogueManager.GetJobById", attribute.String("job.id", jobId))
+	defer func() { tracing.End(span, err) }()
+
+	if db.JobQueue == nil {
+		return nil, errors.New("job queue is not configured")
+	}
+	job, err := db.JobQueue.GetJobById(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if models.NormalizeTenant(job.Tenant) != models.TenantOf(principal) {
+		return nil, fmt.Errorf("%w: job belongs to another tenant", ErrAccessDenied)
+	}
+	return job, nil
+}
+
+//RegisterJobProcessors, wiring the post-upload job handlers and status hooks into a worker pool
+
+func (db *VideoCatalogueManager) RegisterJobProcessors(pool *jobs.WorkerPool) {
+	pool.Register(models.JobTypeProbe, jobs.JobProcessor{
+		Handle:         db.probeJob,
+		OnSucceeded:    db.jobSucceeded,
+		OnDeadLettered: db.jobDeadLettered,
+	})
+	pool.Register(models.JobTypeRehash, jobs.JobProcessor{
+		Handle:         db.rehashJob,
+		OnSucceeded:    db.jobSucceeded,
+		OnDeadLettered: db.jobDeadLettered,
+	})
+}
+
+// loadJobFile, fetching catalogue data and stored bytes of the job's file. Nil data without error
+// means the file was deleted in the meantime and the job has nothing left to do.
+
+func (db *VideoCatalogueManager) loadJobFile(ctx context.Context, job *models.Job) (*models.VideoCatalogueData, []byte, error) {
+	videoCatalogueDataRaw, err := db.catalogue(job.Tenant).GetDocumentById(ctx, job.FileId)
+	if err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	videoCatalogueData := videoCatalogueDataRaw.(*models.VideoCatalogueData)
+	if _, err = db.transitionProcessingStatus(ctx, job.Tenant, job.FileId, models.ProcessingStatusProcessing,
+		models.ProcessingStatusPending); err != nil {
+		return nil, nil, err
+	}
+
+	fileDataBytes, err := db.files(job.Tenant).DownloadFile(ctx, job.FileId, videoCatalogueData.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return videoCatalogueData, fileDataBytes, nil
+}
+
+// probeJob, extracting media information from the stored file
+
+func (db *VideoCatalogueManager) probeJob(ctx context.Context, job *models.Job) error {
+	videoCatalogueData, fileDataBytes, err := db.loadJobFile(ctx, job)
+	if err != nil || videoCatalogueData == nil {
+		return err
+	}
+	if videoCatalogueData.FileType != "video/mp4" {
+		return nil
+	}
+
+	duration, err := utils.MP4Duration(fileDataBytes)
+	if err != nil {
+		// Broken container won't get better with retries
+		logger.FromContext(ctx).Warn(fmt.Sprintf("Probing video failed!! fileId: %s, Error: %s", job.FileId, err.Error()))
+		return nil
+	}
+	_, err = db.catalogue(job.Tenant).UpdateDocumentById(ctx, job.FileId, map[string]interface{}{
+		"duration_ms": duration.Milliseconds(),
+	})
+	return err
+}
+
+// rehashJob, verifying the stored bytes against the hash recorded at upload time
+
+func (db *VideoCatalogueManager) rehashJob(ctx context.Context, job *models.Job) error {
+	videoCatalogueData, fileDataBytes, err := db.loadJobFile(ctx, job)
+	if err != nil || videoCatalogueData == nil {
+		return err
+	}
+
+	hash, err := utils.ToSHA256(fileDataBytes)
+	if err != nil {
+		return err
+	}
+	expectedHash := videoCatalogueData.Hash
+	if videoCatalogueData.StoredHash != "" {
+		expectedHash = videoCatalogueData.StoredHash
+	}
+	if hash != expectedHash {
+		return fmt.Errorf("stored bytes hash mismatch, expected %s got %s", expectedHash, hash)
+	}
+	return nil
+}
+
+// jobSucceeded, the file is ready once the last of its jobs succeeded. A dead sibling has failed the file
+// or is about to, and of concurrent last jobs only the one which moves the file to ready publishes.
+
+func (db *VideoCatalogueManager) jobSucceeded(job *models.Job) {
+	if job.FileId == "" {
+		return
+	}
+	outstanding, err := db.JobQueue.CountOutstandingJobs(job.FileId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Counting outstanding jobs failed!! fileId: %s, Error: %s", job.FileId, err.Error()))
+		return
+	}
+	if outstanding > 0 {
+		return
+	}
+	dead, err := db.JobQueue.CountDeadJobs(job.FileId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Counting dead jobs failed!! fileId: %s, Error: %s", job.FileId, err.Error()))
+		return
+	}
+	if dead > 0 {
+		return
+	}
+	db.finishProcessing(context.Background(), job.Tenant, job.FileId, models.ProcessingStatusReady)
+}
+
+func (db *VideoCatalogueManager) jobDeadLettered(job *models.Job, _ error) {
+	if job.FileId != "" {
+		db.finishProcessing(context.Background(), job.Tenant, job.FileId, models.ProcessingStatusFailed)
+	}
+}
+
+// finishProcessing, moving a file still in processing to its final status, file.processed is only
+// published by the caller which made the move
+func (db *VideoCatalogueManager) finishProcessing(ctx context.Context, tenant string, fileId string, status string) {
+	moved, err := db.transitionProcessingStatus(ctx, tenant, fileId, status,
+		models.ProcessingStatusPending, models.ProcessingStatusProcessing)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Updating processing status failed!! fileId: %s, Error: %s", fileId, err.Error()))
+		return
+	}
+	if moved {
+		db.publishProcessed(tenant, fileId, status)
+	}
+}
+
+func (db *VideoCatalogueManager) publishProcessed(tenant string, fileId string, status string) {
+	db.publish(models.EventFileProcessed, models.NormalizeTenant(tenant), fileId, map[string]interface{}{
+		"fileid":            fileId,
+		"processing_status": status,
+	})
+}
+
+// transitionProcessingStatus, setting the file's processing status while it is in one of the from states,
+// false when it wasn't
+func (db *VideoCatalogueManager) transitionProcessingStatus(ctx context.Context, tenant string, fileId string, status string, from ...string) (bool, error) {
+	matched, err := db.catalogue(tenant).UpdateDocumentByIdAndFilter(ctx, fileId,
+		bson.M{"processing_status": bson.M{"$in": from}},
+		map[string]interface{}{"processing_status": status},
+	)
+	return matched > 0, err
+}
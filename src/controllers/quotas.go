@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"city_os/src/tracing"
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrQuotaExceeded    = errors.New("storage quota exceeded")
+	ErrFileExceedsQuota = errors.New("file is larger than the storage quota")
+)
+
+// reserveUsage, charging a new file to the usage of its tenant and of the uploading principal before its
+// bytes are stored. The file records what was charged, so deleting it returns exactly that. The principal is
+// charged as the owner of the file, which is the subject GetUsage reports.
+
+func (db *VideoCatalogueManager) reserveUsage(ctx context.Context, videoCatalogueData *models.VideoCatalogueData, principal *models.Principal) error {
+	if db.Usage == nil {
+		return nil
+	}
+	tenant, size := videoCatalogueData.Tenant, int64(videoCatalogueData.Size)
+	if err := db.reserve(ctx, tenant, models.UsageSubjectTenant, size, db.Quotas.TenantQuota(tenant)); err != nil {
+		return err
+	}
+	if principal != nil {
+		if err := db.reserve(ctx, tenant, principal.OwnerRef(), size, db.Quotas.User); err != nil {
+			db.release(detach(ctx), tenant, models.UsageSubjectTenant, size)
+			return err
+		}
+		videoCatalogueData.ChargedTo = principal.OwnerRef()
+	}
+	videoCatalogueData.UsageCharged = true
+	return nil
+}
+
+func (db *VideoCatalogueManager) reserve(ctx context.Context, tenant string, subject string, size int64, quota models.Quota) error {
+	if quota.MaxBytes > 0 && size > quota.MaxBytes {
+		return fmt.Errorf("%w: file has %d bytes, %s quota is %d bytes", ErrFileExceedsQuota, size, subject, quota.MaxBytes)
+	}
+	reserved, err := db.Usage.ReserveUsage(ctx, tenant, subject, size, 1, quota)
+	if err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Reserving usage failed!! tenant: %s, subject: %s, Error: %s", tenant, subject, err.Error()))
+		return err
+	}
+	if !reserved {
+		return fmt.Errorf("%w: %s quota is %s", ErrQuotaExceeded, subject, describeQuota(quota))
+	}
+	return nil
+}
+
+// releaseUsage, returning the usage charged for a file which is deleted or couldn't be stored,
+// failures are only logged. ctx has to outlive the request, the change it undoes or follows already happened.
+
+func (db *VideoCatalogueManager) releaseUsage(ctx context.Context, videoCatalogueData *models.VideoCatalogueData) {
+	if db.Usage == nil || !videoCatalogueData.UsageCharged {
+		return
+	}
+	tenant, size := videoCatalogueData.Tenant, int64(videoCatalogueData.Size)
+	db.release(ctx, tenant, models.UsageSubjectTenant, size)
+	if videoCatalogueData.ChargedTo != "" {
+		db.release(ctx, tenant, videoCatalogueData.ChargedTo, size)
+	}
+}
+
+func (db *VideoCatalogueManager) release(ctx context.Context, tenant string, subject string, size int64) {
+	if err := db.Usage.ReleaseUsage(ctx, tenant, subject, size, 1); err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Releasing usage failed!! tenant: %s, subject: %s, bytes: %d, Error: %s", tenant, subject, size, err.Error()))
+	}
+}
+
+//GetUsage, consumption of the caller's tenant and of the caller itself against their quotas, the caller
+//as charged for its uploads, e.g. the principal which minted the upload URL for a share
+
+func (db *VideoCatalogueManager) GetUsage(ctx context.Context, principal *models.Principal) (_ *models.UsageReport, err error) {
+	ctx, span := tracing.Start(ctx, "VideoCatalogueManager.GetUsage")
+	defer func() { tracing.End(span, err) }()
+
+	tenant := models.TenantOf(principal)
+	tenantUsage, err := db.usageStatus(ctx, tenant, models.UsageSubjectTenant, db.Quotas.TenantQuota(tenant))
+	if err != nil {
+		return nil, err
+	}
+	report := models.UsageReport{Tenant: tenant, TenantUsage: *tenantUsage}
+	if principal != nil {
+		if report.UserUsage, err = db.usageStatus(ctx, tenant, principal.OwnerRef(), db.Quotas.User); err != nil {
+			return nil, err
+		}
+	}
+	return &report, nil
+}
+
+func (db *VideoCatalogueManager) usageStatus(ctx context.Context, tenant string, subject string, quota models.Quota) (*models.UsageStatus, error) {
+	usageStatus := models.UsageStatus{Subject: subject, MaxBytes: quota.MaxBytes, MaxFiles: quota.MaxFiles}
+	if db.Usage == nil {
+		return &usageStatus, nil
+	}
+	usage, err := db.Usage.GetUsage(ctx, tenant, subject)
+	if err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Fetching usage failed!! tenant: %s, subject: %s, Error: %s", tenant, subject, err.Error()))
+		return nil, err
+	}
+	usageStatus.Bytes, usageStatus.Files = usage.Bytes, usage.Files
+	return &usageStatus, nil
+}
+
+func describeQuota(quota models.Quota) string {
+	switch {
+	case quota.MaxBytes > 0 && quota.MaxFiles > 0:
+		return fmt.Sprintf("%d bytes and %d files", quota.MaxBytes, quota.MaxFiles)
+	case quota.MaxBytes > 0:
+		return fmt.Sprintf("%d bytes", quota.MaxBytes)
+	default:
+		return fmt.Sprintf("%d files", quota.MaxFiles)
+	}
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"bytes"
+	"city_os/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// memoryUsage, usage counters reserved with the conditional increment of the Mongo wrapper
+type memoryUsage struct {
+	mu       sync.Mutex
+	counters map[string]*models.Usage
+}
+
+func newMemoryUsage() *memoryUsage {
+	return &memoryUsage{counters: map[string]*models.Usage{}}
+}
+
+func (m *memoryUsage) counter(tenant string, subject string) *models.Usage {
+	key := models.NormalizeTenant(tenant) + "/" + subject
+	if m.counters[key] == nil {
+		m.counters[key] = &models.Usage{Tenant: models.NormalizeTenant(tenant), Subject: subject}
+	}
+	return m.counters[key]
+}
+
+func (m *memoryUsage) ReserveUsage(_ context.Context, tenant string, subject string, bytes int64, files int64, quota models.Quota) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := m.counter(tenant, subject)
+	if quota.MaxBytes > 0 && usage.Bytes > quota.MaxBytes-bytes {
+		return false, nil
+	}
+	if quota.MaxFiles > 0 && usage.Files > quota.MaxFiles-files {
+		return false, nil
+	}
+	usage.Bytes += bytes
+	usage.Files += files
+	return true, nil
+}
+
+func (m *memoryUsage) ReleaseUsage(_ context.Context, tenant string, subject string, bytes int64, files int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := m.counter(tenant, subject)
+	usage.Bytes -= bytes
+	usage.Files -= files
+	return nil
+}
+
+func (m *memoryUsage) GetUsage(_ context.Context, tenant string, subject string) (*models.Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := *m.counter(tenant, subject)
+	return &usage, nil
+}
+
+func (m *memoryUsage) usage(tenant string, subject string) (int64, int64) {
+	usage, _ := m.GetUsage(context.Background(), tenant, subject)
+	return usage.Bytes, usage.Files
+}
+
+var quotaTestUser = &models.Principal{Id: "alice", Type: models.PrincipalTypeUser, Scopes: []string{models.ScopeAdmin}}
+
+func newQuotaTestManager(quotas models.QuotaLimits) (*VideoCatalogueManager, *memoryCatalogue, *memoryFiles, *memoryUsage) {
+	catalogue, files, usage := newMemoryCatalogue(), newMemoryFiles(), newMemoryUsage()
+	return &VideoCatalogueManager{
+		VideoCatalogueDBWrapper: catalogue,
+		VideoFilesDBWrapper:     files,
+		Usage:                   usage,
+		Quotas:                  quotas,
+	}, catalogue, files, usage
+}
+
+func TestSaveVideoFileQuotas(t *testing.T) {
+	tests := []struct {
+		name      string
+		quotas    models.QuotaLimits
+		sizes     []int
+		wantErr   error // of the last upload
+		wantBytes int64 // charged to tenant and user after all uploads
+		wantFiles int64
+	}{
+		{name: "unlimited", sizes: []int{10, 20}, wantBytes: 30, wantFiles: 2},
+		{name: "within tenant bytes", quotas: models.QuotaLimits{Tenant: models.Quota{MaxBytes: 30}}, sizes: []int{10, 20}, wantBytes: 30, wantFiles: 2},
+		{name: "tenant bytes exceeded", quotas: models.QuotaLimits{Tenant: models.Quota{MaxBytes: 29}}, sizes: []int{10, 20}, wantErr: ErrQuotaExceeded, wantBytes: 10, wantFiles: 1},
+		{name: "tenant files exceeded", quotas: models.QuotaLimits{Tenant: models.Quota{MaxFiles: 1}}, sizes: []int{10, 20}, wantErr: ErrQuotaExceeded, wantBytes: 10, wantFiles: 1},
+		// the tenant reservation made before the user's failed is given back
+		{name: "user bytes exceeded", quotas: models.QuotaLimits{User: models.Quota{MaxBytes: 15}}, sizes: []int{10, 10}, wantErr: ErrQuotaExceeded, wantBytes: 10, wantFiles: 1},
+		{name: "file larger than quota", quotas: models.QuotaLimits{User: models.Quota{MaxBytes: 15}}, sizes: []int{16}, wantErr: ErrFileExceedsQuota},
+		{name: "tenant override", quotas: models.QuotaLimits{Tenant: models.Quota{MaxBytes: 100}, Tenants: map[string]models.Quota{models.DefaultTenant: {MaxBytes: 5}}}, sizes: []int{10}, wantErr: ErrFileExceedsQuota},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager, catalogue, files, usage := newQuotaTestManager(test.quotas)
+			var err error
+			for i, size := range test.sizes {
+				_, err = manager.SaveVideoFile(context.Background(), bytes.NewReader(make([]byte, size)), int64(size), "video.mp4", "video/mp4", fmt.Sprintf("hash-%d", i), quotaTestUser)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("last upload returned %v, expected %v", err, test.wantErr)
+			}
+			for _, subject := range []string{models.UsageSubjectTenant, quotaTestUser.Ref()} {
+				if bytes, count := usage.usage(models.DefaultTenant, subject); bytes != test.wantBytes || count != test.wantFiles {
+					t.Fatalf("%s charged %d bytes and %d files, expected %d and %d", subject, bytes, count, test.wantBytes, test.wantFiles)
+				}
+			}
+			if stored := int64(len(catalogue.documents)); stored != test.wantFiles || int64(files.count()) != test.wantFiles {
+				t.Fatalf("%d documents and %d files stored, expected %d", stored, files.count(), test.wantFiles)
+			}
+		})
+	}
+}
+
+func TestSaveVideoFileReleasesUsageOfFailedInsert(t *testing.T) {
+	tests := []struct {
+		name      string
+		insertErr error
+		duplicate bool
+	}{
+		{name: "insert failed", insertErr: errors.New("connection reset")},
+		{name: "lost duplicate race", duplicate: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager, catalogue, _, usage := newQuotaTestManager(models.QuotaLimits{})
+			if test.duplicate {
+				if _, err := manager.SaveVideoFile(context.Background(), bytes.NewReader(make([]byte, 10)), 10, "first.mp4", "video/mp4", "hash", nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			catalogue.insertErr = test.insertErr
+			if _, err := manager.SaveVideoFile(context.Background(), bytes.NewReader(make([]byte, 10)), 10, "video.mp4", "video/mp4", "hash", quotaTestUser); err == nil {
+				t.Fatal("expected the upload to fail")
+			}
+			if bytes, count := usage.usage(models.DefaultTenant, quotaTestUser.Ref()); bytes != 0 || count != 0 {
+				t.Fatalf("user still charged %d bytes and %d files", bytes, count)
+			}
+			wantBytes, wantFiles := int64(0), int64(0)
+			if test.duplicate {
+				wantBytes, wantFiles = 10, 1
+			}
+			if bytes, count := usage.usage(models.DefaultTenant, models.UsageSubjectTenant); bytes != wantBytes || count != wantFiles {
+				t.Fatalf("tenant charged %d bytes and %d files, expected %d and %d", bytes, count, wantBytes, wantFiles)
+			}
+		})
+	}
+}
+
+func TestDeleteVideoFileReleasesUsageOnce(t *testing.T) {
+	tests := []struct {
+		name            string
+		concurrentFirst bool // another delete removes the document between the lookup and the delete
+	}{
+		{name: "deleted"},
+		{name: "deleted concurrently", concurrentFirst: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager, catalogue, _, usage := newQuotaTestManager(models.QuotaLimits{})
+			fileId, err := manager.SaveVideoFile(context.Background(), bytes.NewReader(make([]byte, 10)), 10, "video.mp4", "video/mp4", "hash", quotaTestUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.concurrentFirst {
+				catalogue.beforeDelete = func() {
+					catalogue.beforeDelete = nil
+					if _, err := manager.DeleteVideoFile(context.Background(), fileId, quotaTestUser); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+			if _, err = manager.DeleteVideoFile(context.Background(), fileId, quotaTestUser); err != nil {
+				t.Fatal(err)
+			}
+			for _, subject := range []string{models.UsageSubjectTenant, quotaTestUser.Ref()} {
+				if bytes, count := usage.usage(models.DefaultTenant, subject); bytes != 0 || count != 0 {
+					t.Fatalf("%s charged %d bytes and %d files after delete, expected none", subject, bytes, count)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveVideoFileConcurrentUploadsStayWithinQuota(t *testing.T) {
+	manager, _, _, usage := newQuotaTestManager(models.QuotaLimits{Tenant: models.Quota{MaxBytes: 50}})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			manager.SaveVideoFile(context.Background(), bytes.NewReader(make([]byte, 10)), 10, "video.mp4", "video/mp4", fmt.Sprintf("hash-%d", i), nil)
+		}(i)
+	}
+	wg.Wait()
+	if bytes, count := usage.usage(models.DefaultTenant, models.UsageSubjectTenant); bytes != 50 || count != 5 {
+		t.Fatalf("tenant charged %d bytes and %d files, expected 50 and 5", bytes, count)
+	}
+}
+
+func TestGetUsageReportsUploadsThroughShares(t *testing.T) {
+	manager, _, _, _ := newQuotaTestManager(models.QuotaLimits{})
+	share := &models.Principal{Id: "share-1", Type: models.PrincipalTypeShare, SharedBy: quotaTestUser.Ref(), Scopes: []string{models.ScopeFilesWrite}}
+	if _, err := manager.SaveVideoFile(context.Background(), bytes.NewReader(make([]byte, 10)), 10, "video.mp4", "video/mp4", "hash", share); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, principal := range []*models.Principal{quotaTestUser, share} {
+		report, err := manager.GetUsage(context.Background(), principal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.UserUsage.Subject != quotaTestUser.Ref() || report.UserUsage.Bytes != 10 || report.UserUsage.Files != 1 {
+			t.Fatalf("usage of %s reported as %+v, expected the minter's 10 bytes in 1 file", principal.Ref(), report.UserUsage)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"city_os/src/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"time"
+)
+
+var (
+	ErrFileQuarantined = errors.New("malware found, file is quarantined")
+	ErrScanUnavailable = errors.New("malware scanner unavailable")
+)
+
+// scan, scanning an upload before anything of it is stored, nil result when scanning is disabled.
+// A failing scanner rejects the upload unless ScanFailOpen accepts it with a failed scan result.
+
+func (db *VideoCatalogueManager) scan(ctx context.Context, fileData io.Reader) (*models.ScanResult, error) {
+	if db.Scanner == nil {
+		return nil, nil
+	}
+	_, span := tracing.Start(ctx, "VideoCatalogueManager.scan")
+	scanResult, err := db.Scanner.Scan(fileData)
+	if scanResult != nil {
+		span.SetAttributes(attribute.String("scan.status", scanResult.Status))
+	}
+	tracing.End(span, err)
+	if err == nil {
+		return scanResult, nil
+	}
+
+	logger.FromContext(ctx).Error(fmt.Sprintf("Scanning upload failed!! Error: %s", err.Error()))
+	if !db.ScanFailOpen {
+		return nil, fmt.Errorf("%w: %s", ErrScanUnavailable, err.Error())
+	}
+	return &models.ScanResult{
+		Status:    models.ScanStatusFailed,
+		Error:     err.Error(),
+		ScannedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}, nil
+}
+
+func isInfected(scanResult *models.ScanResult) bool {
+	return scanResult != nil && scanResult.Status == models.ScanStatusInfected
+}
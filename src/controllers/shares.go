@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidShare = errors.New("invalid share request")
+
+// ShareManager, Controller minting signed URLs for callers without credentials, downloads of a single file
+// and one-shot uploads into a collection of the minter's tenant. It is also the IUseLimitedAuthenticator of
+// requests carrying "share", "exp" and "sig" query params. The signature covers method and path, so a
+// signed URL resolves to a principal on exactly the request it was minted for. A request rejected after
+// authentication, e.g. a rate limited or duplicate upload, doesn't use up the URL. Only a download's first
+// request counts a use, Range requests continuing it from a later byte don't.
+
+type ShareManager struct {
+	ShareDBWrapper        interfaces.IShareDBWrapper
+	VideoCatalogueManager interfaces.IVideoCatalogueManager
+	SigningKey            []byte
+	BaseURL               string // prefix of the minted URLs, scheme, host and port of the server
+	DefaultTTL            time.Duration
+	MaxTTL                time.Duration
+}
+
+//ShareFile, signed download URL of a file, the minter needs read permission on it
+
+func (sm *ShareManager) ShareFile(ctx context.Context, fileId string, request *models.ShareRequest, principal *models.Principal) (*models.SignedURL, error) {
+	if request.MaxDownloads < 0 {
+		return nil, fmt.Errorf("%w: max_downloads can't be negative", ErrInvalidShare)
+	}
+	if _, err := sm.VideoCatalogueManager.GetFilesDataById(ctx, fileId, principal); err != nil {
+		return nil, err
+	}
+	share := models.Share{Kind: models.ShareKindDownload, FileId: fileId, MaxUses: request.MaxDownloads}
+	return sm.mint(&share, request, principal, http.MethodGet, "/v1/files/"+fileId)
+}
+
+//ShareUpload, signed URL accepting a single upload into the minter's tenant, the file is owned by the minter
+//and goes into the requested collection if any
+
+func (sm *ShareManager) ShareUpload(_ context.Context, request *models.ShareRequest, principal *models.Principal) (*models.SignedURL, error) {
+	if request.Collection != "" {
+		if err := models.ValidateCollection(request.Collection); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidShare, err.Error())
+		}
+	}
+	share := models.Share{Kind: models.ShareKindUpload, Collection: request.Collection, MaxUses: 1}
+	return sm.mint(&share, request, principal, http.MethodPost, "/v1/files")
+}
+
+func (sm *ShareManager) mint(share *models.Share, request *models.ShareRequest, principal *models.Principal, method string, path string) (*models.SignedURL, error) {
+	ttl := time.Duration(request.ExpiresIn) * time.Second
+	if request.ExpiresIn == 0 {
+		ttl = sm.DefaultTTL
+	}
+	if ttl <= 0 || ttl > sm.MaxTTL {
+		return nil, fmt.Errorf("%w: expires_in must be between 1 and %d seconds", ErrInvalidShare, int64(sm.MaxTTL.Seconds()))
+	}
+	if request.IP != "" {
+		ip := net.ParseIP(request.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: ip %q is not an IP address", ErrInvalidShare, request.IP)
+		}
+		share.IP = ip.String()
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+	share.Tenant = models.TenantOf(principal)
+	if principal != nil {
+		share.CreatedBy = principal.OwnerRef()
+	}
+	share.ExpiresAt = primitive.NewDateTimeFromTime(expiresAt)
+	share.CreatedAt = primitive.NewDateTimeFromTime(now)
+
+	shareId, err := sm.ShareDBWrapper.InsertShare(share)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Share insert failed!! Error: %s", err.Error()))
+		return nil, err
+	}
+	share.ShareId = shareId
+
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{}
+	query.Set("share", shareId)
+	query.Set("exp", exp)
+	query.Set("sig", sm.sign(method, path, shareId, exp, share.IP))
+	return &models.SignedURL{Share: share, URL: sm.BaseURL + path + "?" + query.Encode()}, nil
+}
+
+func (sm *ShareManager) Authenticate(request *http.Request, clientIP string) (*models.Principal, error) {
+	query := request.URL.Query()
+	sig := query.Get("sig")
+	if sig == "" {
+		return nil, nil
+	}
+	shareId, exp := query.Get("share"), query.Get("exp")
+	expiresAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || shareId == "" {
+		return nil, fmt.Errorf("%w: share and exp params are required with sig", ErrInvalidCredentials)
+	}
+	if time.Now().Unix() > expiresAt {
+		return nil, fmt.Errorf("%w: signed url expired", ErrInvalidCredentials)
+	}
+
+	// The IP is not part of the URL, a bound URL only verifies when requested from that address
+	if ip := net.ParseIP(clientIP); ip != nil {
+		clientIP = ip.String()
+	}
+	expected := sm.sign(request.Method, request.URL.Path, shareId, exp, "")
+	boundExpected := sm.sign(request.Method, request.URL.Path, shareId, exp, clientIP)
+	if !hmac.Equal([]byte(sig), []byte(expected)) && !hmac.Equal([]byte(sig), []byte(boundExpected)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCredentials)
+	}
+
+	share, err := sm.useShare(request, shareId)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &models.Principal{
+		Id:         share.ShareId,
+		Type:       models.PrincipalTypeShare,
+		Name:       share.Kind + " share",
+		Tenant:     share.Tenant,
+		FileId:     share.FileId,
+		Collection: share.Collection,
+		SharedBy:   share.CreatedBy,
+		Scopes:     []string{models.ScopeFilesRead},
+	}
+	if share.Kind == models.ShareKindUpload {
+		principal.Scopes = []string{models.ScopeFilesWrite}
+	}
+	return principal, nil
+}
+
+// useShare, the share of a request, counting a use unless the request continues a download. A
+// continuation is only accepted once the download was started, so it never gets the file without a use.
+func (sm *ShareManager) useShare(request *http.Request, shareId string) (*models.Share, error) {
+	if !isRangeContinuation(request) {
+		share, err := sm.ShareDBWrapper.ConsumeShare(shareId)
+		if err != nil {
+			return nil, err
+		}
+		if share == nil {
+			return nil, fmt.Errorf("%w: share %s unknown or used up", ErrInvalidCredentials, shareId)
+		}
+		return share, nil
+	}
+
+	share, err := sm.ShareDBWrapper.GetShare(shareId)
+	if err != nil {
+		return nil, err
+	}
+	if share == nil || share.Kind != models.ShareKindDownload || share.Uses == 0 {
+		return nil, fmt.Errorf("%w: share %s unknown or download not started", ErrInvalidCredentials, shareId)
+	}
+	return share, nil
+}
+
+// isRangeContinuation, GET with a Range from a byte after the first one, served as 206 by the download
+// handler. Ranges it ignores, with If-Range or several ranges, and suffix ranges, which may cover the
+// whole file, are full downloads.
+func isRangeContinuation(request *http.Request) bool {
+	if request.Method != http.MethodGet || request.Header.Get("If-Range") != "" {
+		return false
+	}
+	byteRange := models.ParseByteRange(request.Header.Get("Range"))
+	return byteRange != nil && byteRange.Suffix == 0 && byteRange.Start > 0
+}
+
+// ReleaseUse, giving back the use Authenticate counted for a request which was rejected, continuations
+// of a download didn't count one
+func (sm *ShareManager) ReleaseUse(request *http.Request, principal *models.Principal) {
+	if isRangeContinuation(request) {
+		return
+	}
+	if err := sm.ShareDBWrapper.ReleaseShare(principal.Id); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Releasing share use failed!! shareId: %s, Error: %s", principal.Id, err.Error()))
+	}
+}
+
+// sign, HMAC-SHA256 of the request target, share and expiry, and the bound client address if any
+func (sm *ShareManager) sign(method string, path string, shareId string, exp string, ip string) string {
+	mac := hmac.New(sha256.New, sm.SigningKey)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, path, shareId, exp, ip)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
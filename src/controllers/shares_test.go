@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryShares, shares used up with the conditional increment of the Mongo wrapper
+type memoryShares struct {
+	mu     sync.Mutex
+	shares map[string]*models.Share
+}
+
+func newMemoryShares() *memoryShares {
+	return &memoryShares{shares: map[string]*models.Share{}}
+}
+
+func (m *memoryShares) InsertShare(share *models.Share) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *share
+	stored.ShareId = strconv.Itoa(len(m.shares) + 1)
+	m.shares[stored.ShareId] = &stored
+	return stored.ShareId, nil
+}
+
+func (m *memoryShares) GetShare(shareId string) (*models.Share, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	share, found := m.shares[shareId]
+	if !found {
+		return nil, nil
+	}
+	copied := *share
+	return &copied, nil
+}
+
+func (m *memoryShares) ConsumeShare(shareId string) (*models.Share, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	share, found := m.shares[shareId]
+	if !found || (share.MaxUses > 0 && share.Uses >= share.MaxUses) {
+		return nil, nil
+	}
+	share.Uses++
+	copied := *share
+	return &copied, nil
+}
+
+func (m *memoryShares) ReleaseShare(shareId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if share, found := m.shares[shareId]; found && share.Uses > 0 {
+		share.Uses--
+	}
+	return nil
+}
+
+func (m *memoryShares) uses(shareId string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shares[shareId].Uses
+}
+
+// readableCatalogue, catalogue manager letting every principal read every file
+type readableCatalogue struct {
+	interfaces.IVideoCatalogueManager
+}
+
+func (readableCatalogue) GetFilesDataById(_ context.Context, fileId string, _ *models.Principal) (*models.VideoCatalogueData, error) {
+	return &models.VideoCatalogueData{FileId: fileId}, nil
+}
+
+func newTestShareManager(shares *memoryShares) *ShareManager {
+	return &ShareManager{
+		ShareDBWrapper:        shares,
+		VideoCatalogueManager: readableCatalogue{},
+		SigningKey:            []byte("0123456789abcdef0123456789abcdef"),
+		BaseURL:               "http://files.example.com",
+		DefaultTTL:            time.Hour,
+		MaxTTL:                24 * time.Hour,
+	}
+}
+
+// TestRangeRequestsAgainstLimitedShare, a player fetching a shared video in several Range requests uses
+// a single download, the first request counts it and the continuations from later bytes don't
+func TestRangeRequestsAgainstLimitedShare(t *testing.T) {
+	shares := newMemoryShares()
+	manager := newTestShareManager(shares)
+	signed, err := manager.ShareFile(context.Background(), "video", &models.ShareRequest{MaxDownloads: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		headers  map[string]string
+		wantOK   bool
+		wantUses int64
+	}{
+		{"continuation before the download started", map[string]string{"Range": "bytes=100-"}, false, 0},
+		{"first request", map[string]string{"Range": "bytes=0-"}, true, 1},
+		{"continuation", map[string]string{"Range": "bytes=100-"}, true, 1},
+		{"bounded continuation", map[string]string{"Range": "bytes=200-299"}, true, 1},
+		{"restart from the first byte", map[string]string{"Range": "bytes=0-99"}, false, 1},
+		{"suffix range", map[string]string{"Range": "bytes=-100"}, false, 1},
+		{"range ignored for If-Range", map[string]string{"Range": "bytes=100-", "If-Range": `"etag"`}, false, 1},
+		{"several ranges", map[string]string{"Range": "bytes=100-199,300-"}, false, 1},
+		{"full download", nil, false, 1},
+	}
+	for _, step := range steps {
+		request := httptest.NewRequest(http.MethodGet, signed.URL, nil)
+		for name, value := range step.headers {
+			request.Header.Set(name, value)
+		}
+		principal, err := manager.Authenticate(request, "192.0.2.1")
+		if step.wantOK && (err != nil || principal == nil || principal.FileId != "video") {
+			t.Fatalf("%s: principal %v, error %v, expected the share's principal", step.name, principal, err)
+		}
+		if !step.wantOK && !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("%s: error %v, expected %v", step.name, err, ErrInvalidCredentials)
+		}
+		if uses := shares.uses(signed.Share.ShareId); uses != step.wantUses {
+			t.Fatalf("%s: %d uses, expected %d", step.name, uses, step.wantUses)
+		}
+	}
+}
+
+// TestReleaseUseOfRejectedRangeRequests, only rejected requests which counted a use give it back
+func TestReleaseUseOfRejectedRangeRequests(t *testing.T) {
+	shares := newMemoryShares()
+	manager := newTestShareManager(shares)
+	signed, err := manager.ShareFile(context.Background(), "video", &models.ShareRequest{MaxDownloads: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(rangeHeader string) (*http.Request, *models.Principal) {
+		request := httptest.NewRequest(http.MethodGet, signed.URL, nil)
+		if rangeHeader != "" {
+			request.Header.Set("Range", rangeHeader)
+		}
+		principal, err := manager.Authenticate(request, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return request, principal
+	}
+
+	authenticate("")
+	// A continuation past the end of the file is answered with 416, it didn't count a use to give back
+	request, principal := authenticate("bytes=1000000-")
+	manager.ReleaseUse(request, principal)
+	if uses := shares.uses(signed.Share.ShareId); uses != 1 {
+		t.Fatalf("%d uses after a rejected continuation, expected 1", uses)
+	}
+
+	request, principal = authenticate("")
+	manager.ReleaseUse(request, principal)
+	if uses := shares.uses(signed.Share.ShareId); uses != 1 {
+		t.Fatalf("%d uses after a rejected download, expected 1", uses)
+	}
+}
@@ -0,0 +1,439 @@
+package controllers
+
+import (
+	"bytes"
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/jobs"
+	"city_os/src/models"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidWebhook       = errors.New("invalid webhook")
+	ErrDeliveryNotInWebhook = errors.New("delivery does not belong to the webhook")
+	ErrWebhookAddress       = errors.New("webhook address not allowed")
+)
+
+// WebhookManager, Controller for webhook subscriptions. It implements IEventPublisher for the outbox relay,
+// every published event is recorded as a delivery per subscribed webhook and sent by the job workers,
+// so failed deliveries are retried with the job queue backoff and survive restarts.
+
+type WebhookManager struct {
+	WebhookDBWrapper interfaces.IWebhookDBWrapper
+	JobQueue         interfaces.IJobQueue
+	HTTPClient       *http.Client // NewWebhookHTTPClient
+	MaxAttempts      int
+	DeliveryLogLimit int64
+	AllowPrivate     bool // URLs of loopback, link-local and private hosts are accepted
+}
+
+// Networks the net.IP predicates don't cover: the shared address space of carrier-grade NAT and the NAT64
+// prefixes, whose addresses are translated to any IPv4 address including private ones
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("64:ff9b::/96"),
+	mustParseCIDR("64:ff9b:1::/48"),
+}
+
+// NewWebhookHTTPClient, HTTP client for deliveries. Unless allowPrivate it refuses connections to loopback,
+// link-local, private, carrier-grade NAT, NAT64, unspecified and multicast addresses. The check runs on the resolved address of every
+// connection, so it also covers redirects and host names resolving differently than at registration.
+// Proxies from the environment aren't used, their address would be checked instead of the receiver's.
+
+func NewWebhookHTTPClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(_ string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func isPublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func (wm *WebhookManager) CreateWebhook(tenant string, request *models.WebhookRequest) (*models.Webhook, error) {
+	if request.URL == nil || request.Events == nil {
+		return nil, fmt.Errorf("%w: url and events are mandatory", ErrInvalidWebhook)
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	webhook := models.Webhook{
+		Active:    true,
+		Tenant:    models.NormalizeTenant(tenant),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := wm.applyWebhookRequest(&webhook, request); err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	webhookId, err := wm.WebhookDBWrapper.InsertWebhook(&webhook)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Webhook insert failed!! Error: %s", err.Error()))
+		return nil, err
+	}
+	webhook.WebhookId = webhookId
+	return &webhook, nil
+}
+
+func (wm *WebhookManager) GetWebhookById(tenant string, webhookId string) (*models.Webhook, error) {
+	webhook, err := wm.getTenantWebhook(tenant, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (wm *WebhookManager) GetWebhooksList(tenant string) ([]*models.Webhook, error) {
+	webhooks, err := wm.WebhookDBWrapper.GetWebhooksByTenant(tenant)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Fetching webhooks failed!! Error: %s", err.Error()))
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (wm *WebhookManager) UpdateWebhook(tenant string, webhookId string, request *models.WebhookRequest) (*models.Webhook, error) {
+	webhook, err := wm.getTenantWebhook(tenant, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if err = wm.applyWebhookRequest(webhook, request); err != nil {
+		return nil, err
+	}
+	webhook.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	if _, err = wm.WebhookDBWrapper.UpdateWebhookById(webhookId, map[string]interface{}{
+		"url":        webhook.URL,
+		"events":     webhook.Events,
+		"secret":     webhook.Secret,
+		"active":     webhook.Active,
+		"updated_at": webhook.UpdatedAt,
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Webhook update failed!! Error: %s", err.Error()))
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (wm *WebhookManager) DeleteWebhook(tenant string, webhookId string) (bool, error) {
+	if _, err := wm.getTenantWebhook(tenant, webhookId); err != nil {
+		return false, err
+	}
+	deleted, err := wm.WebhookDBWrapper.DeleteWebhookById(webhookId)
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func (wm *WebhookManager) GetDeliveries(tenant string, webhookId string) ([]*models.WebhookDelivery, error) {
+	if _, err := wm.getTenantWebhook(tenant, webhookId); err != nil {
+		return nil, err
+	}
+	return wm.WebhookDBWrapper.GetDeliveriesByWebhookId(webhookId, wm.DeliveryLogLimit)
+}
+
+//Redeliver, sending a logged delivery once more, e.g. after the receiver was fixed
+
+func (wm *WebhookManager) Redeliver(tenant string, webhookId string, deliveryId string) (*models.WebhookDelivery, error) {
+	if _, err := wm.getTenantWebhook(tenant, webhookId); err != nil {
+		return nil, err
+	}
+	delivery, err := wm.WebhookDBWrapper.GetDeliveryById(deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.WebhookId != webhookId {
+		return nil, ErrDeliveryNotInWebhook
+	}
+
+	jobId, err := wm.enqueueDelivery(deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Status = models.DeliveryStatusPending
+	delivery.JobId = jobId
+	delivery.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+	if _, err = wm.WebhookDBWrapper.UpdateDeliveryById(deliveryId, map[string]interface{}{
+		"status":     delivery.Status,
+		"job_id":     delivery.JobId,
+		"updated_at": delivery.UpdatedAt,
+	}); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// getTenantWebhook, webhooks of other tenants are reported like missing ones
+
+func (wm *WebhookManager) getTenantWebhook(tenant string, webhookId string) (*models.Webhook, error) {
+	webhook, err := wm.WebhookDBWrapper.GetWebhookById(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if models.NormalizeTenant(webhook.Tenant) != models.NormalizeTenant(tenant) {
+		return nil, mongo.ErrNoDocuments
+	}
+	return webhook, nil
+}
+
+//Publish, recording a delivery for every active webhook of the event's tenant subscribed to the event.
+//An error makes the relay publish the event again, receivers should dedupe deliveries by event id.
+
+func (wm *WebhookManager) Publish(event *models.Event) error {
+	webhooks, err := wm.WebhookDBWrapper.GetActiveWebhooksByEvent(event.Tenant, event.Type)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		delivery := models.WebhookDelivery{
+			WebhookId: webhook.WebhookId,
+			EventId:   event.EventId,
+			EventType: event.Type,
+			Payload:   string(payload),
+			Status:    models.DeliveryStatusPending,
+			Attempts:  []models.WebhookDeliveryAttempt{},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		deliveryId, err := wm.WebhookDBWrapper.InsertDelivery(&delivery)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Recording webhook delivery failed!! webhookId: %s, Error: %s", webhook.WebhookId, err.Error()))
+			return err
+		}
+		jobId, err := wm.enqueueDelivery(deliveryId)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Enqueueing webhook delivery failed!! deliveryId: %s, Error: %s", deliveryId, err.Error()))
+			return err
+		}
+		if _, err = wm.WebhookDBWrapper.UpdateDeliveryById(deliveryId, map[string]interface{}{"job_id": jobId}); err != nil {
+			logger.Logger.Warn(fmt.Sprintf("Linking delivery to job failed!! deliveryId: %s, Error: %s", deliveryId, err.Error()))
+		}
+	}
+	return nil
+}
+
+//RegisterJobProcessors, wiring the delivery job handler into a worker pool
+
+func (wm *WebhookManager) RegisterJobProcessors(pool *jobs.WorkerPool) {
+	pool.Register(models.JobTypeWebhookDelivery, jobs.JobProcessor{
+		Handle:         wm.deliveryJob,
+		OnDeadLettered: wm.deliveryDeadLettered,
+	})
+}
+
+func (wm *WebhookManager) enqueueDelivery(deliveryId string) (string, error) {
+	return wm.JobQueue.Enqueue(&models.Job{
+		Type:        models.JobTypeWebhookDelivery,
+		Payload:     map[string]string{"delivery_id": deliveryId},
+		MaxAttempts: wm.MaxAttempts,
+	})
+}
+
+// deliveryJob, POSTing the payload signed with the webhook secret, every attempt is logged on the delivery
+
+func (wm *WebhookManager) deliveryJob(ctx context.Context, job *models.Job) error {
+	deliveryId := job.Payload["delivery_id"]
+	delivery, err := wm.WebhookDBWrapper.GetDeliveryById(deliveryId)
+	if err != nil {
+		return err
+	}
+	webhook, err := wm.WebhookDBWrapper.GetWebhookById(delivery.WebhookId)
+	if err != nil {
+		return err
+	}
+	if !webhook.Active {
+		logger.FromContext(ctx).Info(fmt.Sprintf("Skipping delivery to inactive webhook!! deliveryId: %s", deliveryId))
+		return nil
+	}
+
+	started := time.Now()
+	responseCode, sendErr := wm.send(ctx, webhook, delivery)
+	attempt := models.WebhookDeliveryAttempt{
+		At:           primitive.NewDateTimeFromTime(started),
+		ResponseCode: responseCode,
+		DurationMs:   time.Since(started).Milliseconds(),
+	}
+	status := models.DeliveryStatusSucceeded
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+		status = models.DeliveryStatusPending
+	}
+	if err = wm.WebhookDBWrapper.AppendDeliveryAttempt(deliveryId, attempt, status); err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Logging delivery attempt failed!! deliveryId: %s, Error: %s", deliveryId, err.Error()))
+	}
+	return sendErr
+}
+
+func (wm *WebhookManager) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	// receivers which trace continue the delivery job's trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", delivery.EventType)
+	request.Header.Set("X-Webhook-Delivery", delivery.DeliveryId)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	response, err := wm.HTTPClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver responded with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+func (wm *WebhookManager) deliveryDeadLettered(job *models.Job, _ error) {
+	deliveryId := job.Payload["delivery_id"]
+	if _, err := wm.WebhookDBWrapper.UpdateDeliveryById(deliveryId, map[string]interface{}{
+		"status":     models.DeliveryStatusFailed,
+		"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Marking delivery failed failed!! deliveryId: %s, Error: %s", deliveryId, err.Error()))
+	}
+}
+
+// SignWebhookPayload, hex encoded HMAC-SHA256 over "<timestamp>.<body>", receivers recompute it with
+// the webhook secret and should reject stale timestamps to prevent replays
+
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// applyWebhookRequest, the URL's host is only checked when it is an IP address or localhost, host names
+// are checked with every delivery by the HTTP client
+
+func (wm *WebhookManager) applyWebhookRequest(webhook *models.Webhook, request *models.WebhookRequest) error {
+	if request.URL != nil {
+		parsedURL, err := url.Parse(*request.URL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			return fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+		}
+		if !wm.AllowPrivate && isPrivateHost(parsedURL.Hostname()) {
+			return fmt.Errorf("%w: url must not point to a loopback, link-local or private address", ErrInvalidWebhook)
+		}
+		webhook.URL = *request.URL
+	}
+	if request.Events != nil {
+		if len(*request.Events) == 0 {
+			return fmt.Errorf("%w: at least one event is required", ErrInvalidWebhook)
+		}
+		for _, eventType := range *request.Events {
+			if !isWebhookEventType(eventType) {
+				return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, eventType)
+			}
+		}
+		webhook.Events = *request.Events
+	}
+	if request.Secret != nil {
+		webhook.Secret = *request.Secret
+	}
+	if request.Active != nil {
+		webhook.Active = *request.Active
+	}
+	return nil
+}
+
+func isPrivateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && !isPublicAddress(ip)
+}
+
+func isWebhookEventType(eventType string) bool {
+	for _, knownType := range models.WebhookEventTypes {
+		if knownType == eventType {
+			return true
+		}
+	}
+	return false
+}
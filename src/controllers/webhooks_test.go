@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"city_os/src/models"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliveryRefusesNonPublicAddresses(t *testing.T) {
+	var received int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer receiver.Close()
+
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantRefusal  bool
+	}{
+		{name: "loopback", url: receiver.URL + "/hook", wantRefusal: true},
+		{name: "cloud metadata", url: "http://169.254.169.254/latest/meta-data/", wantRefusal: true},
+		{name: "loopback allowed", url: receiver.URL + "/hook", allowPrivate: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = 0
+			wm := &WebhookManager{HTTPClient: NewWebhookHTTPClient(time.Second, test.allowPrivate)}
+			_, err := wm.send(context.Background(), &models.Webhook{URL: test.url, Secret: "secret"}, &models.WebhookDelivery{
+				DeliveryId: "delivery", EventType: models.EventFileCreated, Payload: "{}",
+			})
+			if test.wantRefusal {
+				if !errors.Is(err, ErrWebhookAddress) {
+					t.Fatalf("expected ErrWebhookAddress, got %v", err)
+				}
+				if received != 0 {
+					t.Fatal("refused delivery reached the receiver")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received != 1 {
+				t.Fatalf("receiver got %d deliveries, expected 1", received)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::6810:84e5": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fc00::1":              false,
+		"0.0.0.0":              false,
+		"::":                   false,
+		"224.0.0.1":            false,
+		"ff02::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"100.64.0.1":           false,
+		"100.127.255.254":      false,
+		"100.128.0.1":          true,
+		"64:ff9b::a00:1":       false,
+		"64:ff9b:1::a00:1":     false,
+	}
+	for address, want := range tests {
+		if got := isPublicAddress(net.ParseIP(address)); got != want {
+			t.Errorf("isPublicAddress(%s) = %v, expected %v", address, got, want)
+		}
+	}
+}
+
+func TestApplyWebhookRequestURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{url: "https://hooks.example.com/video"},
+		{url: "http://127.0.0.1:8080/hook", wantErr: true},
+		{url: "http://169.254.169.254/", wantErr: true},
+		{url: "http://[::1]/hook", wantErr: true},
+		{url: "http://100.64.1.1/hook", wantErr: true},
+		{url: "http://localhost/hook", wantErr: true},
+		{url: "http://api.localhost./hook", wantErr: true},
+		{url: "http://127.0.0.1:8080/hook", allowPrivate: true},
+		{url: "ftp://hooks.example.com/", wantErr: true},
+	}
+	for _, test := range tests {
+		wm := &WebhookManager{AllowPrivate: test.allowPrivate}
+		url := test.url
+		err := wm.applyWebhookRequest(&models.Webhook{}, &models.WebhookRequest{URL: &url})
+		if test.wantErr != errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("url %s, allowPrivate %v: got %v", test.url, test.allowPrivate, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package dbconnectors
+
+import (
+	"city_os/src/models"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// APIKeyDBWrapper, storage of API keys, revoked keys are kept for audit
+
+type APIKeyDBWrapper struct {
+	collection *mongo.Collection
+	timeouts   OperationTimeouts
+}
+
+func (mdb *APIKeyDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.collection = dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB).Collection(dbSettings.APIKeysCollection)
+}
+
+func (mdb *APIKeyDBWrapper) InsertAPIKey(apiKey *models.APIKey) (string, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	return insertAndGetHexId(ctx, mdb.collection, apiKey)
+}
+
+func (mdb *APIKeyDBWrapper) GetAPIKeyById(keyId string) (*models.APIKey, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := models.APIKey{}
+	if err = mdb.collection.FindOne(ctx, bson.M{"_id": objectId}).Decode(&apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (mdb *APIKeyDBWrapper) GetAPIKeysByTenant(tenant string) ([]*models.APIKey, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := mdb.collection.Find(ctx, bson.D{tenantCondition(tenant)}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := make([]*models.APIKey, 0)
+	if err = cursor.All(ctx, &apiKeys); err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (mdb *APIKeyDBWrapper) RevokeAPIKeyById(keyId string) (int64, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(keyId)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := mdb.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectId, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": primitive.NewDateTimeFromTime(time.Now())}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
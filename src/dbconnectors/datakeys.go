@@ -0,0 +1,102 @@
+package dbconnectors
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// DataKeyDBWrapper, sealed data keys of the encrypted files, one document per file identified by
+// "<tenant>/<file id>", kept apart from the file bytes so that any file backend can be encrypted
+
+type DataKeyDBWrapper struct {
+	collection *mongo.Collection
+	timeouts   OperationTimeouts
+}
+
+func (mdb *DataKeyDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.collection = dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB).Collection(dbSettings.DataKeysCollection)
+
+	// key rotation looks for the keys sealed by other master keys
+	if _, err := mdb.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "master_key_id", Value: 1}, {Key: "_id", Value: 1}},
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Data keys index creation failed!! Error: %s", err.Error()))
+	}
+}
+
+func (mdb *DataKeyDBWrapper) InsertDataKey(dataKey *models.DataKey) error {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	_, err := mdb.collection.InsertOne(ctx, dataKey)
+	return err
+}
+
+func (mdb *DataKeyDBWrapper) GetDataKey(tenant string, fileId string) (*models.DataKey, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	dataKey := models.DataKey{}
+	err := mdb.collection.FindOne(ctx, bson.M{"_id": models.DataKeyId(tenant, fileId)}).Decode(&dataKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey, nil
+}
+
+func (mdb *DataKeyDBWrapper) DeleteDataKey(tenant string, fileId string) error {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	_, err := mdb.collection.DeleteOne(ctx, bson.M{"_id": models.DataKeyId(tenant, fileId)})
+	return err
+}
+
+func (mdb *DataKeyDBWrapper) GetDataKeysNotWrappedWith(masterKeyId string, afterId string, limit int64) ([]*models.DataKey, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	filter := bson.M{"master_key_id": bson.M{"$ne": masterKeyId}, "_id": bson.M{"$gt": afterId}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	cursor, err := mdb.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKeys := []*models.DataKey{}
+	if err = cursor.All(ctx, &dataKeys); err != nil {
+		return nil, err
+	}
+	return dataKeys, nil
+}
+
+func (mdb *DataKeyDBWrapper) UpdateWrappedKey(id string, oldMasterKeyId string, masterKeyId string, wrappedKey []byte) (bool, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	result, err := mdb.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "master_key_id": oldMasterKeyId},
+		bson.M{"$set": bson.M{
+			"master_key_id": masterKeyId,
+			"wrapped_key":   wrappedKey,
+			"rotated_at":    primitive.NewDateTimeFromTime(time.Now()),
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
@@ -0,0 +1,90 @@
+package dbconnectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Indexes readiness requires, named as Mongo names them from their keys
+const (
+	catalogueHashIndex       = "tenant_1_hash_1"
+	cataloguePrefixHashIndex = "hash_1" // prefix mode, the collection is the tenant's
+	gridFSFilesIndex         = "filename_1_uploadDate_1"
+	gridFSChunksIndex        = "files_id_1_n_1"
+)
+
+// mongo's NamespaceNotFound, listing the indexes of a collection which doesn't exist yet
+const namespaceNotFoundCode = 26
+
+// Ping, the primary is reachable, uploads need it
+func (mcli *MongoDBClient) Ping(ctx context.Context) error {
+	return mcli.conn.(*mongo.Client).Ping(ctx, readpref.Primary())
+}
+
+// CheckCollections, the catalogue collection is reachable and has the indexes InitDatabase creates
+func (mdb *VideoCatalogueDBWrapper) CheckCollections(ctx context.Context) error {
+	if mdb.isolation == TenantIsolationPrefix {
+		// the default tenant's collection, the other tenants' ones are indexed with their first use
+		return checkIndexes(ctx, mdb.collection, cataloguePrefixHashIndex)
+	}
+	return checkIndexes(ctx, mdb.collection, catalogueHashIndex)
+}
+
+// CheckCollections, the files and chunks collections of the bucket are reachable and have the GridFS
+// indexes. Checks the bucket the wrapper is set up for, buckets of tenants are created with their
+// first file.
+func (mdb *VideoFilesDBWrapper) CheckCollections(ctx context.Context) error {
+	if err := checkIndexes(ctx, mdb.database.Collection(mdb.bucketName+".files"), gridFSFilesIndex); err != nil {
+		return err
+	}
+	return checkIndexes(ctx, mdb.database.Collection(mdb.bucketName+".chunks"), gridFSChunksIndex)
+}
+
+// createGridFSIndexes, the indexes GridFS creates on the first upload to an empty bucket, created
+// upfront so readiness can check them from the start
+
+func (mdb *VideoFilesDBWrapper) createGridFSIndexes(ctx context.Context) error {
+	if _, err := mdb.database.Collection(mdb.bucketName+".files").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "filename", Value: 1}, {Key: "uploadDate", Value: 1}},
+	}); err != nil {
+		return err
+	}
+	_, err := mdb.database.Collection(mdb.bucketName+".chunks").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "files_id", Value: 1}, {Key: "n", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func checkIndexes(ctx context.Context, collection *mongo.Collection, required ...string) error {
+	cursor, err := collection.Indexes().List(ctx)
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == namespaceNotFoundCode && len(required) == 0 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", collection.Name(), err)
+	}
+
+	var indexes []bson.M
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return fmt.Errorf("%s: %w", collection.Name(), err)
+	}
+	existing := make(map[string]bool, len(indexes))
+	for _, index := range indexes {
+		if name, ok := index["name"].(string); ok {
+			existing[name] = true
+		}
+	}
+	for _, name := range required {
+		if !existing[name] {
+			return fmt.Errorf("%s: index %s missing", collection.Name(), name)
+		}
+	}
+	return nil
+}
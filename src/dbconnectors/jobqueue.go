@@ -0,0 +1,189 @@
+package dbconnectors
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"city_os/src/utils"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var ErrJobLeaseLost = errors.New("job lease is not held by this worker anymore")
+
+// JobQueueDBWrapper, Mongo backed job queue. Workers lease due jobs with an atomic find-and-modify,
+// a lease which is not completed or extended in time makes the job visible to other workers again.
+
+type JobQueueDBWrapper struct {
+	collection *mongo.Collection
+	timeouts   OperationTimeouts
+}
+
+func (mdb *JobQueueDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.collection = dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB).Collection(dbSettings.JobsCollection)
+
+	_, err := mdb.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		{Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Jobs index creation failed!! Error: %s", err.Error()))
+	}
+}
+
+func (mdb *JobQueueDBWrapper) Enqueue(job *models.Job) (string, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	job.JobId = ""
+	job.Status = models.JobStatusPending
+	job.Attempts = 0
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	if job.RunAt == 0 {
+		job.RunAt = now
+	}
+
+	insertDocBson, err := utils.ToBson(job)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := mdb.collection.InsertOne(ctx, insertDocBson)
+	if err != nil {
+		return "", err
+	}
+	job.JobId = result.InsertedID.(primitive.ObjectID).Hex()
+	return job.JobId, nil
+}
+
+func (mdb *JobQueueDBWrapper) Lease(owner string, leaseFor time.Duration) (*models.Job, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": models.JobStatusPending, "run_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)}},
+		// lease of a crashed or stuck worker ran out
+		bson.M{"status": models.JobStatusRunning, "lease_until": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      models.JobStatusRunning,
+			"lease_owner": owner,
+			"lease_until": primitive.NewDateTimeFromTime(now.Add(leaseFor)),
+			"updated_at":  primitive.NewDateTimeFromTime(now),
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	job := models.Job{}
+	err := mdb.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (mdb *JobQueueDBWrapper) ExtendLease(jobId string, owner string, leaseFor time.Duration) error {
+	return mdb.updateLeasedJob(jobId, owner, bson.M{
+		"lease_until": primitive.NewDateTimeFromTime(time.Now().Add(leaseFor)),
+	})
+}
+
+func (mdb *JobQueueDBWrapper) Complete(jobId string, owner string) error {
+	return mdb.updateLeasedJob(jobId, owner, bson.M{
+		"status":     models.JobStatusSucceeded,
+		"last_error": "",
+	})
+}
+
+func (mdb *JobQueueDBWrapper) Retry(jobId string, owner string, lastError string, runAt time.Time) error {
+	return mdb.updateLeasedJob(jobId, owner, bson.M{
+		"status":     models.JobStatusPending,
+		"last_error": lastError,
+		"run_at":     primitive.NewDateTimeFromTime(runAt),
+	})
+}
+
+func (mdb *JobQueueDBWrapper) DeadLetter(jobId string, owner string, lastError string) error {
+	return mdb.updateLeasedJob(jobId, owner, bson.M{
+		"status":     models.JobStatusDead,
+		"last_error": lastError,
+	})
+}
+
+// updateLeasedJob, applies the update only while the caller still owns the lease, so a worker
+// which lost its lease can't overwrite the outcome of the worker that took the job over.
+
+func (mdb *JobQueueDBWrapper) updateLeasedJob(jobId string, owner string, setFields bson.M) error {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(jobId)
+	if err != nil {
+		return err
+	}
+
+	setFields["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+	result, err := mdb.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectId, "lease_owner": owner, "status": models.JobStatusRunning},
+		bson.M{"$set": setFields},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+func (mdb *JobQueueDBWrapper) GetJobById(jobId string) (*models.Job, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	job := models.Job{}
+	if err = mdb.collection.FindOne(ctx, bson.M{"_id": objectId}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (mdb *JobQueueDBWrapper) CountOutstandingJobs(fileId string) (int64, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	return mdb.collection.CountDocuments(ctx, bson.M{
+		"file_id": fileId,
+		"status":  bson.M{"$in": bson.A{models.JobStatusPending, models.JobStatusRunning}},
+	})
+}
+
+func (mdb *JobQueueDBWrapper) CountDeadJobs(fileId string) (int64, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	return mdb.collection.CountDocuments(ctx, bson.M{"file_id": fileId, "status": models.JobStatusDead})
+}
@@ -3,15 +3,27 @@ package dbconnectors
 import (
 	"bytes"
 	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/metrics"
 	"city_os/src/models"
+	"city_os/src/tracing"
 	"city_os/src/utils"
 	"context"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"strings"
+	"sync"
+	"time"
 )
 
 type IDBClient interface {
@@ -37,8 +49,11 @@ func (mcli *MongoDBClient) InitConnection(settingsRaw interface{}) {
 	opts := options.Client()
 	opts.ApplyURI(settings.URI)
 	opts.SetMaxPoolSize(settings.PoolSize)
+	if settings.PoolMonitor != nil {
+		opts.SetPoolMonitor(settings.PoolMonitor)
+	}
 	if client, err = mongo.Connect(context.Background(), opts); err != nil {
-		logger.Logger.Fatal("Database connection failed!! Error: %v", err.Error())
+		logger.Logger.Fatalf("Database connection failed!! Error: %v", err.Error())
 	}
 	mcli.conn = client
 	mcli.settings = settings
@@ -58,25 +73,147 @@ type MongoDBSettings struct {
 	VideoCatalogueDB         string
 	VideoFilesCollection     string
 	VideoCatalogueCollection string
+	JobsCollection           string
+
+	WebhooksCollection          string
+	WebhookDeliveriesCollection string
+
+	EventsCollection   string
+	CountersCollection string
+	APIKeysCollection  string
+	UsageCollection    string
+	SharesCollection   string
+	DataKeysCollection string
+	// Tenant isolation, TenantIsolationBucket or TenantIsolationPrefix
+	TenantIsolation string
+	// Transactions need a replica set, without them catalogue changes and outbox events are written
+	// one after another, an event can get lost on crash and concurrent events can become visible out of order
+	UseTransactions bool
+	// Optional, receives the connection pool events, e.g. for the pool metrics
+	PoolMonitor *event.PoolMonitor
+	// Deadlines of single operations, none when 0
+	Timeouts OperationTimeouts
 }
 
+// VideoCatalogueDBWrapper, catalogue documents. The wrapper set up by InitDatabase is not bound to
+// a tenant, ForTenant returns the view used for the tenant's requests.
+
 type VideoCatalogueDBWrapper struct {
-	collection *mongo.Collection
+	client          *mongo.Client
+	database        *mongo.Database
+	collection      *mongo.Collection
+	collectionName  string
+	events          *mongo.Collection
+	counters        *mongo.Collection
+	useTransactions bool
+	isolation       string
+	tenant          string    // empty when not bound to a tenant
+	indexed         *sync.Map // names of the collections whose indexes exist, shared by the tenant views
+	timeouts        OperationTimeouts
 }
 
 func (mdb *VideoCatalogueDBWrapper) InitDatabase(dbClient IDBClient) {
 	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
-	mdb.collection = dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB).Collection(dbSettings.VideoCatalogueCollection)
+	mdb.client = dbClient.GetConnection().(*mongo.Client)
+	mdb.database = mdb.client.Database(dbSettings.VideoCatalogueDB)
+	mdb.collectionName = dbSettings.VideoCatalogueCollection
+	mdb.collection = mdb.database.Collection(mdb.collectionName)
+	mdb.events = mdb.database.Collection(dbSettings.EventsCollection)
+	mdb.counters = mdb.database.Collection(dbSettings.CountersCollection)
+	mdb.useTransactions = dbSettings.UseTransactions
+	mdb.timeouts = dbSettings.Timeouts
+
+	mdb.indexed = &sync.Map{}
+
+	mdb.isolation = dbSettings.TenantIsolation
+	if mdb.isolation != TenantIsolationPrefix {
+		mdb.isolation = TenantIsolationBucket
+	}
+	// the shared collection, or in prefix mode the default tenant's one, the other tenants' collections
+	// get theirs with their first use
+	mdb.ensureIndexes()
 }
 
-func (mdb *VideoCatalogueDBWrapper) GetDocumentById(id string) (interface{}, error) {
+// ensureIndexes, creating the catalogue indexes of the collection unless they were created before by this
+// process. Duplicate detection looks files up by hash, the index is unique so that of concurrent uploads of
+// the same file only one is stored. A failure is logged and retried with the next use.
+
+func (mdb *VideoCatalogueDBWrapper) ensureIndexes() {
+	name := mdb.collection.Name()
+	if _, done := mdb.indexed.Load(name); done {
+		return
+	}
+	keys := bson.D{{Key: "hash", Value: 1}}
+	if mdb.isolation == TenantIsolationBucket {
+		keys = bson.D{{Key: "tenant", Value: 1}, {Key: "hash", Value: 1}}
+	}
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+	index := mongo.IndexModel{Keys: keys, Options: options.Index().SetUnique(true)}
+	if _, err := mdb.collection.Indexes().CreateOne(ctx, index); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Catalogue index creation failed!! collection: %s, Error: %s", name, err.Error()))
+		return
+	}
+	mdb.indexed.Store(name, true)
+}
+
+// ForTenant, view of the tenant's catalogue, a prefixed collection or the tenant's documents of the
+// shared collection depending on the isolation mode
+
+func (mdb *VideoCatalogueDBWrapper) ForTenant(tenant string) interfaces.IDBWrapper {
+	scoped := *mdb
+	scoped.tenant = models.NormalizeTenant(tenant)
+	if mdb.isolation == TenantIsolationPrefix {
+		scoped.collection = mdb.database.Collection(tenantName(scoped.tenant, mdb.collectionName))
+		scoped.ensureIndexes()
+	}
+	return &scoped
+}
+
+// scope, restricting a filter to the bound tenant in a shared collection
+func (mdb *VideoCatalogueDBWrapper) scope(filterCondition interface{}) interface{} {
+	if mdb.tenant == "" || mdb.isolation == TenantIsolationPrefix {
+		return filterCondition
+	}
+	return withTenant(filterCondition, mdb.tenant)
+}
+
+// stampTenant, recording the bound tenant on a document to be inserted
+func (mdb *VideoCatalogueDBWrapper) stampTenant(doc bson.D) bson.D {
+	if mdb.tenant == "" {
+		return doc
+	}
+	for i, element := range doc {
+		if element.Key == "tenant" {
+			doc[i].Value = mdb.tenant
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: "tenant", Value: mdb.tenant})
+}
+
+// startSpan, span of a catalogue operation
+func (mdb *VideoCatalogueDBWrapper) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "VideoCatalogueDBWrapper."+operation,
+		semconv.DBSystemMongoDB,
+		semconv.DBOperation(operation),
+		semconv.DBMongoDBCollection(mdb.collection.Name()),
+	)
+}
+
+func (mdb *VideoCatalogueDBWrapper) GetDocumentById(ctx context.Context, id string) (_ interface{}, err error) {
+	ctx, span := mdb.startSpan(ctx, "GetDocumentById")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.readContext(ctx)
+	defer cancel()
+
 	// convert id string to ObjectId
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 	var result bson.D
-	err = mdb.collection.FindOne(context.Background(), bson.M{"_id": objectId}).Decode(&result)
+	err = mdb.collection.FindOne(ctx, mdb.scope(bson.M{"_id": objectId})).Decode(&result)
 	if err != nil {
 		return nil, err
 	}
@@ -94,44 +231,64 @@ func (mdb *VideoCatalogueDBWrapper) GetDocumentById(id string) (interface{}, err
 	return &videoCatalogueData, nil
 }
 
-func (mdb *VideoCatalogueDBWrapper) InsertDocument(insertData interface{}) (string, error) {
-	insertDocBson, err := utils.ToBson(insertData)
+func (mdb *VideoCatalogueDBWrapper) InsertDocument(ctx context.Context, insertData interface{}) (_ string, err error) {
+	ctx, span := mdb.startSpan(ctx, "InsertDocument")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
 
+	insertDocBson, err := utils.ToBson(insertData)
 	if err != nil {
-		logger.Logger.Fatal(err)
+		return "", err
 	}
 
-	result, err := mdb.collection.InsertOne(context.Background(), insertDocBson)
+	result, err := mdb.collection.InsertOne(ctx, mdb.stampTenant(*insertDocBson))
 	if err != nil {
-		logger.Logger.Fatal(err)
+		return "", err
 	}
 
 	return result.InsertedID.(primitive.ObjectID).Hex(), nil
 }
 
-func (mdb *VideoCatalogueDBWrapper) DeleteDocumentById(id string) (int64, error) {
-	objectId, err := primitive.ObjectIDFromHex(id)
+func (mdb *VideoCatalogueDBWrapper) DeleteDocumentById(ctx context.Context, id string) (_ int64, err error) {
+	ctx, span := mdb.startSpan(ctx, "DeleteDocumentById")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
 
+	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		logger.Logger.Println("Invalid id")
+		return 0, err
 	}
 
-	filter := bson.D{{"_id", objectId}}
-	result, err := mdb.collection.DeleteOne(context.TODO(), filter)
+	filter := bson.D{{Key: "_id", Value: objectId}}
+	result, err := mdb.collection.DeleteOne(ctx, mdb.scope(filter))
 	if err != nil {
-		logger.Logger.Fatal(err)
+		return 0, err
 	}
 	return result.DeletedCount, nil
 }
 
-func (mdb *VideoCatalogueDBWrapper) GetAllDocuments() ([]interface{}, error) {
-	cursor, err := mdb.collection.Find(context.TODO(), bson.D{{}})
+func (mdb *VideoCatalogueDBWrapper) GetAllDocuments(ctx context.Context) (_ []interface{}, err error) {
+	ctx, span := mdb.startSpan(ctx, "GetAllDocuments")
+	defer func() { tracing.End(span, err) }()
+
+	return mdb.GetDocumentsByFilter(ctx, bson.D{})
+}
+
+func (mdb *VideoCatalogueDBWrapper) GetDocumentsByFilter(ctx context.Context, filterCondition interface{}) (_ []interface{}, err error) {
+	ctx, span := mdb.startSpan(ctx, "GetDocumentsByFilter")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.readContext(ctx)
+	defer cancel()
+
+	cursor, err := mdb.collection.Find(ctx, mdb.scope(filterCondition))
 	if err != nil {
 		return nil, err
 	}
 
 	var results []bson.D
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err = cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
 
@@ -139,23 +296,27 @@ func (mdb *VideoCatalogueDBWrapper) GetAllDocuments() ([]interface{}, error) {
 	for _, result := range results {
 		docBytes, err := bson.Marshal(result)
 		if err != nil {
-			logger.Logger.Error(fmt.Sprintf("Bson Marshal failed, result: %v,error: %v", result, err))
+			logger.FromContext(ctx).Error(fmt.Sprintf("Bson Marshal failed, result: %v,error: %v", result, err))
 		}
 
 		videoCatalogueData := models.VideoCatalogueData{}
 		err = bson.Unmarshal(docBytes, &videoCatalogueData)
 		if err != nil {
-			logger.Logger.Error(fmt.Sprintf("Bson UnMarshal failed, result: %v,error: %v", result, err))
+			logger.FromContext(ctx).Error(fmt.Sprintf("Bson UnMarshal failed, result: %v,error: %v", result, err))
 		}
 		videoCatalogueList = append(videoCatalogueList, &videoCatalogueData)
 	}
 	return videoCatalogueList, nil
 }
 
-func (mdb *VideoCatalogueDBWrapper) GetSingleDocByFilter(filterCondition interface{}) (interface{}, error) {
+func (mdb *VideoCatalogueDBWrapper) GetSingleDocByFilter(ctx context.Context, filterCondition interface{}) (_ interface{}, err error) {
+	ctx, span := mdb.startSpan(ctx, "GetSingleDocByFilter")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.readContext(ctx)
+	defer cancel()
 
 	var result bson.D
-	err := mdb.collection.FindOne(context.Background(), filterCondition).Decode(&result)
+	err = mdb.collection.FindOne(ctx, mdb.scope(filterCondition)).Decode(&result)
 	if err != nil {
 		return nil, err
 	}
@@ -173,9 +334,148 @@ func (mdb *VideoCatalogueDBWrapper) GetSingleDocByFilter(filterCondition interfa
 	return &videoCatalogueData, nil
 }
 
+func (mdb *VideoCatalogueDBWrapper) UpdateDocumentById(ctx context.Context, id string, setFields interface{}) (_ int64, err error) {
+	ctx, span := mdb.startSpan(ctx, "UpdateDocumentById")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := mdb.collection.UpdateOne(ctx, mdb.scope(bson.M{"_id": objectId}), bson.M{"$set": setFields})
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
+
+func (mdb *VideoCatalogueDBWrapper) UpdateDocumentByIdAndFilter(ctx context.Context, id string, filterCondition interface{}, setFields interface{}) (_ int64, err error) {
+	ctx, span := mdb.startSpan(ctx, "UpdateDocumentByIdAndFilter")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.D{{Key: "$and", Value: bson.A{bson.M{"_id": objectId}, filterCondition}}}
+	result, err := mdb.collection.UpdateOne(ctx, mdb.scope(filter), bson.M{"$set": setFields})
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
+
+func (mdb *VideoCatalogueDBWrapper) InsertDocumentWithEvent(ctx context.Context, insertData interface{}, event *models.Event) (_ string, err error) {
+	ctx, span := mdb.startSpan(ctx, "InsertDocumentWithEvent")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+
+	insertDocBson, err := utils.ToBson(insertData)
+	if err != nil {
+		return "", err
+	}
+
+	// Use the caller's pre-allocated id if there is one, so the event can reference the document
+	objectId := primitive.NewObjectID()
+	doc := bson.D{}
+	for _, element := range *insertDocBson {
+		if element.Key == "_id" {
+			if objectId, err = primitive.ObjectIDFromHex(fmt.Sprint(element.Value)); err != nil {
+				return "", err
+			}
+			continue
+		}
+		doc = append(doc, element)
+	}
+	doc = mdb.stampTenant(append(bson.D{{Key: "_id", Value: objectId}}, doc...))
+
+	err = mdb.withOutbox(ctx, func(ctx context.Context) error {
+		if _, err := mdb.collection.InsertOne(ctx, doc); err != nil {
+			return err
+		}
+		return appendOutboxEvent(ctx, mdb.events, mdb.counters, event)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// unique hash index, a concurrent upload of the same file was stored first
+		return "", fmt.Errorf("%w: %s", models.ErrDuplicateFile, err.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+	return objectId.Hex(), nil
+}
+
+func (mdb *VideoCatalogueDBWrapper) DeleteDocumentByIdWithEvent(ctx context.Context, id string, event *models.Event) (_ int64, err error) {
+	ctx, span := mdb.startSpan(ctx, "DeleteDocumentByIdWithEvent")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var deletedCount int64
+	err = mdb.withOutbox(ctx, func(ctx context.Context) error {
+		result, err := mdb.collection.DeleteOne(ctx, mdb.scope(bson.M{"_id": objectId}))
+		if err != nil {
+			return err
+		}
+		deletedCount = result.DeletedCount
+		if deletedCount == 0 {
+			return nil
+		}
+		return appendOutboxEvent(ctx, mdb.events, mdb.counters, event)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deletedCount, nil
+}
+
+// withOutbox, running a catalogue change and its outbox append in one transaction
+
+func (mdb *VideoCatalogueDBWrapper) withOutbox(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTransaction(ctx, mdb.client, mdb.useTransactions, fn)
+}
+
+// withTransaction, running fn in a transaction unless transactions are disabled, WithTransaction retries
+// the whole callback on transient errors like write conflicts on the outbox counter
+
+func withTransaction(ctx context.Context, client *mongo.Client, useTransactions bool, fn func(ctx context.Context) error) error {
+	if !useTransactions {
+		return fn(ctx)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// VideoFilesDBWrapper, Video file bytes in GridFS, every tenant has its own bucket "<tenant>_fs"
+
 type VideoFilesDBWrapper struct {
+	Metrics *metrics.Metrics // Optional, GridFS operation latency
+
 	database   *mongo.Database
 	collection *mongo.Collection
+	bucketName string
+	timeouts   OperationTimeouts
 }
 
 func (mdb *VideoFilesDBWrapper) InitDatabase(dbClient IDBClient) {
@@ -184,15 +484,57 @@ func (mdb *VideoFilesDBWrapper) InitDatabase(dbClient IDBClient) {
 
 	mdb.database = dbConnection.Database(dbSettings.VideoCatalogueDB)
 	mdb.collection = mdb.database.Collection(dbSettings.VideoFilesCollection)
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.bucketName = strings.TrimSuffix(dbSettings.VideoFilesCollection, ".files")
+	if mdb.bucketName == "" {
+		mdb.bucketName = options.DefaultName
+	}
+	if err := mdb.createGridFSIndexes(context.Background()); err != nil {
+		logger.Logger.Error(fmt.Sprintf("GridFS index creation failed!! Error: %s", err.Error()))
+	}
 }
 
-func (mdb *VideoFilesDBWrapper) UploadFile(fileID string, fileDataBytes []byte, filename string) (int, error) {
-	bucket, err := gridfs.NewBucket(mdb.database)
+// ForTenant, view of the tenant's GridFS bucket
+
+func (mdb *VideoFilesDBWrapper) ForTenant(tenant string) interfaces.IFileManagerDBWrapper {
+	scoped := *mdb
+	scoped.bucketName = tenantName(tenant, mdb.bucketName)
+	scoped.collection = mdb.database.Collection(scoped.bucketName + ".files")
+	return &scoped
+}
+
+func (mdb *VideoFilesDBWrapper) bucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(mdb.database, options.GridFSBucket().SetName(mdb.bucketName))
+}
+
+func (mdb *VideoFilesDBWrapper) UploadFile(ctx context.Context, fileID string, fileData io.Reader, size int64, filename string) (int, error) {
+	ctx, span := mdb.startSpan(ctx, "UploadFile", fileID)
+	span.SetAttributes(attribute.Int64("file.size", size))
+	ctx, cancel := withTimeout(ctx, mdb.timeouts.Upload)
+	defer cancel()
+	start := time.Now()
+	fileSize, err := mdb.uploadFile(ctx, fileID, fileData, size, filename)
+	mdb.Metrics.ObserveGridFS("upload", start, err)
+	tracing.End(span, err)
+	return fileSize, err
+}
+
+// uploadFile, copying the file into the upload stream, a cancelled upload stops at the next read. Chunks
+// of an upload which doesn't complete are removed again, a failed upload leaves nothing behind in the bucket.
+
+func (mdb *VideoFilesDBWrapper) uploadFile(ctx context.Context, fileID string, fileData io.Reader, size int64, filename string) (int, error) {
+	bucket, err := mdb.bucket()
 
 	if err != nil {
-		logger.Logger.Error("GridFS new bucket creation failed!! Error: %v", err)
+		logger.FromContext(ctx).Errorf("GridFS new bucket creation failed!! Error: %v", err)
 		return 0, err
 	}
+	// GridFS of the driver takes deadlines instead of contexts
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = bucket.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
 
 	uploadStream, err := bucket.OpenUploadStreamWithID(
 		fileID,
@@ -200,54 +542,188 @@ func (mdb *VideoFilesDBWrapper) UploadFile(fileID string, fileDataBytes []byte,
 	)
 
 	if err != nil {
-		logger.Logger.Error("GridFS opening upload-stream failed!! Error: %v", err)
+		logger.FromContext(ctx).Errorf("GridFS opening upload-stream failed!! Error: %v", err)
 		return 0, err
 	}
-	defer uploadStream.Close()
 
-	fileSize, err := uploadStream.Write(fileDataBytes)
+	fileSize, err := io.Copy(uploadStream, &contextReader{ctx: ctx, r: fileData})
+	if err == nil && fileSize != size {
+		err = fmt.Errorf("file has %d bytes, %d expected", fileSize, size)
+	}
 	if err != nil {
-		logger.Logger.Error("File upload failed!! Error: %v", err)
+		logger.FromContext(ctx).Errorf("File upload failed!! Error: %v", err)
+		mdb.abortUpload(uploadStream, fileID)
 		return 0, err
 	}
 
-	logger.Logger.Info("Write file to DB was successful. File size: %d M\n", fileSize)
-	return fileSize, nil
+	// Close writes the remaining chunks and the files document
+	if err = uploadStream.Close(); err != nil {
+		logger.FromContext(ctx).Errorf("File upload failed!! Error: %v", err)
+		mdb.removeChunks(bucket, fileID)
+		return 0, err
+	}
+
+	logger.FromContext(ctx).Infof("Write file to DB was successful. File size: %d", fileSize)
+	return int(fileSize), nil
 }
 
-func (mdb *VideoFilesDBWrapper) DownloadFile(fileID string, filename string) ([]byte, error) {
-	bucket, err := gridfs.NewBucket(
-		mdb.database,
-	)
+// abortUpload, removing the chunks written so far. The stream's deadline is moved, an upload which
+// failed on its deadline could not clean up otherwise.
+func (mdb *VideoFilesDBWrapper) abortUpload(uploadStream *gridfs.UploadStream, fileID string) {
+	if err := uploadStream.SetWriteDeadline(time.Now().Add(cleanupTimeout)); err != nil {
+		logger.Logger.Errorf("Aborting upload failed!! fileId: %s, Error: %v", fileID, err)
+		return
+	}
+	if err := uploadStream.Abort(); err != nil {
+		logger.Logger.Errorf("Aborting upload failed!! fileId: %s, Error: %v", fileID, err)
+	}
+}
+
+// removeChunks, cleaning up after a failed Close, the files document may or may not have been written
+func (mdb *VideoFilesDBWrapper) removeChunks(bucket *gridfs.Bucket, fileID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := bucket.DeleteContext(ctx, fileID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		logger.Logger.Errorf("Removing chunks of failed upload failed!! fileId: %s, Error: %v", fileID, err)
+	}
+}
+
+func (mdb *VideoFilesDBWrapper) DownloadFile(ctx context.Context, fileID string, filename string) ([]byte, error) {
+	ctx, span := mdb.startSpan(ctx, "DownloadFile", fileID)
+	ctx, cancel := withTimeout(ctx, mdb.timeouts.Download)
+	defer cancel()
+	start := time.Now()
+	fileDataBytes, err := mdb.downloadFile(ctx, fileID, filename)
+	mdb.Metrics.ObserveGridFS("download", start, err)
+	span.SetAttributes(attribute.Int("file.size", len(fileDataBytes)))
+	tracing.End(span, err)
+	return fileDataBytes, err
+}
+
+func (mdb *VideoFilesDBWrapper) downloadFile(ctx context.Context, fileID string, filename string) ([]byte, error) {
+	bucket, err := mdb.bucket()
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("bucket creation failed!! Error : %v", err.Error()))
+		logger.FromContext(ctx).Errorf("GridFS new bucket creation failed!! Error: %v", err)
 		return nil, err
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = bucket.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
 
 	var buf bytes.Buffer
-	dStream, err := bucket.DownloadToStreamByName(mdb.GetFileNameHash(fileID, filename), &buf)
+	dStream, err := bucket.DownloadToStreamByName(mdb.GetFileNameHash(fileID, filename), &contextWriter{ctx: ctx, w: &buf})
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("File size to download: %v\n", dStream)
+	logger.FromContext(ctx).WithField("size", dStream).Debug("Read file from DB was successful")
 	return buf.Bytes(), nil
 }
 
-func (mdb *VideoFilesDBWrapper) DeleteFileByFileId(fileID string) error {
-	bucket, err := gridfs.NewBucket(
-		mdb.database,
+// DownloadFileRange, reading length bytes of the stored file starting at offset, the chunks before the
+// range are skipped and not transferred
+
+func (mdb *VideoFilesDBWrapper) DownloadFileRange(ctx context.Context, fileID string, filename string, offset int64, length int64) ([]byte, error) {
+	ctx, span := mdb.startSpan(ctx, "DownloadFileRange", fileID)
+	ctx, cancel := withTimeout(ctx, mdb.timeouts.Download)
+	defer cancel()
+	start := time.Now()
+	fileDataBytes, err := mdb.downloadFileRange(ctx, fileID, filename, offset, length)
+	mdb.Metrics.ObserveGridFS("download", start, err)
+	span.SetAttributes(attribute.Int64("range.offset", offset), attribute.Int("range.length", len(fileDataBytes)))
+	tracing.End(span, err)
+	return fileDataBytes, err
+}
+
+func (mdb *VideoFilesDBWrapper) downloadFileRange(ctx context.Context, fileID string, filename string, offset int64, length int64) ([]byte, error) {
+	bucket, err := mdb.bucket()
+	if err != nil {
+		logger.FromContext(ctx).Errorf("GridFS new bucket creation failed!! Error: %v", err)
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = bucket.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	dStream, err := bucket.OpenDownloadStreamByName(mdb.GetFileNameHash(fileID, filename))
+	if err != nil {
+		return nil, err
+	}
+	defer dStream.Close()
+	if _, err = dStream.Skip(offset); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&contextWriter{ctx: ctx, w: &buf}, dStream, length); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (mdb *VideoFilesDBWrapper) DeleteFileByFileId(ctx context.Context, fileID string) error {
+	ctx, span := mdb.startSpan(ctx, "DeleteFileByFileId", fileID)
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+	start := time.Now()
+	err := mdb.deleteFile(ctx, fileID)
+	mdb.Metrics.ObserveGridFS("delete", start, err)
+	tracing.End(span, err)
+	return err
+}
+
+// startSpan, span of a GridFS operation on the file
+func (mdb *VideoFilesDBWrapper) startSpan(ctx context.Context, operation string, fileID string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "VideoFilesDBWrapper."+operation,
+		semconv.DBSystemMongoDB,
+		semconv.DBOperation(operation),
+		attribute.String("gridfs.bucket", mdb.bucketName),
+		attribute.String("file.id", fileID),
 	)
+}
+
+func (mdb *VideoFilesDBWrapper) deleteFile(ctx context.Context, fileID string) error {
+	bucket, err := mdb.bucket()
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("bucket creation failed!! Error : %v", err.Error()))
+		logger.FromContext(ctx).Errorf("GridFS new bucket creation failed!! Error: %v", err)
 		return err
 	}
-	if err := bucket.Delete(fileID); err != nil {
+	if err := bucket.DeleteContext(ctx, fileID); err != nil {
 		return err
 	}
 	return nil
 }
 
+// contextWriter, stops a download once ctx is done, the driver only checks the read deadline
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// contextReader, stops an upload once ctx is done, the driver only checks the write deadline
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
 func (mdb *VideoFilesDBWrapper) GetFileNameHash(fileID string, filename string) string {
 	return fmt.Sprintf("%s_%s", fileID, filename)
 }
@@ -0,0 +1,44 @@
+package dbconnectors
+
+import (
+	logger "city_os/src/common"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestClient, client of a throwaway database on the replica set in CITY_OS_TEST_MONGODB_URI, the
+// database is dropped after the test. Tests needing Mongo are skipped without it.
+func newTestClient(t *testing.T) *MongoDBClient {
+	uri := os.Getenv("CITY_OS_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("CITY_OS_TEST_MONGODB_URI not set")
+	}
+	if logger.Logger == nil {
+		logger.Logger = log.New()
+		logger.Logger.Out = io.Discard
+	}
+
+	settings := &MongoDBSettings{
+		URI:                uri,
+		PoolSize:           20,
+		VideoCatalogueDB:   fmt.Sprintf("city_os_test_%d", time.Now().UnixNano()),
+		EventsCollection:   "Events",
+		CountersCollection: "Counters",
+		UsageCollection:    "Usage",
+		UseTransactions:    true,
+	}
+	client := &MongoDBClient{}
+	client.InitConnection(settings)
+	t.Cleanup(func() {
+		conn := client.GetConnection().(*mongo.Client)
+		conn.Database(settings.VideoCatalogueDB).Drop(context.Background())
+		conn.Disconnect(context.Background())
+	})
+	return client
+}
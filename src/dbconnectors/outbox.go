@@ -0,0 +1,157 @@
+package dbconnectors
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const outboxCounterId = "events"
+
+// OutboxDBWrapper, event outbox of catalogue changes. Events are appended with a sequence number taken
+// from a counter document, inside a transaction the counter update serialises writers so that sequence
+// order equals commit order and a consumer resuming from a cursor can't skip an event. Every append runs
+// in a transaction, the ones of catalogue changes in the change's.
+
+type OutboxDBWrapper struct {
+	client          *mongo.Client
+	events          *mongo.Collection
+	counters        *mongo.Collection
+	useTransactions bool
+	timeouts        OperationTimeouts
+}
+
+func (mdb *OutboxDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.useTransactions = dbSettings.UseTransactions
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.client = dbClient.GetConnection().(*mongo.Client)
+	database := mdb.client.Database(dbSettings.VideoCatalogueDB)
+	mdb.events = database.Collection(dbSettings.EventsCollection)
+	mdb.counters = database.Collection(dbSettings.CountersCollection)
+
+	if _, err := mdb.events.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "published", Value: 1}, {Key: "_id", Value: 1}},
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Events index creation failed!! Error: %s", err.Error()))
+	}
+	if _, err := mdb.events.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "_id", Value: 1}},
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Events index creation failed!! Error: %s", err.Error()))
+	}
+}
+
+func (mdb *OutboxDBWrapper) AppendEvent(event *models.Event) (int64, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	err := withTransaction(ctx, mdb.client, mdb.useTransactions, func(ctx context.Context) error {
+		return appendOutboxEvent(ctx, mdb.events, mdb.counters, event)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return event.Seq, nil
+}
+
+func (mdb *OutboxDBWrapper) GetEventsSince(tenant string, seq int64, limit int64) ([]*models.Event, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	filter := bson.D{{Key: "_id", Value: bson.M{"$gt": seq}}, tenantCondition(tenant)}
+	cursor, err := mdb.events.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*models.Event, 0)
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ClaimNextUnpublishedEvent, claiming the unpublished event with the lowest sequence number. Events are
+// relayed strictly in order: while another relay holds the claim of that event nothing is claimed, the
+// owner of the claim gets the same event again with the claim extended.
+
+func (mdb *OutboxDBWrapper) ClaimNextUnpublishedEvent(owner string, claimFor time.Duration) (*models.Event, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	next := models.Event{}
+	err := mdb.events.FindOne(ctx, bson.M{"published": false}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})).Decode(&next)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"_id":       next.Seq,
+		"published": false,
+		"$or": bson.A{
+			bson.M{"claim_until": bson.M{"$exists": false}},
+			bson.M{"claim_until": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+			bson.M{"claim_owner": owner},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"claim_owner": owner,
+		"claim_until": primitive.NewDateTimeFromTime(now.Add(claimFor)),
+	}}
+	event := models.Event{}
+	err = mdb.events.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&event)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (mdb *OutboxDBWrapper) MarkEventPublished(seq int64, owner string) error {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	_, err := mdb.events.UpdateOne(
+		ctx,
+		bson.M{"_id": seq, "claim_owner": owner},
+		bson.M{"$set": bson.M{"published": true}},
+	)
+	return err
+}
+
+// appendOutboxEvent, shared by the outbox and the catalogue wrapper, ctx may carry a transaction session
+
+func appendOutboxEvent(ctx context.Context, events *mongo.Collection, counters *mongo.Collection, event *models.Event) error {
+	counter := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+	err := counters.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": outboxCounterId},
+		bson.M{"$inc": bson.M{"seq": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return err
+	}
+
+	event.Seq = counter.Seq
+	event.Published = false
+	_, err = events.InsertOne(ctx, event)
+	return err
+}
@@ -0,0 +1,153 @@
+package dbconnectors
+
+import (
+	"city_os/src/models"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestOutbox(t *testing.T) *OutboxDBWrapper {
+	outbox := &OutboxDBWrapper{}
+	outbox.InitDatabase(newTestClient(t))
+	return outbox
+}
+
+func appendTestEvents(t *testing.T, outbox *OutboxDBWrapper, count int) {
+	for i := 0; i < count; i++ {
+		if _, err := outbox.AppendEvent(&models.Event{Type: models.EventFileCreated}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOutboxClaimsAreExclusiveAndOrdered(t *testing.T) {
+	outbox := newTestOutbox(t)
+	appendTestEvents(t, outbox, 3)
+
+	claim := func(owner string, claimFor time.Duration) int64 {
+		event, err := outbox.ClaimNextUnpublishedEvent(owner, claimFor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			return 0
+		}
+		return event.Seq
+	}
+
+	if seq := claim("relay-a", time.Minute); seq != 1 {
+		t.Fatalf("relay-a claimed %d, expected 1", seq)
+	}
+	// Event 2 is free but must wait for event 1
+	if seq := claim("relay-b", time.Minute); seq != 0 {
+		t.Fatalf("relay-b claimed %d while relay-a holds event 1", seq)
+	}
+	if seq := claim("relay-a", time.Minute); seq != 1 {
+		t.Fatalf("relay-a reclaimed %d, expected its event 1", seq)
+	}
+	if err := outbox.MarkEventPublished(1, "relay-b"); err != nil {
+		t.Fatal(err)
+	}
+	if seq := claim("relay-a", time.Minute); seq != 1 {
+		t.Fatalf("event 1 marked published by a relay not holding the claim, next is %d", seq)
+	}
+
+	if err := outbox.MarkEventPublished(1, "relay-a"); err != nil {
+		t.Fatal(err)
+	}
+	if seq := claim("relay-a", time.Millisecond); seq != 2 {
+		t.Fatalf("relay-a claimed %d, expected 2", seq)
+	}
+	time.Sleep(10 * time.Millisecond)
+	// relay-a's claim ran out
+	if seq := claim("relay-b", time.Minute); seq != 2 {
+		t.Fatalf("relay-b claimed %d after relay-a's claim ran out, expected 2", seq)
+	}
+	if seq := claim("relay-a", time.Minute); seq != 0 {
+		t.Fatalf("relay-a claimed %d while relay-b holds event 2", seq)
+	}
+}
+
+func TestOutboxConcurrentClaimsHandOutOneEvent(t *testing.T) {
+	outbox := newTestOutbox(t)
+	appendTestEvents(t, outbox, 1)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			event, err := outbox.ClaimNextUnpublishedEvent(owner, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if event != nil {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("relay-%d", i))
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("%d relays claimed the event, expected 1", claimed)
+	}
+}
+
+func TestOutboxResumeDuringConcurrentAppendsHasNoGaps(t *testing.T) {
+	outbox := newTestOutbox(t)
+	const writers, perWriter = 8, 25
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if _, err := outbox.AppendEvent(&models.Event{Type: models.EventFileCreated}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	appended := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(appended)
+	}()
+
+	// A consumer reading with its cursor while the writers commit must see every sequence number
+	var since int64
+	finished := false
+	for !finished {
+		select {
+		case <-appended:
+			finished = true
+		default:
+		}
+		for {
+			events, err := outbox.GetEventsSince("", since, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) == 0 {
+				break
+			}
+			for _, event := range events {
+				if event.Seq != since+1 {
+					t.Fatalf("cursor %d followed by event %d, events were skipped", since, event.Seq)
+				}
+				since = event.Seq
+			}
+		}
+	}
+	if since != writers*perWriter {
+		t.Fatalf("consumer stopped at %d, expected %d", since, writers*perWriter)
+	}
+}
@@ -0,0 +1,104 @@
+package dbconnectors
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ShareDBWrapper, signed URL records, MongoDB removes them once they are expired
+
+type ShareDBWrapper struct {
+	collection *mongo.Collection
+	timeouts   OperationTimeouts
+}
+
+func (mdb *ShareDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.collection = dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB).Collection(dbSettings.SharesCollection)
+
+	if _, err := mdb.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Shares index creation failed!! Error: %s", err.Error()))
+	}
+}
+
+func (mdb *ShareDBWrapper) InsertShare(share *models.Share) (string, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	return insertAndGetHexId(ctx, mdb.collection, share)
+}
+
+// GetShare, share without counting a use, nil share when it is unknown
+func (mdb *ShareDBWrapper) GetShare(shareId string) (*models.Share, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(shareId)
+	if err != nil {
+		return nil, nil
+	}
+
+	share := models.Share{}
+	err = mdb.collection.FindOne(ctx, bson.M{"_id": objectId}).Decode(&share)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// ConsumeShare, counting a use of the share, nil share when it is unknown or used up
+func (mdb *ShareDBWrapper) ConsumeShare(shareId string) (*models.Share, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(shareId)
+	if err != nil {
+		return nil, nil
+	}
+
+	filter := bson.M{
+		"_id": objectId,
+		"$or": bson.A{
+			bson.M{"max_uses": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+		},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	share := models.Share{}
+	err = mdb.collection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}}, opts).Decode(&share)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// ReleaseShare, giving back a use of the share, e.g. of an upload which was rejected
+func (mdb *ShareDBWrapper) ReleaseShare(shareId string) error {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(shareId)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": objectId, "uses": bson.M{"$gt": 0}}
+	_, err = mdb.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": -1}})
+	return err
+}
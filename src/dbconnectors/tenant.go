@@ -0,0 +1,39 @@
+package dbconnectors
+
+import (
+	"city_os/src/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Tenant isolation modes of MongoDBSettings.TenantIsolation. Both give every tenant its own GridFS bucket,
+// they differ in where the catalogue documents live.
+const (
+	TenantIsolationBucket = "bucket" // shared catalogue collection, documents carry and are filtered by tenant
+	TenantIsolationPrefix = "prefix" // catalogue collection per tenant, "<tenant>_<collection>"
+)
+
+// tenantName, collection or bucket name of the tenant, the default tenant keeps the plain name
+
+func tenantName(tenant string, name string) string {
+	tenant = models.NormalizeTenant(tenant)
+	if tenant == models.DefaultTenant {
+		return name
+	}
+	return tenant + "_" + name
+}
+
+// tenantCondition, filter element matching the tenant's documents, documents written before tenancy
+// have no tenant field and belong to the default tenant
+
+func tenantCondition(tenant string) bson.E {
+	tenant = models.NormalizeTenant(tenant)
+	if tenant == models.DefaultTenant {
+		return bson.E{Key: "tenant", Value: bson.M{"$in": bson.A{tenant, nil}}}
+	}
+	return bson.E{Key: "tenant", Value: tenant}
+}
+
+// withTenant, restricting an arbitrary filter to the tenant's documents
+func withTenant(filterCondition interface{}, tenant string) bson.D {
+	return bson.D{{Key: "$and", Value: bson.A{filterCondition, bson.D{tenantCondition(tenant)}}}}
+}
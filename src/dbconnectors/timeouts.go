@@ -0,0 +1,35 @@
+package dbconnectors
+
+import (
+	"context"
+	"time"
+)
+
+// OperationTimeouts, deadlines of single database operations on top of the caller's context, which
+// is cancelled when the client goes away. No deadline of its own when 0.
+
+type OperationTimeouts struct {
+	Read     time.Duration // finds and counts
+	Write    time.Duration // inserts, updates, deletes and catalogue transactions
+	Upload   time.Duration // storing a file in GridFS
+	Download time.Duration // reading a file from GridFS
+}
+
+// cleanupTimeout, time given to removing the chunks of an aborted upload, the caller's context is
+// usually done by then
+const cleanupTimeout = 30 * time.Second
+
+func (t OperationTimeouts) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Read)
+}
+
+func (t OperationTimeouts) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,87 @@
+package dbconnectors
+
+import (
+	"city_os/src/models"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UsageDBWrapper, usage counters of tenants and of principals within a tenant. Counters are changed with
+// single conditional $inc updates, so concurrent uploads can't overshoot a quota together.
+
+type UsageDBWrapper struct {
+	collection *mongo.Collection
+	timeouts   OperationTimeouts
+}
+
+func (mdb *UsageDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.timeouts = dbSettings.Timeouts
+	mdb.collection = dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB).Collection(dbSettings.UsageCollection)
+}
+
+// ReserveUsage, adding bytes and files to the counter when the result stays within the quota,
+// false when it wouldn't
+func (mdb *UsageDBWrapper) ReserveUsage(ctx context.Context, tenant string, subject string, bytes int64, files int64, quota models.Quota) (bool, error) {
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+
+	usageId := usageDocId(tenant, subject)
+	_, err := mdb.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": usageId},
+		bson.M{"$setOnInsert": bson.M{"tenant": models.NormalizeTenant(tenant), "subject": subject, "bytes": int64(0), "files": int64(0)}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	filter := bson.M{"_id": usageId}
+	if quota.MaxBytes > 0 {
+		filter["bytes"] = bson.M{"$lte": quota.MaxBytes - bytes}
+	}
+	if quota.MaxFiles > 0 {
+		filter["files"] = bson.M{"$lte": quota.MaxFiles - files}
+	}
+	result, err := mdb.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"bytes": bytes, "files": files}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (mdb *UsageDBWrapper) ReleaseUsage(ctx context.Context, tenant string, subject string, bytes int64, files int64) error {
+	ctx, cancel := mdb.timeouts.writeContext(ctx)
+	defer cancel()
+
+	_, err := mdb.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": usageDocId(tenant, subject)},
+		bson.M{"$inc": bson.M{"bytes": -bytes, "files": -files}},
+	)
+	return err
+}
+
+// GetUsage, counter of the subject, zero usage when nothing was stored yet
+func (mdb *UsageDBWrapper) GetUsage(ctx context.Context, tenant string, subject string) (*models.Usage, error) {
+	ctx, cancel := mdb.timeouts.readContext(ctx)
+	defer cancel()
+
+	usage := models.Usage{}
+	err := mdb.collection.FindOne(ctx, bson.M{"_id": usageDocId(tenant, subject)}).Decode(&usage)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.Usage{Tenant: models.NormalizeTenant(tenant), Subject: subject}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func usageDocId(tenant string, subject string) string {
+	return models.NormalizeTenant(tenant) + "/" + subject
+}
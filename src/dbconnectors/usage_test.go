@@ -0,0 +1,57 @@
+package dbconnectors
+
+import (
+	"city_os/src/models"
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestReserveUsageConcurrentlyStaysWithinQuota(t *testing.T) {
+	usage := &UsageDBWrapper{}
+	usage.InitDatabase(newTestClient(t))
+	quota := models.Quota{MaxBytes: 100, MaxFiles: 8}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := usage.ReserveUsage(context.Background(), "acme", models.UsageSubjectTenant, 15, 1, quota)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 6 files of 15 bytes fit into 100 bytes
+	if reserved != 6 {
+		t.Fatalf("%d reservations succeeded, expected 6", reserved)
+	}
+	counter, err := usage.GetUsage(context.Background(), "acme", models.UsageSubjectTenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.Bytes != 90 || counter.Files != 6 {
+		t.Fatalf("counter has %d bytes and %d files, expected 90 and 6", counter.Bytes, counter.Files)
+	}
+
+	if err = usage.ReleaseUsage(context.Background(), "acme", models.UsageSubjectTenant, 15, 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := usage.ReserveUsage(context.Background(), "acme", models.UsageSubjectTenant, 25, 1, quota); !ok {
+		t.Fatal("reservation within the quota after a release was refused")
+	}
+	if ok, _ := usage.ReserveUsage(context.Background(), "acme", models.UsageSubjectTenant, 1, 1, quota); ok {
+		t.Fatal("reservation beyond the byte quota succeeded")
+	}
+}
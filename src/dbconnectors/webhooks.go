@@ -0,0 +1,202 @@
+package dbconnectors
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"city_os/src/utils"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// WebhookDBWrapper, storage of webhook subscriptions and their delivery log
+
+type WebhookDBWrapper struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+	timeouts   OperationTimeouts
+}
+
+func (mdb *WebhookDBWrapper) InitDatabase(dbClient IDBClient) {
+	dbSettings := dbClient.GetDBSettings().(*MongoDBSettings)
+	mdb.timeouts = dbSettings.Timeouts
+	database := dbClient.GetConnection().(*mongo.Client).Database(dbSettings.VideoCatalogueDB)
+	mdb.webhooks = database.Collection(dbSettings.WebhooksCollection)
+	mdb.deliveries = database.Collection(dbSettings.WebhookDeliveriesCollection)
+
+	if _, err := mdb.webhooks.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "events", Value: 1}, {Key: "active", Value: 1}},
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Webhooks index creation failed!! Error: %s", err.Error()))
+	}
+	if _, err := mdb.deliveries.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}},
+	}); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Webhook deliveries index creation failed!! Error: %s", err.Error()))
+	}
+}
+
+func (mdb *WebhookDBWrapper) InsertWebhook(webhook *models.Webhook) (string, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	return insertAndGetHexId(ctx, mdb.webhooks, webhook)
+}
+
+func (mdb *WebhookDBWrapper) GetWebhookById(webhookId string) (*models.Webhook, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(webhookId)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := models.Webhook{}
+	if err = mdb.webhooks.FindOne(ctx, bson.M{"_id": objectId}).Decode(&webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (mdb *WebhookDBWrapper) GetWebhooksByTenant(tenant string) ([]*models.Webhook, error) {
+	return mdb.findWebhooks(bson.D{tenantCondition(tenant)})
+}
+
+func (mdb *WebhookDBWrapper) GetActiveWebhooksByEvent(tenant string, eventType string) ([]*models.Webhook, error) {
+	return mdb.findWebhooks(bson.D{{Key: "events", Value: eventType}, {Key: "active", Value: true}, tenantCondition(tenant)})
+}
+
+func (mdb *WebhookDBWrapper) findWebhooks(filter bson.D) ([]*models.Webhook, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	cursor, err := mdb.webhooks.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*models.Webhook, 0)
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (mdb *WebhookDBWrapper) UpdateWebhookById(webhookId string, setFields interface{}) (int64, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	return updateByHexId(ctx, mdb.webhooks, webhookId, setFields)
+}
+
+func (mdb *WebhookDBWrapper) DeleteWebhookById(webhookId string) (int64, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(webhookId)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := mdb.webhooks.DeleteOne(ctx, bson.M{"_id": objectId})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (mdb *WebhookDBWrapper) InsertDelivery(delivery *models.WebhookDelivery) (string, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	return insertAndGetHexId(ctx, mdb.deliveries, delivery)
+}
+
+func (mdb *WebhookDBWrapper) GetDeliveryById(deliveryId string) (*models.WebhookDelivery, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(deliveryId)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := models.WebhookDelivery{}
+	if err = mdb.deliveries.FindOne(ctx, bson.M{"_id": objectId}).Decode(&delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (mdb *WebhookDBWrapper) GetDeliveriesByWebhookId(webhookId string, limit int64) ([]*models.WebhookDelivery, error) {
+	ctx, cancel := mdb.timeouts.readContext(context.Background())
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := mdb.deliveries.Find(ctx, bson.M{"webhook_id": webhookId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (mdb *WebhookDBWrapper) UpdateDeliveryById(deliveryId string, setFields interface{}) (int64, error) {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	return updateByHexId(ctx, mdb.deliveries, deliveryId, setFields)
+}
+
+func (mdb *WebhookDBWrapper) AppendDeliveryAttempt(deliveryId string, attempt models.WebhookDeliveryAttempt, status string) error {
+	ctx, cancel := mdb.timeouts.writeContext(context.Background())
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(deliveryId)
+	if err != nil {
+		return err
+	}
+
+	_, err = mdb.deliveries.UpdateOne(ctx, bson.M{"_id": objectId}, bson.M{
+		"$push": bson.M{"attempts": attempt},
+		"$set":  bson.M{"status": status, "updated_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return err
+}
+
+// insertAndGetHexId, inserting a model and returning the generated ObjectID as hex string
+
+func insertAndGetHexId(ctx context.Context, collection *mongo.Collection, insertData interface{}) (string, error) {
+	insertDocBson, err := utils.ToBson(insertData)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := collection.InsertOne(ctx, insertDocBson)
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+func updateByHexId(ctx context.Context, collection *mongo.Collection, id string, setFields interface{}) (int64, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objectId}, bson.M{"$set": setFields})
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// File bytes are sealed in chunks of ChunkSize plaintext bytes, each with AES-256-GCM under the file's
+// data key, so a byte range can be decrypted from the chunks covering it without reading the whole file.
+// A chunk is stored as its ciphertext followed by the GCM tag. The data key is used for a single file,
+// which allows the chunk index as nonce. The additional data binds every chunk to the file id, its
+// position and whether it is the last one, so chunks can't be swapped, reordered or cut off unnoticed.
+
+const (
+	DefaultChunkSize = 64 << 10
+	dataKeySize      = 32
+	tagSize          = 16
+)
+
+var (
+	ErrDecryptionFailed = errors.New("decryption failed")
+	ErrSealFailed       = errors.New("encryption failed")
+)
+
+// Sealer, reader of the sealed file of the size plaintext bytes read from Plaintext, the plaintext is
+// read one chunk at a time. An empty file still gets one (empty) chunk.
+
+type Sealer struct {
+	aead      cipher.AEAD
+	fileId    string
+	plaintext io.Reader
+	size      int64
+	chunkSize int
+	chunks    int64
+
+	index   int64  // next chunk to seal
+	chunk   []byte // plaintext of the chunk being sealed
+	sealed  []byte // the sealed chunk
+	pending []byte // bytes of sealed not read yet
+}
+
+func NewSealer(dataKey []byte, fileId string, plaintext io.Reader, size int64, chunkSize int) (*Sealer, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{
+		aead:      aead,
+		fileId:    fileId,
+		plaintext: plaintext,
+		size:      size,
+		chunkSize: chunkSize,
+		chunks:    chunkCount(size, chunkSize),
+		chunk:     make([]byte, chunkSize),
+	}, nil
+}
+
+// SealedSize, size of the sealed file of size plaintext bytes
+func SealedSize(size int64, chunkSize int) int64 {
+	return size + chunkCount(size, chunkSize)*tagSize
+}
+
+func (s *Sealer) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		if s.index == s.chunks {
+			// A plaintext longer than its size would be cut off unnoticed
+			if n, _ := s.plaintext.Read(s.chunk[:1]); n > 0 {
+				return 0, fmt.Errorf("%w: plaintext exceeds its size of %d bytes", ErrSealFailed, s.size)
+			}
+			return 0, io.EOF
+		}
+		length := s.size - s.index*int64(s.chunkSize)
+		if length > int64(s.chunkSize) {
+			length = int64(s.chunkSize)
+		}
+		if _, err := io.ReadFull(s.plaintext, s.chunk[:length]); err != nil {
+			return 0, fmt.Errorf("%w: chunk %d: %s", ErrSealFailed, s.index, err.Error())
+		}
+		s.sealed = s.aead.Seal(s.sealed[:0], nonce(s.index), s.chunk[:length], additionalData(s.fileId, s.index, s.index == s.chunks-1))
+		s.pending = s.sealed
+		s.index++
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Open, decrypting a whole sealed file
+func Open(dataKey []byte, fileId string, sealed []byte, chunkSize int) ([]byte, error) {
+	sealedChunkSize := int64(chunkSize + tagSize)
+	chunks := (int64(len(sealed)) + sealedChunkSize - 1) / sealedChunkSize
+	if chunks == 0 {
+		return nil, fmt.Errorf("%w: empty ciphertext", ErrDecryptionFailed)
+	}
+	return OpenRange(dataKey, fileId, sealed, 0, chunks, chunkSize)
+}
+
+// SealedRange, the chunk aligned byte range [start, end) of the sealed file holding the plaintext bytes
+// [offset, offset+length) and the index of its first chunk, size is the plaintext size of the file
+func SealedRange(offset int64, length int64, size int64, chunkSize int) (start int64, end int64, firstChunk int64) {
+	chunks := chunkCount(size, chunkSize)
+	firstChunk = offset / int64(chunkSize)
+	lastChunk := (offset + length - 1) / int64(chunkSize)
+	if lastChunk >= chunks {
+		lastChunk = chunks - 1
+	}
+	start = firstChunk * int64(chunkSize+tagSize)
+	end = (lastChunk + 1) * int64(chunkSize+tagSize)
+	if end > size+chunks*tagSize {
+		end = size + chunks*tagSize
+	}
+	return start, end, firstChunk
+}
+
+// OpenRange, decrypting consecutive sealed chunks starting with chunk firstChunk of a file sealed as
+// chunks chunks in total, e.g. the range returned by SealedRange
+func OpenRange(dataKey []byte, fileId string, sealed []byte, firstChunk int64, chunks int64, chunkSize int) ([]byte, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, 0, len(sealed))
+	for index := firstChunk; len(sealed) > 0; index++ {
+		chunk := sealed
+		if len(chunk) > chunkSize+tagSize {
+			chunk = chunk[:chunkSize+tagSize]
+		}
+		sealed = sealed[len(chunk):]
+		if index >= chunks || (index < chunks-1 && len(chunk) != chunkSize+tagSize) {
+			return nil, fmt.Errorf("%w: chunk %d out of place", ErrDecryptionFailed, index)
+		}
+		plaintext, err = aead.Open(plaintext, nonce(index), chunk, additionalData(fileId, index, index == chunks-1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: chunk %d: %s", ErrDecryptionFailed, index, err.Error())
+		}
+	}
+	return plaintext, nil
+}
+
+func chunkCount(size int64, chunkSize int) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + int64(chunkSize) - 1) / int64(chunkSize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+func additionalData(fileId string, index int64, last bool) []byte {
+	data := binary.BigEndian.AppendUint64([]byte(fileId), uint64(index))
+	if last {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
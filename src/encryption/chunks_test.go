@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+const testChunkSize = 16
+
+var testDataKey = bytes.Repeat([]byte{7}, dataKeySize)
+
+func seal(t *testing.T, plaintext []byte, size int64, reader func(io.Reader) io.Reader) ([]byte, error) {
+	t.Helper()
+	sealer, err := NewSealer(testDataKey, "file", bytes.NewReader(plaintext), size, testChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return io.ReadAll(reader(sealer))
+}
+
+func TestSealerRoundTrip(t *testing.T) {
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole":    func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+	}
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 3 * testChunkSize} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+		for name, reader := range readers {
+			sealed, err := seal(t, plaintext, int64(size), reader)
+			if err != nil {
+				t.Fatalf("size %d, %s reads: %s", size, name, err)
+			}
+			if int64(len(sealed)) != SealedSize(int64(size), testChunkSize) {
+				t.Fatalf("size %d, %s reads: sealed %d bytes, SealedSize %d", size, name, len(sealed), SealedSize(int64(size), testChunkSize))
+			}
+			opened, err := Open(testDataKey, "file", sealed, testChunkSize)
+			if err != nil {
+				t.Fatalf("size %d, %s reads: %s", size, name, err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Fatalf("size %d, %s reads: opened plaintext differs", size, name)
+			}
+		}
+	}
+}
+
+func TestSealerRejectsPlaintextOfOtherSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext int
+		size      int64
+	}{
+		{"shorter", testChunkSize, testChunkSize + 1},
+		{"longer", testChunkSize + 1, testChunkSize},
+		{"longer than empty", 1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := seal(t, make([]byte, test.plaintext), test.size, func(r io.Reader) io.Reader { return r })
+			if !errors.Is(err, ErrSealFailed) {
+				t.Fatalf("error %v, expected %v", err, ErrSealFailed)
+			}
+		})
+	}
+}
+
+// TestSealedChunksCantBeCutOff, a sealed file missing its last chunk doesn't open
+func TestSealedChunksCantBeCutOff(t *testing.T) {
+	sealed, err := seal(t, make([]byte, 2*testChunkSize), 2*testChunkSize, func(r io.Reader) io.Reader { return r })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(testDataKey, "file", sealed[:testChunkSize+tagSize], testChunkSize); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("error %v, expected %v", err, ErrDecryptionFailed)
+	}
+}
@@ -0,0 +1,201 @@
+package encryption
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"city_os/src/tracing"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"time"
+)
+
+// EncryptedFileManager, envelope encryption around any IFileManagerDBWrapper: every file gets a random
+// data key, the wrapped backend only sees the sealed chunks and the data key is stored sealed by the
+// active master key in DataKeys. Files stored before encryption was enabled have no data key and are
+// returned as they are.
+
+type EncryptedFileManager struct {
+	Files     interfaces.IFileManagerDBWrapper
+	DataKeys  interfaces.IDataKeyDBWrapper
+	Keys      interfaces.IKeyProvider
+	ChunkSize int // plaintext bytes per sealed chunk, DefaultChunkSize when 0
+
+	tenant string
+}
+
+// ForTenant, view of the tenant's files, data keys are identified by tenant and file id
+
+func (efm *EncryptedFileManager) ForTenant(tenant string) interfaces.IFileManagerDBWrapper {
+	scoped := *efm
+	scoped.Files = efm.Files.ForTenant(tenant)
+	scoped.tenant = tenant
+	return &scoped
+}
+
+func (efm *EncryptedFileManager) UploadFile(ctx context.Context, fileID string, fileData io.Reader, size int64, filename string) (int, error) {
+	chunkSize := efm.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	plainKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(plainKey); err != nil {
+		return 0, err
+	}
+	sealer, err := NewSealer(plainKey, fileID, fileData, size, chunkSize)
+	if err != nil {
+		return 0, err
+	}
+	masterKeyId, wrappedKey, err := efm.Keys.WrapKey(plainKey)
+	if err != nil {
+		return 0, fmt.Errorf("sealing the data key failed: %w", err)
+	}
+
+	// The data key goes first, bytes stored without their key could never be read again
+	if err := efm.DataKeys.InsertDataKey(&models.DataKey{
+		Id:          models.DataKeyId(efm.tenant, fileID),
+		Tenant:      models.NormalizeTenant(efm.tenant),
+		FileId:      fileID,
+		MasterKeyId: masterKeyId,
+		WrappedKey:  wrappedKey,
+		Algorithm:   models.DataKeyAlgorithmAES256GCMChunked,
+		ChunkSize:   chunkSize,
+		Size:        size,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}); err != nil {
+		return 0, err
+	}
+
+	if _, err := efm.Files.UploadFile(ctx, fileID, sealer, SealedSize(size, chunkSize), filename); err != nil {
+		if deleteErr := efm.DataKeys.DeleteDataKey(efm.tenant, fileID); deleteErr != nil {
+			logger.FromContext(ctx).Error(fmt.Sprintf("Deleting data key of failed upload failed!! fileId: %s, Error: %s", fileID, deleteErr.Error()))
+		}
+		return 0, err
+	}
+	return int(size), nil
+}
+
+func (efm *EncryptedFileManager) DownloadFile(ctx context.Context, fileID string, filename string) ([]byte, error) {
+	dataKey, err := efm.DataKeys.GetDataKey(efm.tenant, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	fileDataBytes, err := efm.Files.DownloadFile(ctx, fileID, filename)
+	if err != nil || dataKey == nil {
+		return fileDataBytes, err
+	}
+
+	plainKey, err := efm.Keys.UnwrapKey(dataKey.MasterKeyId, dataKey.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	_, span := tracing.Start(ctx, "EncryptedFileManager.Open", attribute.Int64("file.size", dataKey.Size))
+	fileDataBytes, err = Open(plainKey, fileID, fileDataBytes, dataKey.ChunkSize)
+	tracing.End(span, err)
+	return fileDataBytes, err
+}
+
+// DownloadFileRange, reading and decrypting only the sealed chunks covering the plaintext range, the
+// range is cut out of the first and last chunk afterwards
+
+func (efm *EncryptedFileManager) DownloadFileRange(ctx context.Context, fileID string, filename string, offset int64, length int64) ([]byte, error) {
+	dataKey, err := efm.DataKeys.GetDataKey(efm.tenant, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if dataKey == nil {
+		return efm.Files.DownloadFileRange(ctx, fileID, filename, offset, length)
+	}
+	if offset >= dataKey.Size || length <= 0 {
+		return []byte{}, nil
+	}
+	if offset+length > dataKey.Size {
+		length = dataKey.Size - offset
+	}
+
+	start, end, firstChunk := SealedRange(offset, length, dataKey.Size, dataKey.ChunkSize)
+	sealed, err := efm.Files.DownloadFileRange(ctx, fileID, filename, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+
+	plainKey, err := efm.Keys.UnwrapKey(dataKey.MasterKeyId, dataKey.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	_, span := tracing.Start(ctx, "EncryptedFileManager.OpenRange",
+		attribute.Int64("range.offset", offset), attribute.Int64("range.length", length))
+	plaintext, err := OpenRange(plainKey, fileID, sealed, firstChunk, chunkCount(dataKey.Size, dataKey.ChunkSize), dataKey.ChunkSize)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := offset - firstChunk*int64(dataKey.ChunkSize)
+	if skip+length > int64(len(plaintext)) {
+		return nil, fmt.Errorf("%w: range %d-%d beyond the stored chunks", ErrDecryptionFailed, offset, offset+length-1)
+	}
+	return plaintext[skip : skip+length], nil
+}
+
+// DeleteFileByFileId, deleting the bytes and then the data key, which leaves any copy of the bytes
+// (e.g. in a backup) unreadable
+
+func (efm *EncryptedFileManager) DeleteFileByFileId(ctx context.Context, fileID string) error {
+	if err := efm.Files.DeleteFileByFileId(ctx, fileID); err != nil {
+		return err
+	}
+	return efm.DataKeys.DeleteDataKey(efm.tenant, fileID)
+}
+
+// RotateDataKeys, re-wrapping every data key not sealed by the active master key with it, the file bytes
+// are not touched. Keys that fail to re-wrap are logged and skipped, rotation can be run again.
+func RotateDataKeys(dataKeys interfaces.IDataKeyDBWrapper, keys interfaces.IKeyProvider, batchSize int64) (rotated int, failed int, err error) {
+	activeKeyId := keys.ActiveKeyId()
+	afterId := ""
+	for {
+		batch, err := dataKeys.GetDataKeysNotWrappedWith(activeKeyId, afterId, batchSize)
+		if err != nil {
+			return rotated, failed, err
+		}
+		if len(batch) == 0 {
+			return rotated, failed, nil
+		}
+
+		for _, dataKey := range batch {
+			afterId = dataKey.Id
+			if err := rewrap(dataKeys, keys, dataKey); err != nil {
+				logger.Logger.Error(fmt.Sprintf("Data key rotation failed!! id: %s, master key: %s, Error: %s",
+					dataKey.Id, dataKey.MasterKeyId, err.Error()))
+				failed++
+				continue
+			}
+			rotated++
+		}
+	}
+}
+
+func rewrap(dataKeys interfaces.IDataKeyDBWrapper, keys interfaces.IKeyProvider, dataKey *models.DataKey) error {
+	plainKey, err := keys.UnwrapKey(dataKey.MasterKeyId, dataKey.WrappedKey)
+	if err != nil {
+		return err
+	}
+	masterKeyId, wrappedKey, err := keys.WrapKey(plainKey)
+	if err != nil {
+		return err
+	}
+	updated, err := dataKeys.UpdateWrappedKey(dataKey.Id, dataKey.MasterKeyId, masterKeyId, wrappedKey)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("data key changed during rotation")
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package encryption
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key providers of encryption.provider
+const (
+	ProviderStatic   = "static"   // master keys from the ENCRYPTION_MASTER_KEYS env
+	ProviderLocalKMS = "localkms" // master keys in a keyring file, stand-in for a KMS
+)
+
+var ErrUnknownMasterKey = errors.New("unknown master key")
+
+// keyringCheckInterval, how often LocalKMS looks for changes of the keyring file
+const keyringCheckInterval = time.Second
+
+// Keyring, named 256-bit master keys, the Active one seals new data keys. A data key is sealed with
+// AES-256-GCM under the master key as random nonce followed by the ciphertext, the master key id is
+// the additional data.
+
+type Keyring struct {
+	Active string            `json:"active"`
+	Keys   map[string][]byte `json:"keys"` // base64 in the keyring file
+}
+
+func (kr *Keyring) validate() error {
+	if kr.Active == "" {
+		return errors.New("no active master key")
+	}
+	if _, found := kr.Keys[kr.Active]; !found {
+		return fmt.Errorf("%w: active key %q", ErrUnknownMasterKey, kr.Active)
+	}
+	for keyId, key := range kr.Keys {
+		if len(key) != dataKeySize {
+			return fmt.Errorf("master key %q must be %d bytes, got %d", keyId, dataKeySize, len(key))
+		}
+	}
+	return nil
+}
+
+func (kr *Keyring) wrap(dataKey []byte) (string, []byte, error) {
+	aead, err := newAEAD(kr.Keys[kr.Active])
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return kr.Active, aead.Seal(nonce, nonce, dataKey, []byte(kr.Active)), nil
+}
+
+func (kr *Keyring) unwrap(masterKeyId string, wrappedKey []byte) ([]byte, error) {
+	masterKey, found := kr.Keys[masterKeyId]
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMasterKey, masterKeyId)
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: wrapped key too short", ErrDecryptionFailed)
+	}
+	dataKey, err := aead.Open(nil, wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():], []byte(masterKeyId))
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key: %s", ErrDecryptionFailed, err.Error())
+	}
+	return dataKey, nil
+}
+
+// GenerateMasterKey, a new random master key, its id carries the creation time
+func GenerateMasterKey() (string, []byte, error) {
+	suffix := make([]byte, 4)
+	masterKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(masterKey); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("mk-%s-%s", time.Now().UTC().Format("20060102"), hex.EncodeToString(suffix)), masterKey, nil
+}
+
+// StaticKeyProvider, master keys given in the config as "<id>:<base64 key>,<id>:<base64 key>", old keys
+// have to stay listed until their data keys are rotated
+
+type StaticKeyProvider struct {
+	keyring Keyring
+}
+
+func NewStaticKeyProvider(masterKeys string, activeKeyId string) (*StaticKeyProvider, error) {
+	keyring := Keyring{Active: activeKeyId, Keys: map[string][]byte{}}
+	for _, entry := range strings.Split(masterKeys, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		keyId, encodedKey, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("master key %q must be <id>:<base64 key>", keyId)
+		}
+		masterKey, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q isn't base64: %s", keyId, err.Error())
+		}
+		keyring.Keys[keyId] = masterKey
+	}
+	if err := keyring.validate(); err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{keyring: keyring}, nil
+}
+
+func (kp *StaticKeyProvider) ActiveKeyId() string {
+	return kp.keyring.Active
+}
+
+func (kp *StaticKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	return kp.keyring.wrap(dataKey)
+}
+
+func (kp *StaticKeyProvider) UnwrapKey(masterKeyId string, wrappedKey []byte) ([]byte, error) {
+	return kp.keyring.unwrap(masterKeyId, wrappedKey)
+}
+
+// LocalKMS, master keys kept in a JSON keyring file, a stand-in for a KMS in development and single host
+// deployments. The file is read again once it changed, and when a data key is sealed by a key it doesn't
+// know yet, so running instances seal new data keys with a key created after they started and can open
+// data keys re-wrapped under it.
+
+type LocalKMS struct {
+	Path string
+
+	mu        sync.RWMutex
+	keyring   Keyring
+	loaded    os.FileInfo // keyring file as it was when read
+	checkedAt time.Time
+}
+
+// OpenLocalKMS, the keyring at path, created with a first master key when it doesn't exist
+func OpenLocalKMS(path string) (*LocalKMS, error) {
+	kms := &LocalKMS{Path: path}
+	err := kms.load()
+	if errors.Is(err, os.ErrNotExist) {
+		_, err = kms.CreateKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kms, nil
+}
+
+func (kms *LocalKMS) load() error {
+	info, err := os.Stat(kms.Path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(kms.Path)
+	if err != nil {
+		return err
+	}
+	keyring := Keyring{}
+	if err := json.Unmarshal(content, &keyring); err != nil {
+		return fmt.Errorf("keyring %s: %s", kms.Path, err.Error())
+	}
+	if err := keyring.validate(); err != nil {
+		return fmt.Errorf("keyring %s: %s", kms.Path, err.Error())
+	}
+
+	kms.mu.Lock()
+	kms.keyring = keyring
+	kms.loaded = info
+	kms.mu.Unlock()
+	return nil
+}
+
+// refresh, reading the keyring file again when it changed since it was read, at most once per
+// keyringCheckInterval. A keyring that can't be read is logged and the loaded one is kept.
+func (kms *LocalKMS) refresh() {
+	kms.mu.Lock()
+	if time.Since(kms.checkedAt) < keyringCheckInterval {
+		kms.mu.Unlock()
+		return
+	}
+	kms.checkedAt = time.Now()
+	loaded := kms.loaded
+	kms.mu.Unlock()
+
+	info, err := os.Stat(kms.Path)
+	if err == nil && loaded != nil && info.ModTime().Equal(loaded.ModTime()) && info.Size() == loaded.Size() {
+		return
+	}
+	if err == nil {
+		err = kms.load()
+	}
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Reading keyring failed, keeping the loaded one!! path: %s, Error: %s", kms.Path, err.Error()))
+	}
+}
+
+// CreateKey, adding a new master key to the keyring file and making it the active one
+func (kms *LocalKMS) CreateKey() (string, error) {
+	keyId, masterKey, err := GenerateMasterKey()
+	if err != nil {
+		return "", err
+	}
+
+	kms.mu.Lock()
+	defer kms.mu.Unlock()
+	keyring := Keyring{Active: keyId, Keys: map[string][]byte{keyId: masterKey}}
+	for existingId, existingKey := range kms.keyring.Keys {
+		keyring.Keys[existingId] = existingKey
+	}
+	content, err := json.MarshalIndent(&keyring, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// written next to the keyring and renamed, so a crash never leaves a truncated keyring behind
+	if err := os.MkdirAll(filepath.Dir(kms.Path), 0700); err != nil {
+		return "", err
+	}
+	tempPath := kms.Path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tempPath, kms.Path); err != nil {
+		return "", err
+	}
+	kms.keyring = keyring
+	if info, err := os.Stat(kms.Path); err == nil {
+		kms.loaded = info
+	}
+	return keyId, nil
+}
+
+func (kms *LocalKMS) ActiveKeyId() string {
+	kms.refresh()
+	kms.mu.RLock()
+	defer kms.mu.RUnlock()
+	return kms.keyring.Active
+}
+
+func (kms *LocalKMS) WrapKey(dataKey []byte) (string, []byte, error) {
+	kms.refresh()
+	kms.mu.RLock()
+	defer kms.mu.RUnlock()
+	return kms.keyring.wrap(dataKey)
+}
+
+func (kms *LocalKMS) UnwrapKey(masterKeyId string, wrappedKey []byte) ([]byte, error) {
+	kms.mu.RLock()
+	dataKey, err := kms.keyring.unwrap(masterKeyId, wrappedKey)
+	kms.mu.RUnlock()
+	if !errors.Is(err, ErrUnknownMasterKey) {
+		return dataKey, err
+	}
+
+	if err := kms.load(); err != nil {
+		return nil, err
+	}
+	kms.mu.RLock()
+	defer kms.mu.RUnlock()
+	return kms.keyring.unwrap(masterKeyId, wrappedKey)
+}
+
+// NewKeyProvider, the key provider named by encryption.provider
+func NewKeyProvider(provider string, masterKeys string, activeKeyId string, keyringFile string) (interfaces.IKeyProvider, error) {
+	switch provider {
+	case ProviderStatic:
+		return NewStaticKeyProvider(masterKeys, activeKeyId)
+	case ProviderLocalKMS:
+		return OpenLocalKMS(keyringFile)
+	default:
+		return nil, fmt.Errorf("unknown encryption provider %q, expected %q or %q", provider, ProviderStatic, ProviderLocalKMS)
+	}
+}
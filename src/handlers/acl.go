@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/middlewares"
+	"city_os/src/models"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+func (h *Handler) GetFileACLHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	fileId, found := c.Params.Get("fileid")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "fileID is a mandatory path param"})
+		return
+	}
+
+	fileACL, err := h.VideoCatalogueManager.GetFileACL(c.Request.Context(), fileId, middlewares.GetPrincipal(c))
+	if err != nil {
+		respondACLError(c, fileId, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileACL)
+}
+
+func (h *Handler) UpdateFileACLHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	fileId, found := c.Params.Get("fileid")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "fileID is a mandatory path param"})
+		return
+	}
+	request := models.FileACLRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing acl request failed", "error": err.Error()})
+		return
+	}
+
+	fileACL, err := h.VideoCatalogueManager.UpdateFileACL(c.Request.Context(), fileId, &request, middlewares.GetPrincipal(c))
+	if err != nil {
+		respondACLError(c, fileId, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileACL)
+}
+
+func respondACLError(c *gin.Context, fileId string, err error) {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidACL):
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid acl", "error": err.Error()})
+	case errors.Is(err, controllers.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"message": "Access denied", "error": err.Error()})
+	case strings.Contains(err.Error(), "no document") || strings.Contains(err.Error(), "ObjectID"):
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("File not found!! fileID:%s", fileId))
+		c.JSON(http.StatusNotFound, gin.H{"message": "File not found", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Processing acl failed", "error": err.Error()})
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/models"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func (h *Handler) CreateAPIKeyHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	request := models.APIKeyRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing api key request failed", "error": err.Error()})
+		return
+	}
+
+	apiKey, plaintextKey, err := h.APIKeyManager.MintKey(tenantOf(c), request.Name, request.Scopes)
+	if err != nil {
+		if errors.Is(err, controllers.ErrInvalidAPIKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid api key request", "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Minting api key failed", "error": err.Error()})
+		return
+	}
+	// The only time the key is revealed
+	c.JSON(http.StatusCreated, gin.H{"apiKey": apiKey, "key": plaintextKey})
+}
+
+func (h *Handler) GetAPIKeysListHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	apiKeys, err := h.APIKeyManager.GetKeysList(tenantOf(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Fetching api keys failed", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, apiKeys)
+}
+
+func (h *Handler) RevokeAPIKeyHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	revoked, err := h.APIKeyManager.RevokeKey(tenantOf(c), c.Param("id"))
+	if err != nil || !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Active api key not found"})
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{})
+}
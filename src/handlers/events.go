@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventsDefaultLimit = 100
+	eventsMaxLimit     = 1000
+	eventsMaxWait      = 60 * time.Second
+	sseKeepAlive       = 15 * time.Second
+)
+
+// GetEventsHandler, change feed of catalogue events after the `since` cursor (the seq of the last
+// consumed event). Answers as long-poll JSON, or as a Server-Sent Events stream when the client
+// accepts text/event-stream, in which case Last-Event-ID takes precedence over `since` on reconnect.
+
+func (h *Handler) GetEventsHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil || since < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "since must be a non-negative event sequence number"})
+		return
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(eventsDefaultLimit)), 10, 64)
+	if err != nil || limit <= 0 || limit > eventsMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("limit must be between 1 and %d", eventsMaxLimit)})
+		return
+	}
+	waitSeconds, err := strconv.Atoi(c.DefaultQuery("wait", "0"))
+	if err != nil || waitSeconds < 0 || time.Duration(waitSeconds)*time.Second > eventsMaxWait {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("wait must be between 0 and %d seconds", int(eventsMaxWait.Seconds()))})
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		if lastEventId := c.GetHeader("Last-Event-ID"); lastEventId != "" {
+			if since, err = strconv.ParseInt(lastEventId, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Last-Event-ID must be an event sequence number"})
+				return
+			}
+		}
+		h.streamEvents(c, since, limit)
+		return
+	}
+
+	// a long-poll returns what it has once the server drains
+	ctx, cancel := h.Drain.Context(c.Request.Context())
+	defer cancel()
+	events, err := h.EventManager.GetEvents(tenantOf(c), since, limit, time.Duration(waitSeconds)*time.Second, ctx.Done())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Fetching events failed", "error": err.Error()})
+		return
+	}
+	next := since
+	if len(events) > 0 {
+		next = events[len(events)-1].Seq
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "next": next})
+}
+
+func (h *Handler) streamEvents(c *gin.Context, since int64, limit int64) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	// the stream ends when the server drains, clients reconnect with Last-Event-ID to another instance
+	ctx, cancel := h.Drain.Context(c.Request.Context())
+	defer cancel()
+	done := ctx.Done()
+	for {
+		events, err := h.EventManager.GetEvents(tenantOf(c), since, limit, sseKeepAlive, done)
+		if err != nil {
+			fmt.Fprintf(c.Writer, "event: error\ndata: %q\n\n", err.Error())
+			c.Writer.Flush()
+			return
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if len(events) == 0 {
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		}
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Event marshalling failed!! seq: %d, Error: %s", event.Seq, err.Error()))
+				return
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+			since = event.Seq
+		}
+		c.Writer.Flush()
+	}
+}
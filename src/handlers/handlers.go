@@ -4,40 +4,121 @@ import (
 	"bytes"
 	"city_os/cmd/app/configs"
 	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/health"
 	"city_os/src/interfaces"
+	"city_os/src/metrics"
+	"city_os/src/middlewares"
+	"city_os/src/models"
+	"city_os/src/progress"
+	"city_os/src/shutdown"
+	"city_os/src/tracing"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
-	"os"
+	"strconv"
 )
 
 type Handler struct {
 	VideoCatalogueManager interfaces.IVideoCatalogueManager
-	Config                *configs.AppConfig
+	UploadProgress        *progress.Registry
+	WebhookManager        interfaces.IWebhookManager
+	EventManager          interfaces.IEventManager
+	APIKeyManager         interfaces.IAPIKeyManager
+	ShareManager          interfaces.IShareManager
+	Config                func() *configs.AppConfig // Config in effect, reloadable settings change between calls
+	Metrics               *metrics.Metrics          // Optional, transferred bytes, duplicates and uploads in flight
+	Drain                 *shutdown.Drain           // Optional, set when the server shuts down
+	Health                *health.Checker           // Readiness checks of the dependencies
+}
+
+// tenantOf, tenant of the request's caller, the default tenant when auth is disabled
+
+func tenantOf(c *gin.Context) string {
+	return models.TenantOf(middlewares.GetPrincipal(c))
 }
 
 func (h *Handler) HealthCheck(c *gin.Context) {
+	// failing while draining takes the instance out of the load balancer before it stops listening
+	if h.Drain.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{})
 }
 
+// LivenessHandler, the process is up and serving, dependencies are not checked so an outage of Mongo
+// doesn't get every instance restarted
+
+func (h *Handler) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// ReadinessHandler, status and latency of every dependency, 503 when any of them is down or the
+// server is draining
+
+func (h *Handler) ReadinessHandler(c *gin.Context) {
+	if h.Drain.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": health.StatusDraining})
+		return
+	}
+
+	report := h.Health.Run(c.Request.Context())
+	status := http.StatusOK
+	for name, result := range report.Checks {
+		if result.Status != health.StatusUp {
+			logger.FromContext(c.Request.Context()).Warn(fmt.Sprintf("Readiness check failed!! check: %s, Error: %s", name, result.Error))
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, report)
+}
+
 func (h *Handler) GetFileByIdHandler(c *gin.Context) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Logger.Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
 		}
 	}()
 	fileid, found := c.Params.Get("fileid")
 	if !found {
-		logger.Logger.Info(fmt.Sprintf("Bad Request!!"))
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Bad Request!!"))
 		c.JSON(http.StatusBadRequest, gin.H{"message": "fileID is a mandatory path param!!"})
 		return
 	}
 
-	fileData, err := h.VideoCatalogueManager.GetFileByFileId(fileid)
+	// A range only applies to the file version the client has, which isn't known with If-Range
+	var byteRange *models.ByteRange
+	if c.GetHeader("If-Range") == "" {
+		byteRange = models.ParseByteRange(c.GetHeader("Range"))
+	}
+
+	fileData, err := h.VideoCatalogueManager.GetFileByFileId(c.Request.Context(), fileid, byteRange, middlewares.GetPrincipal(c))
+	var rangeErr *controllers.RangeNotSatisfiableError
+	if errors.As(err, &rangeErr) {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", rangeErr.Size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"message": "Range not satisfiable", "error": err.Error()})
+		return
+	}
+	if errors.Is(err, controllers.ErrAccessDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Access denied", "error": err.Error()})
+		return
+	}
+	if errors.Is(err, controllers.ErrFileQuarantined) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "File is quarantined", "error": err.Error()})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Download timed out!! fileID:%s", fileid))
+		c.JSON(http.StatusGatewayTimeout, gin.H{"message": "Storage timed out", "error": err.Error()})
+		return
+	}
 	if err != nil {
-		logger.Logger.Info(fmt.Sprintf("File not found!! fileID:%s", fileid))
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("File not found!! fileID:%s", fileid))
 		c.JSON(http.StatusNotFound, gin.H{"message": "File not found!!", "error": err.Error()})
 		return
 	}
@@ -45,7 +126,16 @@ func (h *Handler) GetFileByIdHandler(c *gin.Context) {
 	responseWriter := c.Writer
 	responseWriter.Header().Set("Content-Type", fileData.FileMimeType)
 	responseWriter.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileData.Name))
-	if _, err = io.Copy(responseWriter, bytes.NewReader(fileData.FileDataBytes)); err != nil {
+	responseWriter.Header().Set("Accept-Ranges", "bytes")
+	responseWriter.Header().Set("Content-Length", strconv.Itoa(len(fileData.FileDataBytes)))
+	if byteRange != nil {
+		responseWriter.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d",
+			fileData.Offset, fileData.Offset+int64(len(fileData.FileDataBytes))-1, fileData.TotalSize))
+		responseWriter.WriteHeader(http.StatusPartialContent)
+	}
+	written, err := io.Copy(responseWriter, bytes.NewReader(fileData.FileDataBytes))
+	h.Metrics.AddDownloadedBytes(int(written))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "IO write into Response failed", "error": err.Error()})
 	}
 }
@@ -53,21 +143,25 @@ func (h *Handler) GetFileByIdHandler(c *gin.Context) {
 func (h *Handler) LocateFileByIdHandler(c *gin.Context) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Logger.Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
 		}
 	}()
 
 	fileid, found := c.Params.Get("fileid")
 	if !found {
-		logger.Logger.Info(fmt.Sprintf("Bad Request!!"))
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Bad Request!!"))
 		c.JSON(http.StatusBadRequest, gin.H{"message": "fileID is a mandatory path param!!"})
 		return
 	}
 
-	fileData, err := h.VideoCatalogueManager.GetFilesDataById(fileid)
+	fileData, err := h.VideoCatalogueManager.GetFilesDataById(c.Request.Context(), fileid, middlewares.GetPrincipal(c))
+	if errors.Is(err, controllers.ErrAccessDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Access denied", "error": err.Error()})
+		return
+	}
 	if err != nil {
-		logger.Logger.Info(fmt.Sprintf("File not found!! fileID:%s", fileid))
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("File not found!! fileID:%s", fileid))
 		c.JSON(http.StatusNotFound, gin.H{"message": "File not found!!", "error": err.Error()})
 		return
 	}
@@ -78,7 +172,7 @@ func (h *Handler) LocateFileByIdHandler(c *gin.Context) {
 func (h *Handler) DeleteFileByIdHandler(c *gin.Context) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Logger.Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
 		}
 	}()
@@ -88,7 +182,11 @@ func (h *Handler) DeleteFileByIdHandler(c *gin.Context) {
 		return
 	}
 
-	_, err := h.VideoCatalogueManager.DeleteVideoFile(fileId)
+	_, err := h.VideoCatalogueManager.DeleteVideoFile(c.Request.Context(), fileId, middlewares.GetPrincipal(c))
+	if errors.Is(err, controllers.ErrAccessDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Access denied", "error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": "File not found", "error": err.Error()})
 		return
@@ -97,77 +195,202 @@ func (h *Handler) DeleteFileByIdHandler(c *gin.Context) {
 	c.JSON(http.StatusNoContent, gin.H{})
 }
 
+// PostSingleFileHandler, storing an uploaded Video file. Clients which want to follow the upload pass an
+// id in X-Upload-ID header (or upload_id query param) and stream GET /v1/uploads/:id/progress.
+
 func (h *Handler) PostSingleFileHandler(c *gin.Context) {
+	var uploadProgress *progress.Tracker
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Logger.Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			uploadProgress.Fail(http.StatusInternalServerError, "Unknown error occurred")
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
 		}
 	}()
-	//Supported media types
-	supportedMediaTypes := []string{`video/mp4`, `video/mpeg`}
-	file, header, err := c.Request.FormFile("data")
-	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("Parsing form-data failed!! Error: %s", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing form-data failed", "error": err.Error()})
-		return
-	}
-	contentType := header.Header.Get("Content-Type")
-	isSupportedMediaType := false
-	for _, mType := range supportedMediaTypes {
-		if mType == contentType {
-			isSupportedMediaType = true
-			break
+	defer h.Metrics.UploadStarted()()
+
+	if uploadId := c.GetHeader("X-Upload-ID"); uploadId != "" || c.Query("upload_id") != "" {
+		if uploadId == "" {
+			uploadId = c.Query("upload_id")
+		}
+		tracker, err := h.UploadProgress.Start(uploadId, c.Request.ContentLength)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid upload id", "error": err.Error()})
+			return
 		}
+		uploadProgress = tracker
+		c.Request.Body = uploadProgress.WrapBody(c.Request.Body)
 	}
 
-	if !isSupportedMediaType {
-		c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "media type not supported"})
+	// Limits and media types of this upload, a reload in between doesn't change them halfway
+	config := h.Config()
+
+	// The body includes the file, a shorter one can't carry a file of the minimum size
+	if c.Request.ContentLength >= 0 && c.Request.ContentLength < config.BodyLimits.MinFileBytes {
+		message := fmt.Sprintf("Files must have at least %d bytes", config.BodyLimits.MinFileBytes)
+		uploadProgress.Fail(http.StatusBadRequest, message)
+		c.JSON(http.StatusBadRequest, gin.H{"message": message})
 		return
 	}
 
-	buf := bytes.NewBuffer(nil)
-	if _, err = io.Copy(buf, file); err != nil {
-		logger.Logger.Error(fmt.Printf("Video file byte conversion failed!! Error: %v", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Video file parsing failed.", "error": err.Error()})
+	// Hashing happens while the upload is read, the span covers both
+	_, readSpan := tracing.Start(c.Request.Context(), "readUploadedFile")
+	upload, err := h.readUploadedFile(c, config.Uploads.MediaTypes, &config.BodyLimits)
+	tracing.End(readSpan, err)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr) || errors.Is(err, errFileTooLarge):
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Upload too large!! Error: %s", err.Error()))
+		uploadProgress.Fail(http.StatusRequestEntityTooLarge, "File too large")
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "File too large", "error": err.Error()})
+		return
+	case errors.Is(err, errUnsupportedMediaType):
+		uploadProgress.Fail(http.StatusUnsupportedMediaType, "media type not supported")
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "media type not supported"})
+		return
+	case errors.Is(err, errFileTooSmall):
+		uploadProgress.Fail(http.StatusBadRequest, "File too small")
+		c.JSON(http.StatusBadRequest, gin.H{"message": "File too small", "error": err.Error()})
+		return
+	case err != nil:
+		logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Parsing form-data failed!! Error: %s", err.Error()))
+		uploadProgress.Fail(http.StatusBadRequest, "Parsing form-data failed")
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing form-data failed", "error": err.Error()})
 		return
 	}
 
-	docId, hash, err := h.VideoCatalogueManager.GetVideoDocIdBySHAHash(buf.Bytes())
+	defer upload.remove()
+
+	uploadProgress.SetStage(progress.StageDuplicateCheck)
+	docId, err := h.VideoCatalogueManager.GetVideoDocIdByHash(c.Request.Context(), upload.hash, middlewares.GetPrincipal(c))
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("Fetchnig doc by hash failed!! Error: %v", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Hash conversion failed!!", "error": err.Error()})
+		logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Fetchnig doc by hash failed!! Error: %v", err.Error()))
+		uploadProgress.Fail(http.StatusInternalServerError, "Duplicate check failed!!")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Duplicate check failed!!", "error": err.Error()})
 		return
 	}
 
 	if docId != "" {
-		logger.Logger.Info(fmt.Printf("Duplicate doc found!! docId : %s", docId))
-		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("File exists!! docId : %s", docId)})
+		h.rejectDuplicate(c, uploadProgress, config, docId, upload.hash)
 		return
 	}
 
-	fileDocId, err := h.VideoCatalogueManager.SaveVideoFile(buf.Bytes(), header.Filename, contentType, hash)
+	uploadProgress.SetStage(progress.StageStoring)
+	fileDocId, err := h.VideoCatalogueManager.SaveVideoFile(c.Request.Context(), upload.file, upload.size, upload.name, upload.contentType, upload.hash, middlewares.GetPrincipal(c))
+	var duplicate *controllers.DuplicateFileError
+	if errors.As(err, &duplicate) {
+		h.rejectDuplicate(c, uploadProgress, config, duplicate.FileId, upload.hash)
+		return
+	}
+	if errors.Is(err, controllers.ErrFileExceedsQuota) || errors.Is(err, controllers.ErrQuotaExceeded) {
+		status := http.StatusInsufficientStorage
+		if errors.Is(err, controllers.ErrFileExceedsQuota) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Upload rejected!! %s", err.Error()))
+		uploadProgress.Fail(status, "Storage quota exceeded")
+		c.JSON(status, gin.H{"message": "Storage quota exceeded", "error": err.Error()})
+		return
+	}
+	if errors.Is(err, controllers.ErrFileQuarantined) {
+		uploadProgress.Fail(http.StatusUnprocessableEntity, "Malware found, file quarantined")
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"message": "Malware found, file quarantined", "fileid": fileDocId, "error": err.Error()})
+		return
+	}
+	if errors.Is(err, controllers.ErrScanUnavailable) {
+		uploadProgress.Fail(http.StatusServiceUnavailable, "Malware scan unavailable")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Malware scan unavailable, retry later", "error": err.Error()})
+		return
+	}
+	// Nothing of the file is kept, the client can retry
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Saving video file timed out!! Error: %v", err.Error()))
+		uploadProgress.Fail(http.StatusGatewayTimeout, "Storage timed out")
+		c.JSON(http.StatusGatewayTimeout, gin.H{"message": "Storage timed out", "error": err.Error()})
+		return
+	}
 	if err != nil {
-		logger.Logger.Error(fmt.Printf("Video file byte conversion failed!! Error: %v", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Video file parsing failed.", "error": err.Error()})
+		logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Saving video file failed!! Error: %v", err.Error()))
+		uploadProgress.Fail(http.StatusInternalServerError, "Video file saving failed.")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Video file saving failed.", "error": err.Error()})
 		return
 	}
-	host := os.Getenv("HOST")
-	port := os.Getenv("PORT")
-	c.Redirect(http.StatusCreated, fmt.Sprintf("http://%s:%s/v1/files/locate/%s", host, port, fileDocId))
+	h.Metrics.AddUploadedBytes(int(upload.size))
+	uploadProgress.Done(http.StatusCreated, fileDocId)
+
+	// The created file as GET /v1/files/locate/:fileid returns it, the upload stands when reading it back fails
+	location := absoluteURL(c, config, "/v1/files/locate/"+fileDocId)
+	c.Header("Location", location)
+	fileData, err := h.VideoCatalogueManager.GetFilesDataById(c.Request.Context(), fileDocId, middlewares.GetPrincipal(c))
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Reading back uploaded file failed!! fileID: %s, Error: %s", fileDocId, err.Error()))
+		c.JSON(http.StatusCreated, gin.H{"fileid": fileDocId, "location": location})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"fileid": fileDocId, "location": location, "fileData": fileData})
+}
+
+// rejectDuplicate, 409 of an upload whose content is stored already as docId
+
+func (h *Handler) rejectDuplicate(c *gin.Context, uploadProgress *progress.Tracker, config *configs.AppConfig, docId string, hash string) {
+	logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Duplicate doc found!! docId : %s", docId))
+	h.Metrics.IncDuplicateRejections()
+	h.VideoCatalogueManager.RejectDuplicateUpload(c.Request.Context(), docId, hash, middlewares.GetPrincipal(c))
+	response := gin.H{
+		"message": "File exists",
+		"error":   "a file with the same content was uploaded before",
+	}
+	// The stored file is only pointed out to callers who may read it
+	if _, err := h.VideoCatalogueManager.GetFilesDataById(c.Request.Context(), docId, middlewares.GetPrincipal(c)); err == nil {
+		response["fileid"] = docId
+		response["location"] = absoluteURL(c, config, "/v1/files/locate/"+docId)
+		uploadProgress.Fail(http.StatusConflict, fmt.Sprintf("File exists!! docId : %s", docId))
+	} else {
+		uploadProgress.Fail(http.StatusConflict, "File exists!!")
+	}
+	c.JSON(http.StatusConflict, response)
 }
 
 func (h *Handler) GetFilesListHandler(c *gin.Context) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Logger.Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
 		}
 	}()
-	videosList, err := h.VideoCatalogueManager.GetVideoFilesList()
+	collection := c.Query("collection")
+	if collection != "" {
+		if err := models.ValidateCollection(collection); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid collection", "error": err.Error()})
+			return
+		}
+	}
+	videosList, err := h.VideoCatalogueManager.GetVideoFilesList(c.Request.Context(), collection, middlewares.GetPrincipal(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Fetching videos list failed", "error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, videosList)
 }
+
+func (h *Handler) GetJobByIdHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	jobId, found := c.Params.Get("id")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "job id is a mandatory path param"})
+		return
+	}
+
+	job, err := h.VideoCatalogueManager.GetJobById(c.Request.Context(), jobId, middlewares.GetPrincipal(c))
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Job not found!! jobId:%s", jobId))
+		c.JSON(http.StatusNotFound, gin.H{"message": "Job not found", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
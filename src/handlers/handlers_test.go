@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"city_os/cmd/app/configs"
+	"city_os/src/controllers"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"city_os/src/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+)
+
+// testConfig, config of the handler tests accepting the default media types
+func testConfig(limits *models.BodyLimits) func() *configs.AppConfig {
+	config := &configs.AppConfig{}
+	config.Uploads.MediaTypes = []string{"video/mp4", "video/mpeg"}
+	if limits != nil {
+		config.BodyLimits = *limits
+	}
+	return func() *configs.AppConfig { return config }
+}
+
+// uploadManager, catalogue manager of the upload tests, only the upload path is implemented
+type uploadManager struct {
+	interfaces.IVideoCatalogueManager
+	existingId string
+	saveErr    error
+	unreadable bool   // GetFilesDataById fails like for a file the caller can't read
+	rejected   string // fileId of the last RejectDuplicateUpload call
+
+	saved     []byte // bytes of the last SaveVideoFile call
+	savedSize int64
+	savedHash string
+	savedFrom string // name of the temporary file it read them from
+}
+
+func (m *uploadManager) GetVideoDocIdByHash(context.Context, string, *models.Principal) (string, error) {
+	return m.existingId, nil
+}
+
+func (m *uploadManager) SaveVideoFile(_ context.Context, fileData io.ReadSeeker, size int64, _ string, _ string, hash string, _ *models.Principal) (string, error) {
+	if file, isFile := fileData.(*os.File); isFile {
+		m.savedFrom = file.Name()
+	}
+	saved, err := io.ReadAll(fileData)
+	if err != nil {
+		return "", err
+	}
+	m.saved, m.savedSize, m.savedHash = saved, size, hash
+	if m.saveErr != nil {
+		return "", m.saveErr
+	}
+	return "stored", nil
+}
+
+func (m *uploadManager) RejectDuplicateUpload(_ context.Context, fileId string, _ string, _ *models.Principal) {
+	m.rejected = fileId
+}
+
+func (m *uploadManager) GetFilesDataById(_ context.Context, fileId string, _ *models.Principal) (*models.VideoCatalogueData, error) {
+	if m.unreadable {
+		return nil, errors.New("mongo: no documents in result")
+	}
+	return &models.VideoCatalogueData{FileId: fileId}, nil
+}
+
+func uploadRequest(t *testing.T) *http.Request {
+	return uploadRequestOf(t, "video/mp4", []byte("video bytes"))
+}
+
+func uploadRequestOf(t *testing.T, contentType string, data []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="data"; filename="video.mp4"`)
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/v1/files", body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestPostSingleFileHandlerStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		manager    *uploadManager
+		wantStatus int
+	}{
+		{name: "stored", manager: &uploadManager{}, wantStatus: http.StatusCreated},
+		{name: "duplicate", manager: &uploadManager{existingId: "other"}, wantStatus: http.StatusConflict},
+		{name: "lost duplicate race", manager: &uploadManager{saveErr: &controllers.DuplicateFileError{FileId: "other"}}, wantStatus: http.StatusConflict},
+		{name: "quota exceeded", manager: &uploadManager{saveErr: fmt.Errorf("%w: tenant quota is 10 bytes", controllers.ErrQuotaExceeded)}, wantStatus: http.StatusInsufficientStorage},
+		{name: "file exceeds quota", manager: &uploadManager{saveErr: fmt.Errorf("%w: file has 11 bytes", controllers.ErrFileExceedsQuota)}, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "save failed", manager: &uploadManager{saveErr: fmt.Errorf("connection reset")}, wantStatus: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &Handler{VideoCatalogueManager: test.manager, Config: testConfig(nil)}
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = uploadRequest(t)
+
+			h.PostSingleFileHandler(c)
+			// gin writes the header of body-less responses after the handler
+			c.Writer.WriteHeaderNow()
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status %d, expected %d: %s", recorder.Code, test.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+}
+
+// TestPostSingleFileHandlerStreamsUpload, the file reaches the manager complete and hashed from a
+// temporary file, which is gone once the request is answered
+func TestPostSingleFileHandlerStreamsUpload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	manager := &uploadManager{}
+	h := &Handler{VideoCatalogueManager: manager, Config: testConfig(nil)}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = uploadRequestOf(t, "video/mp4", data)
+
+	h.PostSingleFileHandler(c)
+	c.Writer.WriteHeaderNow()
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status %d, expected %d: %s", recorder.Code, http.StatusCreated, recorder.Body.String())
+	}
+	if !bytes.Equal(manager.saved, data) || manager.savedSize != int64(len(data)) {
+		t.Fatalf("saved %d bytes of size %d, expected the %d uploaded", len(manager.saved), manager.savedSize, len(data))
+	}
+	if hash, _ := utils.ToSHA256(data); manager.savedHash != hash {
+		t.Fatalf("hash %s, expected %s", manager.savedHash, hash)
+	}
+	if manager.savedFrom == "" {
+		t.Fatal("file not read from a temporary file")
+	}
+	if _, err := os.Stat(manager.savedFrom); !os.IsNotExist(err) {
+		t.Fatalf("temporary file %s not removed, Stat error: %v", manager.savedFrom, err)
+	}
+}
+
+// TestPostSingleFileHandlerDuplicate, the rejection is recorded and the stored file only pointed out to
+// callers who can read it
+func TestPostSingleFileHandlerDuplicate(t *testing.T) {
+	tests := []struct {
+		name         string
+		unreadable   bool
+		wantFileId   string
+		wantLocation string
+	}{
+		{name: "readable", wantFileId: "other", wantLocation: "http://example.com/v1/files/locate/other"},
+		{name: "unreadable", unreadable: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := &uploadManager{existingId: "other", unreadable: test.unreadable}
+			h := &Handler{VideoCatalogueManager: manager, Config: testConfig(nil)}
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = uploadRequest(t)
+
+			h.PostSingleFileHandler(c)
+			if recorder.Code != http.StatusConflict {
+				t.Fatalf("status %d, expected %d: %s", recorder.Code, http.StatusConflict, recorder.Body.String())
+			}
+			if manager.rejected != "other" {
+				t.Fatalf("rejection recorded for %q, expected other", manager.rejected)
+			}
+			var response map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response["fileid"] != test.wantFileId || response["location"] != test.wantLocation {
+				t.Fatalf("fileid %q location %q, expected %q %q", response["fileid"], response["location"], test.wantFileId, test.wantLocation)
+			}
+		})
+	}
+}
+
+func TestPostSingleFileHandlerFileLimits(t *testing.T) {
+	limits := &models.BodyLimits{MimeTypes: map[string]int64{"video/mp4": 10}, MinFileBytes: 4}
+	tests := []struct {
+		name        string
+		contentType string
+		size        int
+		wantStatus  int
+	}{
+		{"at the media type limit", "video/mp4", 10, http.StatusCreated},
+		{"over the media type limit", "video/mp4", 11, http.StatusRequestEntityTooLarge},
+		{"media type without limit", "video/mpeg", 1000, http.StatusCreated},
+		{"under the minimum size", "video/mpeg", 3, http.StatusBadRequest},
+		{"unsupported media type", "image/png", 5, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := &uploadManager{}
+			h := &Handler{VideoCatalogueManager: manager, Config: testConfig(limits)}
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = uploadRequestOf(t, test.contentType, make([]byte, test.size))
+
+			h.PostSingleFileHandler(c)
+			c.Writer.WriteHeaderNow()
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status %d, expected %d: %s", recorder.Code, test.wantStatus, recorder.Body.String())
+			}
+			if test.wantStatus != http.StatusCreated && manager.saved != nil {
+				t.Fatal("rejected file was saved")
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	logger.Logger = log.New()
+	logger.Logger.Out = io.Discard
+	os.Exit(m.Run())
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"city_os/src/interfaces"
+	"city_os/src/metrics"
+	"city_os/src/middlewares"
+	"city_os/src/models"
+	"city_os/src/utils"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeCatalogue, one stored file and the hash of a second one, methods the handlers under test don't call
+// panic through the nil embedded interface
+type fakeCatalogue struct {
+	interfaces.IVideoCatalogueManager
+	file          models.VideoFileData
+	duplicateHash string
+}
+
+func (fc *fakeCatalogue) GetFileByFileId(_ context.Context, fileId string, byteRange *models.ByteRange, _ *models.Principal) (*models.VideoFileData, error) {
+	if fileId != "stored" {
+		return nil, errors.New("mongo: no documents in result")
+	}
+	fileData := fc.file
+	fileData.TotalSize = int64(len(fc.file.FileDataBytes))
+	if byteRange != nil {
+		offset, length, _ := byteRange.Resolve(fileData.TotalSize)
+		fileData.Offset, fileData.FileDataBytes = offset, fc.file.FileDataBytes[offset:offset+length]
+	}
+	return &fileData, nil
+}
+
+func (fc *fakeCatalogue) GetVideoDocIdByHash(_ context.Context, hash string, _ *models.Principal) (string, error) {
+	if hash == fc.duplicateHash {
+		return "stored", nil
+	}
+	return "", nil
+}
+
+func (fc *fakeCatalogue) RejectDuplicateUpload(context.Context, string, string, *models.Principal) {}
+
+func (fc *fakeCatalogue) SaveVideoFile(context.Context, io.ReadSeeker, int64, string, string, string, *models.Principal) (string, error) {
+	return "created", nil
+}
+
+func (fc *fakeCatalogue) GetFilesDataById(_ context.Context, fileId string, _ *models.Principal) (*models.VideoCatalogueData, error) {
+	return &models.VideoCatalogueData{FileId: fileId}, nil
+}
+
+func newMetricsTestRouter(t *testing.T, catalogue *fakeCatalogue) (*gin.Engine, *metrics.Metrics) {
+	t.Helper()
+	appMetrics := metrics.New(prometheus.NewRegistry())
+	handler := Handler{
+		VideoCatalogueManager: catalogue,
+		Metrics:               appMetrics,
+		Config:                testConfig(nil),
+	}
+
+	router := gin.New()
+	router.Use(middlewares.MetricsMiddleware(appMetrics))
+	router.GET("/v1/files/:fileid", handler.GetFileByIdHandler)
+	router.POST("/v1/files", handler.PostSingleFileHandler)
+	return router, appMetrics
+}
+
+// requestDurationCount, observations of the request duration histogram of the route
+func requestDurationCount(t *testing.T, appMetrics *metrics.Metrics, method string, route string) uint64 {
+	t.Helper()
+	families, err := appMetrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "city_os_http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["method"] == method && labels["route"] == route {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestDownloadMetrics(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	router, appMetrics := newMetricsTestRouter(t, &fakeCatalogue{
+		file: models.VideoFileData{Name: "clip.mp4", FileMimeType: "video/mp4", FileDataBytes: content},
+	})
+
+	requests := []struct {
+		path   string
+		rangeH string
+		code   int
+	}{
+		{path: "/v1/files/stored", code: http.StatusOK},
+		{path: "/v1/files/stored", rangeH: "bytes=100-199", code: http.StatusPartialContent},
+		{path: "/v1/files/missing", code: http.StatusNotFound},
+	}
+	for _, request := range requests {
+		recorder := httptest.NewRecorder()
+		httpRequest := httptest.NewRequest(http.MethodGet, request.path, nil)
+		if request.rangeH != "" {
+			httpRequest.Header.Set("Range", request.rangeH)
+		}
+		router.ServeHTTP(recorder, httpRequest)
+		if recorder.Code != request.code {
+			t.Fatalf("GET %s %s: status %d, expected %d", request.path, request.rangeH, recorder.Code, request.code)
+		}
+	}
+
+	route := "/v1/files/:fileid"
+	for code, expected := range map[string]float64{"200": 1, "206": 1, "404": 1} {
+		if got := testutil.ToFloat64(appMetrics.RequestsTotal.WithLabelValues(http.MethodGet, route, code)); got != expected {
+			t.Errorf("requests_total code %s: %v, expected %v", code, got, expected)
+		}
+	}
+	if got := requestDurationCount(t, appMetrics, http.MethodGet, route); got != 3 {
+		t.Errorf("request_duration_seconds count: %d, expected 3", got)
+	}
+	if got := testutil.ToFloat64(appMetrics.DownloadedBytes); got != float64(len(content)+100) {
+		t.Errorf("downloaded_bytes_total: %v, expected %d", got, len(content)+100)
+	}
+}
+
+func TestUploadMetrics(t *testing.T) {
+	duplicate := []byte("duplicate video bytes")
+	hash, err := utils.ToSHA256(duplicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, appMetrics := newMetricsTestRouter(t, &fakeCatalogue{duplicateHash: hash})
+
+	uploads := []struct {
+		content []byte
+		code    int
+	}{
+		{content: []byte("new video bytes"), code: http.StatusCreated},
+		{content: duplicate, code: http.StatusConflict},
+	}
+	for _, upload := range uploads {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, uploadRequestOf(t, "video/mp4", upload.content))
+		if recorder.Code != upload.code {
+			t.Fatalf("upload of %q: status %d, expected %d, body %s", upload.content, recorder.Code, upload.code, recorder.Body.String())
+		}
+	}
+
+	for code, expected := range map[string]float64{"201": 1, "409": 1} {
+		if got := testutil.ToFloat64(appMetrics.RequestsTotal.WithLabelValues(http.MethodPost, "/v1/files", code)); got != expected {
+			t.Errorf("requests_total code %s: %v, expected %v", code, got, expected)
+		}
+	}
+	if got := requestDurationCount(t, appMetrics, http.MethodPost, "/v1/files"); got != 2 {
+		t.Errorf("request_duration_seconds count: %d, expected 2", got)
+	}
+	if got := testutil.ToFloat64(appMetrics.UploadedBytes); got != float64(len("new video bytes")) {
+		t.Errorf("uploaded_bytes_total: %v, expected %d", got, len("new video bytes"))
+	}
+	if got := testutil.ToFloat64(appMetrics.DuplicateRejections); got != 1 {
+		t.Errorf("duplicate_rejections_total: %v, expected 1", got)
+	}
+	if got := testutil.ToFloat64(appMetrics.UploadsInFlight); got != 0 {
+		t.Errorf("uploads_in_flight: %v, expected 0 once the uploads finished", got)
+	}
+}
+
+func TestUnmatchedRouteMetrics(t *testing.T) {
+	router, appMetrics := newMetricsTestRouter(t, &fakeCatalogue{})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/unknown/path", nil))
+
+	if got := testutil.ToFloat64(appMetrics.RequestsTotal.WithLabelValues(http.MethodGet, "unmatched", "404")); got != 1 {
+		t.Errorf("requests_total of unmatched route: %v, expected 1", got)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/middlewares"
+	"city_os/src/models"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+func (h *Handler) ShareFileHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	fileId, found := c.Params.Get("fileid")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "fileID is a mandatory path param"})
+		return
+	}
+	request := models.ShareRequest{}
+	if err := bindOptionalJSON(c, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing share request failed", "error": err.Error()})
+		return
+	}
+
+	signedURL, err := h.ShareManager.ShareFile(c.Request.Context(), fileId, &request, middlewares.GetPrincipal(c))
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, signedURL)
+}
+
+func (h *Handler) ShareUploadHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	request := models.ShareRequest{}
+	if err := bindOptionalJSON(c, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing share request failed", "error": err.Error()})
+		return
+	}
+
+	signedURL, err := h.ShareManager.ShareUpload(c.Request.Context(), &request, middlewares.GetPrincipal(c))
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, signedURL)
+}
+
+// bindOptionalJSON, share requests may come without body to take the defaults
+func bindOptionalJSON(c *gin.Context, request interface{}) error {
+	if c.Request.ContentLength == 0 {
+		return nil
+	}
+	return c.ShouldBindJSON(request)
+}
+
+func respondShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidShare):
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid share request", "error": err.Error()})
+	case errors.Is(err, controllers.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"message": "Access denied", "error": err.Error()})
+	case strings.Contains(err.Error(), "no document") || strings.Contains(err.Error(), "ObjectID"):
+		c.JSON(http.StatusNotFound, gin.H{"message": "File not found", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Minting signed url failed", "error": err.Error()})
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"city_os/src/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// GetUploadProgressHandler, Server-Sent Events stream of an upload's stages and received bytes.
+// It may be opened before the upload request is sent, the stream ends after the final result.
+
+func (h *Handler) GetUploadProgressHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+		}
+	}()
+	subscription, err := h.UploadProgress.Subscribe(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid upload id", "error": err.Error()})
+		return
+	}
+	defer subscription.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case <-subscription.Notify():
+			for _, event := range subscription.Drain() {
+				data, err := json.Marshal(event)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Stage, data)
+				if event.IsFinal() {
+					c.Writer.Flush()
+					return
+				}
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+var (
+	errMissingFilePart      = errors.New("multipart form has no data file")
+	errUnsupportedMediaType = errors.New("media type not supported")
+	errFileTooLarge         = errors.New("file exceeds the size limit of its media type")
+	errFileTooSmall         = errors.New("file is smaller than the minimum size")
+)
+
+// uploadedFile, the "data" file of an upload, received into a temporary file
+type uploadedFile struct {
+	name        string
+	contentType string
+	hash        string // ToSHA256 of the file's bytes
+	size        int64
+	file        *os.File
+}
+
+// remove, closing and deleting the temporary file
+func (upload *uploadedFile) remove() {
+	upload.file.Close()
+	if err := os.Remove(upload.file.Name()); err != nil {
+		logger.Logger.Error(fmt.Sprintf("Removing upload temp file failed!! Error: %s", err.Error()))
+	}
+}
+
+// readUploadedFile, streaming the "data" file of the multipart body into a temporary file and hashing it
+// on the way, so the request never holds the file in memory. The media type is checked from the part
+// header before the file is read, its size against the limit of the media type while reading and against
+// the minimum size afterwards. The caller has to remove the returned file.
+
+func (h *Handler) readUploadedFile(c *gin.Context, supportedMediaTypes []string, bodyLimits *models.BodyLimits) (*uploadedFile, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errMissingFilePart
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "data" || part.FileName() == "" {
+			continue
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		isSupportedMediaType := false
+		for _, mType := range supportedMediaTypes {
+			if mType == contentType {
+				isSupportedMediaType = true
+				break
+			}
+		}
+		if !isSupportedMediaType {
+			return nil, errUnsupportedMediaType
+		}
+
+		limit := bodyLimits.MimeTypeLimit(contentType)
+		source := io.Reader(part)
+		if limit > 0 {
+			source = io.LimitReader(part, limit+1)
+		}
+		file, err := os.CreateTemp("", "upload-*")
+		if err != nil {
+			return nil, err
+		}
+		upload := &uploadedFile{name: part.FileName(), contentType: contentType, file: file}
+		hasher := utils.NewFileHasher()
+		upload.size, err = io.Copy(io.MultiWriter(file, hasher), source)
+		if err == nil && limit > 0 && upload.size > limit {
+			err = fmt.Errorf("%w: %s files are limited to %d bytes", errFileTooLarge, contentType, limit)
+		}
+		if err == nil && bodyLimits != nil && upload.size < bodyLimits.MinFileBytes {
+			err = fmt.Errorf("%w: files must have at least %d bytes", errFileTooSmall, bodyLimits.MinFileBytes)
+		}
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			upload.remove()
+			return nil, err
+		}
+		upload.hash = hex.EncodeToString(hasher.Sum(nil))
+		return upload, nil
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"city_os/cmd/app/configs"
+	"github.com/gin-gonic/gin"
+	"net"
+	"strings"
+)
+
+// absoluteURL, URL of path as clients reach the server: below the configured public base URL, otherwise
+// from the X-Forwarded-Proto/Host/Prefix headers when a trusted proxy sent them, otherwise from the
+// request's own scheme and Host.
+
+func absoluteURL(c *gin.Context, config *configs.AppConfig, path string) string {
+	if config.Server.PublicBaseURL != "" {
+		return config.BaseURL() + path
+	}
+
+	scheme, host, prefix := "http", c.Request.Host, ""
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if isTrustedProxy(c.Request.RemoteAddr, config.Server.TrustedProxies) {
+		if proto := strings.ToLower(firstForwarded(c.GetHeader("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := firstForwarded(c.GetHeader("X-Forwarded-Host")); forwardedHost != "" &&
+			!strings.ContainsAny(forwardedHost, "/\\@ ") {
+			host = forwardedHost
+		}
+		if forwardedPrefix := firstForwarded(c.GetHeader("X-Forwarded-Prefix")); strings.HasPrefix(forwardedPrefix, "/") &&
+			!strings.HasPrefix(forwardedPrefix, "//") {
+			prefix = strings.TrimSuffix(forwardedPrefix, "/")
+		}
+	}
+	return scheme + "://" + host + prefix + path
+}
+
+// firstForwarded, value added by the proxy closest to the client when several proxies appended theirs
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// isTrustedProxy, whether remoteAddr is one of the trusted proxy IPs or inside one of their CIDR ranges
+func isTrustedProxy(remoteAddr string, trustedProxies []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"city_os/cmd/app/configs"
+	"crypto/tls"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAbsoluteURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		publicBaseURL  string
+		trustedProxies []string
+		remoteAddr     string
+		tls            bool
+		headers        map[string]string
+		want           string
+	}{
+		{name: "request host", remoteAddr: "10.0.0.1:1234", want: "http://api.internal/v1/files/locate/id"},
+		{name: "request over TLS", remoteAddr: "10.0.0.1:1234", tls: true, want: "https://api.internal/v1/files/locate/id"},
+		{
+			name:           "public base URL wins over forwarded headers",
+			publicBaseURL:  "https://files.example.com/video",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Host": "proxy.example.com"},
+			want:           "https://files.example.com/video/v1/files/locate/id",
+		},
+		{
+			name:          "public base URL with a trailing slash",
+			publicBaseURL: "https://files.example.com/",
+			remoteAddr:    "10.0.0.1:1234",
+			want:          "https://files.example.com/v1/files/locate/id",
+		},
+		{
+			name:           "trusted proxy",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-Proto":  "HTTPS",
+				"X-Forwarded-Host":   "files.example.com, proxy.internal",
+				"X-Forwarded-Prefix": "/video/",
+			},
+			want: "https://files.example.com/video/v1/files/locate/id",
+		},
+		{
+			name:           "trusted proxy with an unknown proto",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "javascript"},
+			want:           "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:           "untrusted remote forging headers",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "203.0.113.7:1234",
+			headers: map[string]string{
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "evil.example.com",
+				"X-Forwarded-Prefix": "/evil",
+			},
+			want: "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:       "forwarded headers without trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-Host": "evil.example.com"},
+			want:       "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:           "forwarded host with a path",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Host": "evil.example.com/x"},
+			want:           "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:           "forwarded host with userinfo",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Host": "files.example.com@evil.example.com"},
+			want:           "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:           "forwarded host with a space",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Host": "files.example.com evil.example.com"},
+			want:           "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:           "protocol-relative forwarded prefix",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Prefix": "//evil.example.com"},
+			want:           "http://api.internal/v1/files/locate/id",
+		},
+		{
+			name:           "relative forwarded prefix",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Prefix": "video"},
+			want:           "http://api.internal/v1/files/locate/id",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &configs.AppConfig{}
+			config.Server.PublicBaseURL = test.publicBaseURL
+			config.Server.TrustedProxies = test.trustedProxies
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "http://api.internal/v1/files", nil)
+			c.Request.RemoteAddr = test.remoteAddr
+			if test.tls {
+				c.Request.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range test.headers {
+				c.Request.Header.Set(name, value)
+			}
+
+			if got := absoluteURL(c, config, "/v1/files/locate/id"); got != test.want {
+				t.Fatalf("got %s, expected %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsTrustedProxy(t *testing.T) {
+	trustedProxies := []string{"192.168.1.10", "10.0.0.0/8", "2001:db8::/32", "not a proxy"}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"192.168.1.10:1234", true},
+		{"192.168.1.11:1234", false},
+		{"10.20.30.40:1234", true},
+		{"11.0.0.1:1234", false},
+		{"[2001:db8::1]:1234", true},
+		{"[2001:db9::1]:1234", false},
+		{"192.168.1.10", true},
+		{"not an address", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isTrustedProxy(test.remoteAddr, trustedProxies); got != test.want {
+			t.Errorf("isTrustedProxy(%q) = %t, expected %t", test.remoteAddr, got, test.want)
+		}
+	}
+}
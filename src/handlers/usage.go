@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/middlewares"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func (h *Handler) GetUsageHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	usageReport, err := h.VideoCatalogueManager.GetUsage(c.Request.Context(), middlewares.GetPrincipal(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Fetching usage failed", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, usageReport)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/controllers"
+	"city_os/src/models"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+func (h *Handler) CreateWebhookHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	request := models.WebhookRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing webhook failed", "error": err.Error()})
+		return
+	}
+
+	webhook, err := h.WebhookManager.CreateWebhook(tenantOf(c), &request)
+	if err != nil {
+		h.webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, webhook)
+}
+
+func (h *Handler) GetWebhooksListHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	webhooks, err := h.WebhookManager.GetWebhooksList(tenantOf(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Fetching webhooks failed", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *Handler) GetWebhookByIdHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	webhook, err := h.WebhookManager.GetWebhookById(tenantOf(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (h *Handler) UpdateWebhookHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	request := models.WebhookRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Parsing webhook failed", "error": err.Error()})
+		return
+	}
+
+	webhook, err := h.WebhookManager.UpdateWebhook(tenantOf(c), c.Param("id"), &request)
+	if err != nil {
+		h.webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (h *Handler) DeleteWebhookHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	deleted, err := h.WebhookManager.DeleteWebhook(tenantOf(c), c.Param("id"))
+	if err != nil || !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+func (h *Handler) GetWebhookDeliveriesHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	deliveries, err := h.WebhookManager.GetDeliveries(tenantOf(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *Handler) RedeliverWebhookHandler(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Panic occurred!!Error: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Unknown error occurred"})
+		}
+	}()
+	delivery, err := h.WebhookManager.Redeliver(tenantOf(c), c.Param("id"), c.Param("deliveryid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Delivery not found", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+func (h *Handler) webhookError(c *gin.Context, err error) {
+	if errors.Is(err, controllers.ErrInvalidWebhook) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid webhook", "error": err.Error()})
+		return
+	}
+	if strings.Contains(err.Error(), "no document") || strings.Contains(err.Error(), "ObjectID") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found", "error": err.Error()})
+		return
+	}
+	logger.FromContext(c.Request.Context()).Error(fmt.Sprintf("Webhook operation failed!! Error: %s", err.Error()))
+	c.JSON(http.StatusInternalServerError, gin.H{"message": "Webhook operation failed", "error": err.Error()})
+}
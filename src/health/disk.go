@@ -0,0 +1,27 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var errDiskStatsUnsupported = errors.New("free disk space can't be determined on this platform")
+
+// FreeDiskSpace, check of the free space of the file system of path, e.g. the temp directory net/http
+// spools large multipart files to. Platforms without disk stats pass the check.
+func FreeDiskSpace(path string, minFreeBytes uint64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		free, err := freeDiskBytes(path)
+		if errors.Is(err, errDiskStatsUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < minFreeBytes {
+			return fmt.Errorf("%d bytes free in %s, at least %d needed", free, path, minFreeBytes)
+		}
+		return nil
+	}
+}
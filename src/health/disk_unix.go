@@ -0,0 +1,14 @@
+//go:build unix
+
+package health
+
+import "syscall"
+
+func freeDiskBytes(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	// blocks available to unprivileged users, not the ones reserved for root
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,83 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Status of a check and of a report
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusDraining = "draining"
+)
+
+// Check, a dependency the service needs to serve requests, Check returns nil when it is usable
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult, outcome of a single check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report, outcome of all checks, up only when every check is up
+type Report struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks"`
+}
+
+// Checker, running the readiness checks concurrently, every check gets Timeout
+
+type Checker struct {
+	Checks  []Check
+	Timeout time.Duration
+}
+
+func (hc *Checker) Run(ctx context.Context) *Report {
+	report := &Report{Status: StatusUp, Checks: make(map[string]*CheckResult, len(hc.Checks))}
+	results := make([]*CheckResult, len(hc.Checks))
+
+	var wg sync.WaitGroup
+	for i, check := range hc.Checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = hc.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range hc.Checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func (hc *Checker) run(ctx context.Context, check Check) *CheckResult {
+	if hc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hc.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := &CheckResult{
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
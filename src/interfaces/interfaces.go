@@ -1,33 +1,200 @@
 package interfaces
 
-import "city_os/src/models"
+import (
+	"city_os/src/models"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
 
+// IDBWrapper, ctx carries the caller's trace span down to the database calls
 type IDBWrapper interface {
-	GetDocumentById(id string) (interface{}, error)
-	GetAllDocuments() ([]interface{}, error)
-	DeleteDocumentById(id string) (int64, error)
-	InsertDocument(insertData interface{}) (string, error)
-	GetSingleDocByFilter(filterCondition interface{}) (interface{}, error)
+	GetDocumentById(ctx context.Context, id string) (interface{}, error)
+	GetAllDocuments(ctx context.Context) ([]interface{}, error)
+	GetDocumentsByFilter(ctx context.Context, filterCondition interface{}) ([]interface{}, error)
+	DeleteDocumentById(ctx context.Context, id string) (int64, error)
+	InsertDocument(ctx context.Context, insertData interface{}) (string, error)
+	GetSingleDocByFilter(ctx context.Context, filterCondition interface{}) (interface{}, error)
+	UpdateDocumentById(ctx context.Context, id string, setFields interface{}) (int64, error)
+	// UpdateDocumentByIdAndFilter only updates the document while it matches filterCondition, returns the matched count
+	UpdateDocumentByIdAndFilter(ctx context.Context, id string, filterCondition interface{}, setFields interface{}) (int64, error)
+	// Catalogue changes together with their outbox event, either both are stored or none
+	InsertDocumentWithEvent(ctx context.Context, insertData interface{}, event *models.Event) (string, error)
+	DeleteDocumentByIdWithEvent(ctx context.Context, id string, event *models.Event) (int64, error)
+	// ForTenant returns the wrapper restricted to the tenant's namespace
+	ForTenant(tenant string) IDBWrapper
 }
 
 type IFileManagerDBWrapper interface {
-	UploadFile(fileID string, fileDataBytes []byte, filename string) (int, error)
-	DownloadFile(fileName string, filename string) ([]byte, error)
-	DeleteFileByFileId(fileID string) error
+	// UploadFile stores the size bytes read from fileData
+	UploadFile(ctx context.Context, fileID string, fileData io.Reader, size int64, filename string) (int, error)
+	DownloadFile(ctx context.Context, fileName string, filename string) ([]byte, error)
+	// DownloadFileRange, length bytes starting at offset, fewer when the file ends before
+	DownloadFileRange(ctx context.Context, fileID string, filename string, offset int64, length int64) ([]byte, error)
+	DeleteFileByFileId(ctx context.Context, fileID string) error
+	ForTenant(tenant string) IFileManagerDBWrapper
 }
 
+// IVideoCatalogueManager, principal is the caller of the operation, nil when auth is disabled or for
+// internal callers, which skips the access checks. ctx is the request's context.
 type IVideoCatalogueManager interface {
-	GetVideoDocIdBySHAHash(fileDataBytes []byte) (string, string, error)
+	GetVideoDocIdBySHAHash(ctx context.Context, fileDataBytes []byte, principal *models.Principal) (string, string, error)
+	GetVideoDocIdByHash(ctx context.Context, hash string, principal *models.Principal) (string, error)
+	RejectDuplicateUpload(ctx context.Context, fileId string, hash string, principal *models.Principal)
 	SaveVideoFile(
-		fileDataBytes []byte,
+		ctx context.Context,
+		fileData io.ReadSeeker,
+		size int64,
 		filename string,
 		fileMimeType string,
 		hash string,
+		principal *models.Principal,
 	) (string, error)
+	// GetFileByFileId, the whole file when byteRange is nil, otherwise the bytes of the range
 	GetFileByFileId(
+		ctx context.Context,
 		fileId string,
+		byteRange *models.ByteRange,
+		principal *models.Principal,
 	) (*models.VideoFileData, error)
-	GetFilesDataById(fileId string) (*models.VideoCatalogueData, error)
-	GetVideoFilesList() ([]*models.VideoFilesDataResponse, error)
-	DeleteVideoFile(fileid string) (bool, error)
+	GetFilesDataById(ctx context.Context, fileId string, principal *models.Principal) (*models.VideoCatalogueData, error)
+	// GetVideoFilesList, files of the collection only unless it is empty
+	GetVideoFilesList(ctx context.Context, collection string, principal *models.Principal) ([]*models.VideoFilesDataResponse, error)
+	DeleteVideoFile(ctx context.Context, fileid string, principal *models.Principal) (bool, error)
+	GetFileACL(ctx context.Context, fileId string, principal *models.Principal) (*models.FileACL, error)
+	UpdateFileACL(ctx context.Context, fileId string, request *models.FileACLRequest, principal *models.Principal) (*models.FileACL, error)
+	GetJobById(ctx context.Context, jobId string, principal *models.Principal) (*models.Job, error)
+	GetUsage(ctx context.Context, principal *models.Principal) (*models.UsageReport, error)
+}
+
+type IJobQueue interface {
+	Enqueue(job *models.Job) (string, error)
+	// Lease returns nil job when nothing is due
+	Lease(owner string, leaseFor time.Duration) (*models.Job, error)
+	ExtendLease(jobId string, owner string, leaseFor time.Duration) error
+	Complete(jobId string, owner string) error
+	Retry(jobId string, owner string, lastError string, runAt time.Time) error
+	DeadLetter(jobId string, owner string, lastError string) error
+	GetJobById(jobId string) (*models.Job, error)
+	CountOutstandingJobs(fileId string) (int64, error)
+	CountDeadJobs(fileId string) (int64, error)
+}
+
+type IEventPublisher interface {
+	Publish(event *models.Event) error
+}
+
+type IOutboxDBWrapper interface {
+	AppendEvent(event *models.Event) (int64, error)
+	GetEventsSince(tenant string, seq int64, limit int64) ([]*models.Event, error)
+	// ClaimNextUnpublishedEvent returns nil event when everything is published or the next event in
+	// sequence is claimed by another owner
+	ClaimNextUnpublishedEvent(owner string, claimFor time.Duration) (*models.Event, error)
+	MarkEventPublished(seq int64, owner string) error
+}
+
+type IEventManager interface {
+	// GetEvents waits up to wait for events after since, returns empty list on timeout
+	GetEvents(tenant string, since int64, limit int64, wait time.Duration, done <-chan struct{}) ([]*models.Event, error)
+}
+
+type IWebhookDBWrapper interface {
+	InsertWebhook(webhook *models.Webhook) (string, error)
+	GetWebhookById(webhookId string) (*models.Webhook, error)
+	GetWebhooksByTenant(tenant string) ([]*models.Webhook, error)
+	GetActiveWebhooksByEvent(tenant string, eventType string) ([]*models.Webhook, error)
+	UpdateWebhookById(webhookId string, setFields interface{}) (int64, error)
+	DeleteWebhookById(webhookId string) (int64, error)
+	InsertDelivery(delivery *models.WebhookDelivery) (string, error)
+	GetDeliveryById(deliveryId string) (*models.WebhookDelivery, error)
+	GetDeliveriesByWebhookId(webhookId string, limit int64) ([]*models.WebhookDelivery, error)
+	UpdateDeliveryById(deliveryId string, setFields interface{}) (int64, error)
+	AppendDeliveryAttempt(deliveryId string, attempt models.WebhookDeliveryAttempt, status string) error
+}
+
+// IWebhookManager, webhooks belong to a tenant, webhooks of other tenants are reported as not found
+type IWebhookManager interface {
+	CreateWebhook(tenant string, request *models.WebhookRequest) (*models.Webhook, error)
+	GetWebhookById(tenant string, webhookId string) (*models.Webhook, error)
+	GetWebhooksList(tenant string) ([]*models.Webhook, error)
+	UpdateWebhook(tenant string, webhookId string, request *models.WebhookRequest) (*models.Webhook, error)
+	DeleteWebhook(tenant string, webhookId string) (bool, error)
+	GetDeliveries(tenant string, webhookId string) ([]*models.WebhookDelivery, error)
+	Redeliver(tenant string, webhookId string, deliveryId string) (*models.WebhookDelivery, error)
+}
+
+// IAuthenticator, resolves the caller of a request. Nil principal without error means the request
+// carries no credentials of the authenticator's kind. clientIP is the caller's address, the one forwarded
+// by a trusted proxy when the request came through one.
+type IAuthenticator interface {
+	Authenticate(request *http.Request, clientIP string) (*models.Principal, error)
+}
+
+// IUseLimitedAuthenticator, authenticator of credentials which can be used a limited number of times.
+// Authenticate counts a use, ReleaseUse gives it back when the request was rejected.
+type IUseLimitedAuthenticator interface {
+	IAuthenticator
+	ReleaseUse(request *http.Request, principal *models.Principal)
+}
+
+type IAPIKeyDBWrapper interface {
+	InsertAPIKey(apiKey *models.APIKey) (string, error)
+	GetAPIKeyById(keyId string) (*models.APIKey, error)
+	GetAPIKeysByTenant(tenant string) ([]*models.APIKey, error)
+	RevokeAPIKeyById(keyId string) (int64, error)
+}
+
+// IAPIKeyManager, keys are minted into a tenant and only managed from within it
+type IAPIKeyManager interface {
+	MintKey(tenant string, name string, scopes []string) (*models.APIKey, string, error)
+	GetKeysList(tenant string) ([]*models.APIKey, error)
+	RevokeKey(tenant string, keyId string) (bool, error)
+}
+
+// IUsageDBWrapper, usage counters identified by tenant and subject, a tenant's total or a principal reference
+type IUsageDBWrapper interface {
+	// ReserveUsage returns false without changing the counter when the quota would be exceeded
+	ReserveUsage(ctx context.Context, tenant string, subject string, bytes int64, files int64, quota models.Quota) (bool, error)
+	ReleaseUsage(ctx context.Context, tenant string, subject string, bytes int64, files int64) error
+	GetUsage(ctx context.Context, tenant string, subject string) (*models.Usage, error)
+}
+
+type IShareDBWrapper interface {
+	InsertShare(share *models.Share) (string, error)
+	// GetShare doesn't count a use, nil share when it is unknown
+	GetShare(shareId string) (*models.Share, error)
+	// ConsumeShare counts a use, nil share when it is unknown or has no uses left
+	ConsumeShare(shareId string) (*models.Share, error)
+	// ReleaseShare gives back a use counted by ConsumeShare
+	ReleaseShare(shareId string) error
+}
+
+type IShareManager interface {
+	ShareFile(ctx context.Context, fileId string, request *models.ShareRequest, principal *models.Principal) (*models.SignedURL, error)
+	ShareUpload(ctx context.Context, request *models.ShareRequest, principal *models.Principal) (*models.SignedURL, error)
+}
+
+// IScanner, malware scanner of uploaded files, errors mean the file couldn't be scanned
+type IScanner interface {
+	Scan(fileData io.Reader) (*models.ScanResult, error)
+}
+
+// IKeyProvider, master keys sealing the per-file data keys. WrapKey always uses the active master key,
+// UnwrapKey any master key still known to the provider.
+type IKeyProvider interface {
+	ActiveKeyId() string
+	WrapKey(dataKey []byte) (masterKeyId string, wrappedKey []byte, err error)
+	UnwrapKey(masterKeyId string, wrappedKey []byte) ([]byte, error)
+}
+
+type IDataKeyDBWrapper interface {
+	InsertDataKey(dataKey *models.DataKey) error
+	// GetDataKey returns nil when the file has no data key, i.e. it was stored unencrypted
+	GetDataKey(tenant string, fileId string) (*models.DataKey, error)
+	DeleteDataKey(tenant string, fileId string) error
+	// GetDataKeysNotWrappedWith pages by id through the keys sealed by any other master key
+	GetDataKeysNotWrappedWith(masterKeyId string, afterId string, limit int64) ([]*models.DataKey, error)
+	// UpdateWrappedKey replaces the sealed key, false when it was re-wrapped meanwhile
+	UpdateWrappedKey(id string, oldMasterKeyId string, masterKeyId string, wrappedKey []byte) (bool, error)
 }
@@ -0,0 +1,208 @@
+package jobs
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"city_os/src/tracing"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// JobHandlerFunc, processes a single leased job, returned error schedules a retry. ctx carries the
+// job's span.
+type JobHandlerFunc func(ctx context.Context, job *models.Job) error
+
+// JobProcessor, handler of a job type with optional hooks called after the job outcome is persisted
+type JobProcessor struct {
+	Handle         JobHandlerFunc
+	OnSucceeded    func(job *models.Job)
+	OnDeadLettered func(job *models.Job, err error)
+}
+
+// WorkerPool, leases jobs from the queue and dispatches them to the handlers registered per job type.
+// It can run inside the API server or standalone in cmd/worker, any number of pools may share a queue.
+
+type WorkerPool struct {
+	Queue         interfaces.IJobQueue
+	Workers       int
+	LeaseDuration time.Duration
+	PollInterval  time.Duration
+	BackoffBase   time.Duration
+	BackoffMax    time.Duration
+
+	processors map[string]JobProcessor
+	owner      string
+	wg         sync.WaitGroup
+}
+
+func (wp *WorkerPool) Register(jobType string, processor JobProcessor) {
+	if wp.processors == nil {
+		wp.processors = map[string]JobProcessor{}
+	}
+	wp.processors[jobType] = processor
+}
+
+// Start, spawning the workers, they stop leasing new jobs once ctx is cancelled
+
+func (wp *WorkerPool) Start(ctx context.Context) {
+	hostname, _ := os.Hostname()
+	wp.owner = fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+
+	for i := 0; i < wp.Workers; i++ {
+		wp.wg.Add(1)
+		go func(workerId int) {
+			defer wp.wg.Done()
+			wp.work(ctx, fmt.Sprintf("%s-%d", wp.owner, workerId))
+		}(i)
+	}
+	logger.Logger.Info(fmt.Sprintf("Job worker pool started with %d workers", wp.Workers))
+}
+
+// Wait, blocking until all workers finished their current job after ctx cancellation
+
+func (wp *WorkerPool) Wait() {
+	wp.wg.Wait()
+}
+
+func (wp *WorkerPool) work(ctx context.Context, owner string) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, err := wp.Queue.Lease(owner, wp.LeaseDuration)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Leasing job failed!! Error: %s", err.Error()))
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wp.PollInterval):
+			}
+			continue
+		}
+		wp.process(job, owner)
+	}
+}
+
+func (wp *WorkerPool) process(job *models.Job, owner string) {
+	processor, found := wp.processors[job.Type]
+	if !found {
+		wp.deadLetter(job, owner, processor, fmt.Errorf("no handler registered for job type %q", job.Type))
+		return
+	}
+	// Lease of a crashed worker ran out after it already used up the last attempt
+	if job.Attempts > job.MaxAttempts {
+		wp.deadLetter(job, owner, processor, fmt.Errorf("max attempts exceeded, last error: %s", job.LastError))
+		return
+	}
+
+	err := wp.runWithLease(job, owner, processor.Handle)
+	if err == nil {
+		if err = wp.Queue.Complete(job.JobId, owner); err != nil {
+			logger.Logger.WithFields(jobFields(job)).Error(fmt.Sprintf("Completing job failed!! jobId: %s, Error: %s", job.JobId, err.Error()))
+			return
+		}
+		if processor.OnSucceeded != nil {
+			processor.OnSucceeded(job)
+		}
+		return
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		wp.deadLetter(job, owner, processor, err)
+		return
+	}
+	runAt := time.Now().Add(wp.backoff(job.Attempts))
+	logger.Logger.WithFields(jobFields(job)).Warn(fmt.Sprintf("Job failed, retrying at %s!! jobId: %s, Error: %s", runAt.Format(time.RFC3339), job.JobId, err.Error()))
+	if err = wp.Queue.Retry(job.JobId, owner, err.Error(), runAt); err != nil {
+		logger.Logger.WithFields(jobFields(job)).Error(fmt.Sprintf("Scheduling job retry failed!! jobId: %s, Error: %s", job.JobId, err.Error()))
+	}
+}
+
+// runWithLease, running the handler while extending the lease in the background so long running
+// jobs are not picked up by another worker
+
+func (wp *WorkerPool) runWithLease(job *models.Job, owner string, handler JobHandlerFunc) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wp.LeaseDuration / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := wp.Queue.ExtendLease(job.JobId, owner, wp.LeaseDuration); err != nil {
+					logger.Logger.WithFields(jobFields(job)).Warn(fmt.Sprintf("Extending job lease failed!! jobId: %s, Error: %s", job.JobId, err.Error()))
+				}
+			}
+		}
+	}()
+
+	// Not the pool's context, stopping the pool must not abort jobs halfway
+	ctx, span := tracing.Start(context.Background(), "job "+job.Type,
+		attribute.String("job.id", job.JobId),
+		attribute.String("job.type", job.Type),
+		attribute.Int("job.attempt", job.Attempts),
+	)
+	defer func() { tracing.End(span, err) }()
+	fields := jobFields(job)
+	if span.SpanContext().HasTraceID() {
+		fields[logger.FieldTraceID] = span.SpanContext().TraceID().String()
+	}
+	ctx = logger.WithFields(ctx, fields)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occurred: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (wp *WorkerPool) deadLetter(job *models.Job, owner string, processor JobProcessor, cause error) {
+	logger.Logger.WithFields(jobFields(job)).Error(fmt.Sprintf("Job moved to dead-letter!! jobId: %s, Error: %s", job.JobId, cause.Error()))
+	if err := wp.Queue.DeadLetter(job.JobId, owner, cause.Error()); err != nil {
+		logger.Logger.WithFields(jobFields(job)).Error(fmt.Sprintf("Dead-lettering job failed!! jobId: %s, Error: %s", job.JobId, err.Error()))
+		return
+	}
+	if processor.OnDeadLettered != nil {
+		processor.OnDeadLettered(job, cause)
+	}
+}
+
+// backoff, exponential delay with jitter, capped at BackoffMax
+
+func (wp *WorkerPool) backoff(attempt int) time.Duration {
+	delay := wp.BackoffBase
+	for i := 1; i < attempt && delay < wp.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > wp.BackoffMax {
+		delay = wp.BackoffMax
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// jobFields, log fields of the job, the job's handler gets a logger with them in its context
+func jobFields(job *models.Job) log.Fields {
+	return log.Fields{
+		logger.FieldJobID:   job.JobId,
+		logger.FieldJobType: job.Type,
+		logger.FieldFileID:  job.FileId,
+		logger.FieldTenant:  models.NormalizeTenant(job.Tenant),
+	}
+}
@@ -0,0 +1,228 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("jwt: no key with the token's kid in the key set")
+
+// Unknown kids trigger a refetch (the provider may have rotated keys), at most this often
+const minUnknownKidRefresh = time.Minute
+
+// KeySet, public keys of the token issuer. Keys are fetched from a JWKS URL, or read from a JWKS file
+// for offline testing, and refreshed every RefreshInterval. Fetches run without holding the lock, so
+// tokens of known keys are verified meanwhile, and concurrent callers share a single fetch.
+
+type KeySet struct {
+	URL             string
+	File            string
+	HTTPClient      *http.Client
+	RefreshInterval time.Duration
+
+	fetches     singleflight.Group
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// jsonWebKey, the members of RFC 7517/7518 keys needed for RS256 and ES256
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Key, returning the public key with the kid. A key set without kids in it matches any kid when it
+// holds a single key.
+
+func (ks *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	stale := ks.keys == nil || (ks.RefreshInterval > 0 && time.Since(ks.fetchedAt) > ks.RefreshInterval)
+	ks.mu.RUnlock()
+	if stale {
+		if err := ks.refresh(); err != nil && !ks.loaded() {
+			return nil, err
+		}
+	}
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	ks.mu.RLock()
+	lastAttempt := ks.lastAttempt
+	ks.mu.RUnlock()
+	if time.Since(lastAttempt) < minUnknownKidRefresh {
+		return nil, ErrUnknownKey
+	}
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (ks *KeySet) loaded() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys != nil
+}
+
+func (ks *KeySet) lookup(kid string) crypto.PublicKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, found := ks.keys[kid]; found {
+		return key
+	}
+	if len(ks.keys) == 1 {
+		if key, found := ks.keys[""]; found {
+			return key
+		}
+	}
+	return nil
+}
+
+// refresh, loading the key set and swapping it in, callers arriving during a fetch wait for its result
+// instead of starting another. A failed refresh keeps the previous keys.
+func (ks *KeySet) refresh() error {
+	_, err, _ := ks.fetches.Do("jwks", func() (interface{}, error) {
+		ks.mu.Lock()
+		ks.lastAttempt = time.Now()
+		ks.mu.Unlock()
+
+		raw, err := ks.read()
+		if err != nil {
+			return nil, fmt.Errorf("jwt: loading jwks failed: %w", err)
+		}
+		keys, err := ParseJWKS(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		ks.mu.Lock()
+		ks.keys = keys
+		ks.fetchedAt = time.Now()
+		ks.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+func (ks *KeySet) read() ([]byte, error) {
+	if ks.File != "" {
+		return os.ReadFile(ks.File)
+	}
+	if ks.URL == "" {
+		return nil, errors.New("neither jwks url nor jwks file is configured")
+	}
+
+	client := ks.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Get(ks.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d", ks.URL, response.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// ParseJWKS, decoding the RSA and P-256 EC signing keys of a JWKS document. Keys of other types,
+// curves or uses are skipped.
+
+func ParseJWKS(raw []byte) (map[string]crypto.PublicKey, error) {
+	document := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, fmt.Errorf("jwt: parsing jwks failed: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch {
+		case jwk.Kty == "RSA":
+			key, err = jwk.rsaPublicKey()
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			key, err = jwk.ecPublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: jwks contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (jwk *jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || n.BitLen() < 2048 {
+		return nil, errors.New("unsupported rsa key parameters")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (jwk *jsonWebKey) ecPublicKey() (*ecdsa.PublicKey, error) {
+	x, err := decodeBigInt(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return nil, errors.New("point is not on P-256")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
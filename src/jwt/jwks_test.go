@@ -0,0 +1,241 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKeys, an RSA and a P-256 signing key published in a JWKS document under the kids "rsa" and "ec"
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+func (tk *testKeys) jwks(t *testing.T) []byte {
+	t.Helper()
+	encode := func(value *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, size)))
+	}
+	document, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(tk.rsa.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(tk.rsa.E)).Bytes()),
+		},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(tk.ec.X, 32), "y": encode(tk.ec.Y, 32)},
+		// skipped, encryption key
+		{"kty": "EC", "kid": "enc", "use": "enc", "crv": "P-256", "x": encode(tk.ec.X, 32), "y": encode(tk.ec.Y, 32)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+// sign, compact serialized token of the claims signed by the key of alg and published as kid
+func (tk *testKeys) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(value interface{}) string {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signingInput := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch alg {
+	case AlgRS256:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, tk.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case AlgES256:
+		r, s, err := ecdsa.Sign(rand.Reader, tk.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// unsigned, the token's claims with alg "none" and no signature
+func unsigned(token string) string {
+	parts := strings.Split(token, ".")
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + parts[1] + "."
+}
+
+func writeJWKS(t *testing.T, document []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, document, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifierWithJWKSFile(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := Verifier{
+		Keys:     &KeySet{File: writeJWKS(t, keys.jwks(t))},
+		Issuer:   "https://issuer.example.com",
+		Audience: "city_os",
+		Leeway:   5 * time.Second,
+	}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss": "https://issuer.example.com",
+			"aud": []string{"other", "city_os"},
+			"sub": "user-1",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+				continue
+			}
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "RS256", token: keys.sign(t, AlgRS256, "rsa", claims(nil))},
+		{name: "ES256", token: keys.sign(t, AlgES256, "ec", claims(nil))},
+		{name: "audience as string", token: keys.sign(t, AlgES256, "ec", claims(map[string]interface{}{"aud": "city_os"}))},
+		{name: "expired", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})), wantErr: ErrTokenExpired},
+		{name: "expired within leeway", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"exp": time.Now().Add(-time.Second).Unix()}))},
+		{name: "not yet valid", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"nbf": time.Now().Add(time.Minute).Unix()})), wantErr: ErrTokenNotYetValid},
+		{name: "without exp", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"exp": nil})), wantErr: ErrMalformedToken},
+		{name: "other audience", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"aud": "other"})), wantErr: ErrInvalidAudience},
+		{name: "without audience", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"aud": nil})), wantErr: ErrInvalidAudience},
+		{name: "other issuer", token: keys.sign(t, AlgRS256, "rsa", claims(map[string]interface{}{"iss": "https://evil.example.com"})), wantErr: ErrInvalidIssuer},
+		{name: "unknown kid", token: keys.sign(t, AlgRS256, "rotated", claims(nil)), wantErr: ErrUnknownKey},
+		{name: "encryption key", token: keys.sign(t, AlgES256, "enc", claims(nil)), wantErr: ErrUnknownKey},
+		{name: "RS256 claimed for the EC key", token: keys.sign(t, AlgRS256, "ec", claims(nil)), wantErr: ErrInvalidSignature},
+		{name: "alg none", token: unsigned(keys.sign(t, AlgRS256, "rsa", claims(nil))), wantErr: ErrUnsupportedAlg},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifier.Verify(test.token)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got claims %v, error %v", test.wantErr, claims, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.String("sub") != "user-1" {
+				t.Fatalf("sub claim %q, expected user-1", claims.String("sub"))
+			}
+		})
+	}
+}
+
+func TestKeySetUnknownKidRereadsFile(t *testing.T) {
+	keys := newTestKeys(t)
+	path := writeJWKS(t, []byte(`{"keys": []}`))
+	keySet := &KeySet{File: path}
+	if _, err := keySet.Key("rsa"); err == nil {
+		t.Fatal("empty key set accepted")
+	}
+
+	// nothing loaded yet, every lookup reads the file
+	if err := os.WriteFile(path, keys.jwks(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keySet.Key("rsa"); err != nil {
+		t.Fatalf("lookup without loaded keys: %v", err)
+	}
+
+	// a kid missing from the loaded keys reads the file again, at most every minUnknownKidRefresh
+	keySet.mu.Lock()
+	keySet.keys = map[string]crypto.PublicKey{"ec": &keys.ec.PublicKey}
+	keySet.mu.Unlock()
+	if _, err := keySet.Key("rsa"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown kid within minUnknownKidRefresh: expected ErrUnknownKey, got %v", err)
+	}
+	keySet.mu.Lock()
+	keySet.lastAttempt = time.Now().Add(-2 * minUnknownKidRefresh)
+	keySet.mu.Unlock()
+	if _, err := keySet.Key("rsa"); err != nil {
+		t.Fatalf("unknown kid after minUnknownKidRefresh: %v", err)
+	}
+}
+
+func TestKeySetSharesConcurrentFetches(t *testing.T) {
+	keys := newTestKeys(t)
+	document := keys.jwks(t)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		_, _ = w.Write(document)
+	}))
+	defer server.Close()
+
+	keySet := &KeySet{URL: server.URL}
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := keySet.Key("ec")
+			errs <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("%d fetches for concurrent lookups, expected 1", got)
+	}
+}
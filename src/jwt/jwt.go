@@ -0,0 +1,212 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Signature algorithms accepted, "none" and the HMAC family never are
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+var (
+	ErrMalformedToken   = errors.New("jwt: malformed token")
+	ErrUnsupportedAlg   = errors.New("jwt: unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrTokenExpired     = errors.New("jwt: token expired")
+	ErrTokenNotYetValid = errors.New("jwt: token not valid yet")
+	ErrInvalidIssuer    = errors.New("jwt: unexpected issuer")
+	ErrInvalidAudience  = errors.New("jwt: unexpected audience")
+)
+
+// Claims, decoded token payload. Numbers are json.Number.
+type Claims map[string]interface{}
+
+// Verifier, validating compact serialized JWS tokens: signature against the key set, then exp/nbf and,
+// when configured, iss and aud.
+
+type Verifier struct {
+	Keys     *KeySet
+	Issuer   string // empty accepts any issuer
+	Audience string // empty accepts any audience
+	Leeway   time.Duration
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	tokenHeader := header{}
+	if err := decodeSegment(parts[0], &tokenHeader); err != nil {
+		return nil, err
+	}
+	if tokenHeader.Alg != AlgRS256 && tokenHeader.Alg != AlgES256 {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlg, tokenHeader.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	key, err := v.Keys.Key(tokenHeader.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = verifySignature(tokenHeader.Alg, key, digest[:], signature); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature, the key type has to match the algorithm so an RSA key can't verify ES256 and vice versa
+func verifySignature(alg string, key crypto.PublicKey, digest []byte, signature []byte) error {
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		if alg == AlgRS256 && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// JWS ES256 signatures are R || S, 32 bytes each
+		if alg == AlgES256 && len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if ecdsa.Verify(publicKey, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func (v *Verifier) validateClaims(claims Claims) error {
+	now := time.Now()
+	expiresAt, found, err := claims.Time("exp")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: exp claim is required", ErrMalformedToken)
+	}
+	if now.After(expiresAt.Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+	notBefore, found, err := claims.Time("nbf")
+	if err != nil {
+		return err
+	}
+	if found && now.Add(v.Leeway).Before(notBefore) {
+		return ErrTokenNotYetValid
+	}
+
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return ErrInvalidIssuer
+	}
+	if v.Audience != "" {
+		for _, audience := range claims.Strings("aud") {
+			if audience == v.Audience {
+				return nil
+			}
+		}
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func decodeSegment(segment string, into interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err = decoder.Decode(into); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
+// Lookup, claim by a dotted path such as "realm_access.roles"
+func (c Claims) Lookup(path string) (interface{}, bool) {
+	var value interface{} = map[string]interface{}(c)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func (c Claims) String(path string) string {
+	value, _ := c.Lookup(path)
+	text, _ := value.(string)
+	return text
+}
+
+// Strings, claim which is either an array of strings or a single space separated string (OAuth2 "scope")
+func (c Claims) Strings(path string) []string {
+	value, found := c.Lookup(path)
+	if !found {
+		return nil
+	}
+	switch typed := value.(type) {
+	case string:
+		return strings.Fields(typed)
+	case []interface{}:
+		values := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Time, NumericDate claim (seconds since epoch)
+func (c Claims) Time(path string) (time.Time, bool, error) {
+	value, found := c.Lookup(path)
+	if !found {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrMalformedToken, path)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrMalformedToken, path)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+	"net/http"
+	"time"
+)
+
+const namespace = "city_os"
+
+// Metrics, collectors of the service, registered on the registry given to New instead of the global
+// one, so every test can assert on its own registry. All methods are no-ops on a nil *Metrics, which
+// lets components record unconditionally whether metrics are enabled or not.
+
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	RequestsTotal       *prometheus.CounterVec
+	RequestDuration     *prometheus.HistogramVec
+	UploadedBytes       prometheus.Counter
+	DownloadedBytes     prometheus.Counter
+	DuplicateRejections prometheus.Counter
+	UploadsInFlight     prometheus.Gauge
+	GridFSDuration      *prometheus.HistogramVec
+	MongoPoolOpen       *prometheus.GaugeVec
+	MongoPoolInUse      *prometheus.GaugeVec
+	MongoPoolMax        *prometheus.GaugeVec
+}
+
+func New(registry *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		Registry: registry,
+		RequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "http", Name: "requests_total",
+			Help: "HTTP requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+			Help:    "HTTP request latency by route, uploads and downloads include the transfer.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"method", "route"}),
+		UploadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "files", Name: "uploaded_bytes_total",
+			Help: "Bytes of stored uploads.",
+		}),
+		DownloadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "files", Name: "downloaded_bytes_total",
+			Help: "Bytes of downloaded files.",
+		}),
+		DuplicateRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "files", Name: "duplicate_rejections_total",
+			Help: "Uploads rejected as duplicates of a stored file.",
+		}),
+		UploadsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "files", Name: "uploads_in_flight",
+			Help: "Uploads currently being received or stored.",
+		}),
+		GridFSDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "gridfs", Name: "operation_duration_seconds",
+			Help:    "GridFS operation latency by operation and outcome.",
+			Buckets: prometheus.ExponentialBuckets(.001, 2.5, 12),
+		}, []string{"operation", "outcome"}),
+		MongoPoolOpen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "mongo_pool", Name: "connections_open",
+			Help: "Open connections of the MongoDB connection pool by server.",
+		}, []string{"address"}),
+		MongoPoolInUse: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "mongo_pool", Name: "connections_in_use",
+			Help: "Checked out connections of the MongoDB connection pool by server.",
+		}, []string{"address"}),
+		MongoPoolMax: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "mongo_pool", Name: "connections_max",
+			Help: "Maximum size of the MongoDB connection pool by server.",
+		}, []string{"address"}),
+	}
+	registry.MustRegister(
+		m.RequestsTotal, m.RequestDuration,
+		m.UploadedBytes, m.DownloadedBytes, m.DuplicateRejections, m.UploadsInFlight,
+		m.GridFSDuration, m.MongoPoolOpen, m.MongoPoolInUse, m.MongoPoolMax,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler, the exposition of the registry for GET /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// ObserveRequest, route is the route pattern, never the raw path, to keep the label set bounded
+func (m *Metrics) ObserveRequest(method string, route string, code string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.RequestsTotal.WithLabelValues(method, route, code).Inc()
+	m.RequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+func (m *Metrics) AddUploadedBytes(bytes int) {
+	if m == nil {
+		return
+	}
+	m.UploadedBytes.Add(float64(bytes))
+}
+
+func (m *Metrics) AddDownloadedBytes(bytes int) {
+	if m == nil {
+		return
+	}
+	m.DownloadedBytes.Add(float64(bytes))
+}
+
+func (m *Metrics) IncDuplicateRejections() {
+	if m == nil {
+		return
+	}
+	m.DuplicateRejections.Inc()
+}
+
+// UploadStarted, counting an upload as in flight until the returned func is called
+func (m *Metrics) UploadStarted() (finished func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.UploadsInFlight.Inc()
+	return m.UploadsInFlight.Dec
+}
+
+// ObserveGridFS, latency of a GridFS operation started at start, outcome "error" when err is set
+func (m *Metrics) ObserveGridFS(operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.GridFSDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// PoolMonitor, MongoDB driver pool events kept as the pool gauges, nil without metrics
+func (m *Metrics) PoolMonitor() *event.PoolMonitor {
+	if m == nil {
+		return nil
+	}
+	return &event.PoolMonitor{Event: func(poolEvent *event.PoolEvent) {
+		switch poolEvent.Type {
+		case event.PoolCreated:
+			if poolEvent.PoolOptions != nil {
+				m.MongoPoolMax.WithLabelValues(poolEvent.Address).Set(float64(poolEvent.PoolOptions.MaxPoolSize))
+			}
+		case event.ConnectionCreated:
+			m.MongoPoolOpen.WithLabelValues(poolEvent.Address).Inc()
+		case event.ConnectionClosed:
+			m.MongoPoolOpen.WithLabelValues(poolEvent.Address).Dec()
+		case event.GetSucceeded:
+			m.MongoPoolInUse.WithLabelValues(poolEvent.Address).Inc()
+		case event.ConnectionReturned:
+			m.MongoPoolInUse.WithLabelValues(poolEvent.Address).Dec()
+		case event.PoolClosedEvent:
+			m.MongoPoolOpen.DeleteLabelValues(poolEvent.Address)
+			m.MongoPoolInUse.DeleteLabelValues(poolEvent.Address)
+			m.MongoPoolMax.DeleteLabelValues(poolEvent.Address)
+		}
+	}}
+}
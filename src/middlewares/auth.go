@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/interfaces"
+	"city_os/src/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const principalContextKey = "principal"
+
+// AuthMiddleware, resolving the request's principal with the first authenticator which finds
+// credentials of its kind. Requests without any credentials are rejected when auth is enabled.
+// Credentials with limited uses get their use back when the request ends with an error status.
+
+func AuthMiddleware(enabled bool, authenticators ...interfaces.IAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(c.Request, c.ClientIP())
+			if err != nil {
+				logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Authentication failed!! path: %s, Error: %s", c.FullPath(), err.Error()))
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
+				return
+			}
+			if principal != nil {
+				c.Set(principalContextKey, principal)
+				c.Request = c.Request.WithContext(logger.WithFields(c.Request.Context(), log.Fields{
+					logger.FieldPrincipal: principal.Id,
+					logger.FieldTenant:    models.NormalizeTenant(principal.Tenant),
+				}))
+				c.Next()
+				if limited, ok := authenticator.(interfaces.IUseLimitedAuthenticator); ok && c.Writer.Status() >= http.StatusBadRequest {
+					limited.ReleaseUse(c.Request, principal)
+				}
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication required"})
+	}
+}
+
+// RequireScope, rejecting principals without the scope. Passes everything when auth is disabled,
+// in which case no principal is set.
+
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, found := c.Get(principalContextKey)
+		if !found {
+			c.Next()
+			return
+		}
+		if !value.(*models.Principal).HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("%s scope required", scope)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetPrincipal, caller of the request, nil when auth is disabled
+func GetPrincipal(c *gin.Context) *models.Principal {
+	if value, found := c.Get(principalContextKey); found {
+		return value.(*models.Principal)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// BodyLimitMiddleware, limiting request bodies to the route's limit. Bodies announcing a larger
+// Content-Length are rejected before anything is read, others fail to read once they exceed it.
+// limits is called per request, so that reloaded limits apply to the next request.
+
+func BodyLimitMiddleware(limits func() *models.BodyLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := limits().RouteLimit(c.Request.Method, c.FullPath())
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Request body too large!! path: %s, Content-Length: %d, limit: %d", c.FullPath(), c.Request.ContentLength, limit))
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"message":   "Request body too large",
+				"error":     fmt.Sprintf("Content-Length %d exceeds the limit of %d bytes", c.Request.ContentLength, limit),
+				"max_bytes": limit,
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,143 @@
+package middlewares
+
+import (
+	"city_os/src/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newCORSRouter(policy models.CORSPolicy) *gin.Engine {
+	router := gin.New()
+	router.Use(CORSMiddleware(func() *models.CORSPolicy { return &policy }))
+	router.GET("/v1/files", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func corsRequest(router *gin.Engine, method string, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/v1/files", nil)
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestCORSOriginPatterns(t *testing.T) {
+	router := newCORSRouter(models.CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.org", "https://*.example.com"},
+	})
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.org", true},
+		{"HTTPS://App.Example.Org", true},
+		{"https://a.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://example.com", false},
+		{"https://evil.com.example.com.attacker", false},
+		{"https://a..example.com", false},
+		{"https://a..b.example.com", false},
+		{"https://.example.com", false},
+		{"https://a_b.example.com", false},
+		{"http://a.example.com", false},
+		{"https://a.example.com:8443", false},
+		{"https://evilexample.com", false},
+		{"https://other.example.org", false},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			recorder := corsRequest(router, http.MethodGet, test.origin, nil)
+			allowOrigin := recorder.Header().Get("Access-Control-Allow-Origin")
+			if test.allowed && allowOrigin != test.origin {
+				t.Fatalf("Access-Control-Allow-Origin %q, expected the request's origin", allowOrigin)
+			}
+			if !test.allowed && allowOrigin != "" {
+				t.Fatalf("Access-Control-Allow-Origin %q for an origin not allowed", allowOrigin)
+			}
+			if vary := recorder.Header().Get("Vary"); vary != "Origin" {
+				t.Fatalf("Vary %q, expected Origin", vary)
+			}
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %d, expected the request to reach the handler", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := models.CORSPolicy{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"Location"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}
+	preflight := map[string]string{"Access-Control-Request-Method": "POST"}
+	tests := []struct {
+		name        string
+		policy      models.CORSPolicy
+		origin      string
+		wantHeaders map[string]string
+	}{
+		{"allowed origin", policy, "https://app.example.com", map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, POST",
+			"Access-Control-Allow-Headers":     "Content-Type, Authorization",
+			"Access-Control-Max-Age":           "600",
+			"Access-Control-Expose-Headers":    "",
+			"Vary":                             "Origin",
+		}},
+		{"origin not allowed", policy, "https://example.com", map[string]string{
+			"Access-Control-Allow-Origin":      "",
+			"Access-Control-Allow-Credentials": "",
+			"Access-Control-Allow-Methods":     "",
+			"Vary":                             "Origin",
+		}},
+		{"any origin", models.CORSPolicy{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}, "https://app.example.com", map[string]string{
+			"Access-Control-Allow-Origin":      "*",
+			"Access-Control-Allow-Credentials": "",
+			"Access-Control-Allow-Methods":     "GET",
+			"Access-Control-Max-Age":           "",
+			"Vary":                             "",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := corsRequest(newCORSRouter(test.policy), http.MethodOptions, test.origin, preflight)
+			if recorder.Code != http.StatusNoContent {
+				t.Fatalf("status %d, expected %d", recorder.Code, http.StatusNoContent)
+			}
+			for name, want := range test.wantHeaders {
+				if got := recorder.Header().Get(name); got != want {
+					t.Fatalf("%s %q, expected %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSExposedHeaders(t *testing.T) {
+	router := newCORSRouter(models.CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com"},
+		ExposedHeaders: []string{"Location", "X-Request-ID"},
+	})
+	recorder := corsRequest(router, http.MethodGet, "https://app.example.com", nil)
+	if got := recorder.Header().Get("Access-Control-Expose-Headers"); got != "Location, X-Request-ID" {
+		t.Fatalf("Access-Control-Expose-Headers %q, expected the exposed headers", got)
+	}
+	if got := recorder.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Fatalf("Access-Control-Allow-Methods %q on a request which isn't a preflight", got)
+	}
+}
@@ -1,16 +1,48 @@
 package middlewares
 
-import "github.com/gin-gonic/gin"
+import (
+	"city_os/src/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware, applying the CORS policy: allowed origins get the CORS headers, others get none and
+// their browser blocks the response. Preflight requests are answered here. policy is called per request,
+// so that a reloaded policy applies to the next request.
+
+func CORSMiddleware(policy func() *models.CORSPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Content-Disposition")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT , DELETE")
+		cors := policy()
+		header := c.Writer.Header()
+		origin := c.GetHeader("Origin")
+
+		// With "*" (no credentials) every response is the same, otherwise it depends on the origin and
+		// caches must not hand it to another one
+		allowOrigin := "*"
+		if !cors.AnyOrigin() {
+			header.Add("Vary", "Origin")
+			allowOrigin = origin
+		}
+		if cors.AllowsOrigin(origin) || cors.AnyOrigin() {
+			header.Set("Access-Control-Allow-Origin", allowOrigin)
+			if cors.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+				header.Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+				header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+				if cors.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+				}
+			} else if len(cors.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+			}
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
@@ -0,0 +1,94 @@
+package middlewares
+
+import (
+	logger "city_os/src/common"
+	"city_os/src/ratelimit"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ClientRateLimitMiddleware, limiting the request rate of the client address. Runs before AuthMiddleware,
+// so requests with missing or invalid credentials are limited before they reach the key store or the JWKS.
+
+func ClientRateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := c.ClientIP()
+		if allowed, retryAfter := limiter.AllowClientRequest(clientIP); !allowed {
+			logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Client rate limit exceeded!! client: %s, path: %s", clientIP, c.FullPath()))
+			abortTooManyRequests(c, retryAfter, "Rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimitMiddleware, limiting request rate and body bandwidth of the caller, the principal or the
+// client address when there is none. Runs after AuthMiddleware so that API keys are limited on their own.
+
+func RateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := rateLimitKey(c)
+		if allowed, retryAfter := limiter.AllowRequest(key); !allowed {
+			logger.FromContext(c.Request.Context()).Info(fmt.Sprintf("Rate limit exceeded!! key: %s, path: %s", key, c.FullPath()))
+			abortTooManyRequests(c, retryAfter, "Rate limit exceeded")
+			return
+		}
+
+		if c.Request.Body != nil {
+			c.Request.Body = limiter.Reader(c.Request.Context(), key, c.Request.Body)
+		}
+		c.Writer = &throttledResponseWriter{ResponseWriter: c.Writer, limiter: limiter, c: c, key: key}
+		c.Next()
+	}
+}
+
+// ConcurrentUploadsMiddleware, rejecting uploads while the configured number of uploads is in progress
+
+func ConcurrentUploadsMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started, retryAfter := limiter.StartUpload()
+		if !started {
+			logger.FromContext(c.Request.Context()).Info("Concurrent upload limit reached!!")
+			abortTooManyRequests(c, retryAfter, "Too many concurrent uploads")
+			return
+		}
+		defer limiter.FinishUpload()
+		c.Next()
+	}
+}
+
+func rateLimitKey(c *gin.Context) string {
+	if principal := GetPrincipal(c); principal != nil {
+		return principal.Ref()
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func abortTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": message, "retry_after": seconds})
+}
+
+// throttledResponseWriter, response body writes limited to the caller's bandwidth
+type throttledResponseWriter struct {
+	gin.ResponseWriter
+	limiter *ratelimit.Limiter
+	c       *gin.Context
+	key     string
+}
+
+func (w *throttledResponseWriter) Write(data []byte) (int, error) {
+	return w.limiter.Write(w.c.Request.Context(), w.key, w.ResponseWriter, data)
+}
+
+func (w *throttledResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
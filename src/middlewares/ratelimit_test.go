@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"city_os/src/models"
+	"city_os/src/ratelimit"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	tests := []struct {
+		name           string
+		limits         models.RateLimits
+		middleware     func(limiter *ratelimit.Limiter) gin.HandlerFunc
+		wantRetryAfter string
+	}{
+		{"request rate", models.RateLimits{RequestsPerSecond: 0.5, RequestBurst: 1}, RateLimitMiddleware, "2"},
+		{"sub-second wait rounded up", models.RateLimits{RequestsPerSecond: 10, RequestBurst: 1}, RateLimitMiddleware, "1"},
+		{"client rate", models.RateLimits{ClientRequestsPerSecond: 0.25, ClientRequestBurst: 1}, ClientRateLimitMiddleware, "4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", test.middleware(ratelimit.NewLimiter(test.limits)), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			first := httptest.NewRecorder()
+			router.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/", nil))
+			if first.Code != http.StatusOK {
+				t.Fatalf("first request status %d, expected %d", first.Code, http.StatusOK)
+			}
+			limited := httptest.NewRecorder()
+			router.ServeHTTP(limited, httptest.NewRequest(http.MethodGet, "/", nil))
+			if limited.Code != http.StatusTooManyRequests {
+				t.Fatalf("second request status %d, expected %d", limited.Code, http.StatusTooManyRequests)
+			}
+			if got := limited.Header().Get("Retry-After"); got != test.wantRetryAfter {
+				t.Fatalf("Retry-After %q, expected %q", got, test.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestConcurrentUploadsMiddleware(t *testing.T) {
+	limiter := ratelimit.NewLimiter(models.RateLimits{MaxConcurrentUploads: 1, UploadRetryAfter: 5 * time.Second})
+	router := gin.New()
+	router.POST("/files", ConcurrentUploadsMiddleware(limiter), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	// An upload in progress takes the only slot
+	limiter.StartUpload()
+	rejected := httptest.NewRecorder()
+	router.ServeHTTP(rejected, httptest.NewRequest(http.MethodPost, "/files", nil))
+	if rejected.Code != http.StatusTooManyRequests || rejected.Header().Get("Retry-After") != "5" {
+		t.Fatalf("upload at the cap: status %d, Retry-After %q, expected 429, \"5\"", rejected.Code, rejected.Header().Get("Retry-After"))
+	}
+
+	// Finished uploads give their slot back, also to the next request through the middleware
+	limiter.FinishUpload()
+	for i := 0; i < 2; i++ {
+		accepted := httptest.NewRecorder()
+		router.ServeHTTP(accepted, httptest.NewRequest(http.MethodPost, "/files", nil))
+		if accepted.Code != http.StatusCreated {
+			t.Fatalf("upload %d after the slot was freed: status %d, expected %d", i, accepted.Code, http.StatusCreated)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	logger "city_os/src/common"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"time"
+)
+
+const (
+	RequestIDHeader       = "X-Request-ID"
+	requestIDContextKey   = "request_id"
+	maxRequestIDLength    = 128
+	generatedRequestIDLen = 16 // random bytes, hex encoded
+)
+
+// RequestIDMiddleware, correlation id of the request, the caller's X-Request-ID when it is a sane
+// one or a random id otherwise. The id is echoed in the response and the request context carries a
+// logger with the id, the route and the file id, see logger.FromContext.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(requestIDContextKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		fields := log.Fields{
+			logger.FieldRequestID: requestID,
+			logger.FieldMethod:    c.Request.Method,
+			logger.FieldRoute:     c.FullPath(),
+		}
+		if fileId := c.Param("fileid"); fileId != "" {
+			fields[logger.FieldFileID] = fileId
+		}
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.HasTraceID() {
+			fields[logger.FieldTraceID] = spanContext.TraceID().String()
+		}
+		c.Request = c.Request.WithContext(logger.WithFields(c.Request.Context(), fields))
+		c.Next()
+	}
+}
+
+// GetRequestID, correlation id set by RequestIDMiddleware
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// AccessLogMiddleware, one log line per request in the format of every other log line, logged with
+// the request's logger so it carries the request id and the principal
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		bytesOut := c.Writer.Size()
+		if bytesOut < 0 { // nothing written
+			bytesOut = 0
+		}
+		entry := logger.FromContext(c.Request.Context()).WithFields(log.Fields{
+			"status":     status,
+			"path":       path,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+			"bytes_in":   c.Request.ContentLength,
+			"bytes_out":  bytesOut,
+			"access_log": true,
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+		switch {
+		case status >= 500:
+			entry.Error("request")
+		case status >= 400:
+			entry.Warn("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		isAlphaNumeric := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if !isAlphaNumeric && r != '-' && r != '_' && r != '.' && r != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, generatedRequestIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
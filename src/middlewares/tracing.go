@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"city_os/src/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/semconv/v1.17.0/httpconv"
+)
+
+// TracingMiddleware, server span of every request, continuing the trace of the caller's W3C traceparent
+// header. The span's context replaces the request context, handlers pass c.Request.Context() on so the
+// spans of the lower layers end up in the same trace.
+func TracingMiddleware(serverName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		spanName := c.Request.Method + " " + route
+		if route == "" {
+			spanName = c.Request.Method
+		}
+		ctx, span := tracing.StartServer(ctx, spanName, httpconv.ServerRequest(serverName, c.Request)...)
+		defer span.End()
+		if route != "" {
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		code, description := httpconv.ServerStatus(status)
+		span.SetStatus(code, description)
+	}
+}
@@ -1,7 +1,13 @@
 package models
 
 import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type VideoCatalogueData struct {
@@ -11,17 +17,626 @@ type VideoCatalogueData struct {
 	CreatedAt primitive.DateTime `bson:"created_at"`    // Video File created at time
 	FileType  string             `bson:"type"`          // Video File MIME type
 	Hash      string             `bson:"hash"`          // SHA256 hash of Video File Bytes, using it to detect duplicate Video files even with same filename provided
+	FastStart bool               `bson:"fast_start"`    // Stored bytes were rewritten with moov atom before mdat, Hash still refers to the original upload
+
+	StoredHash       string `bson:"stored_hash,omitempty"` // SHA256 hash of the bytes actually stored, only set when it differs from Hash
+	ProcessingStatus string `bson:"processing_status"`     // State of post-upload processing jobs, one of ProcessingStatus* constants
+	DurationMs       int64  `bson:"duration_ms,omitempty"` // Video duration found by the probe job
+
+	Owner  string     `bson:"owner,omitempty"`  // Principal reference of the uploader, files stored before ownership existed have none
+	ACL    []ACLGrant `bson:"acl,omitempty"`    // Permissions granted to principals other than the owner
+	Tenant string     `bson:"tenant,omitempty"` // Tenant namespace of the file, files stored before tenancy belong to DefaultTenant
+
+	Scan *ScanResult `bson:"scan,omitempty"` // Result of the malware scan at upload, missing when scanning is disabled
+
+	UsageCharged bool   `bson:"usage_charged,omitempty"` // Size and file count were added to the usage counters, files stored before quotas weren't
+	ChargedTo    string `bson:"charged_to,omitempty"`    // Principal reference whose usage counter was charged, kept apart from Owner which can be transferred
+
+	Collection string `bson:"collection,omitempty"` // Named group of files within the tenant, set by the upload URL the file was pushed through
+}
+
+// ACLGrant, permissions of a grantee on a Video File. Grantee is a principal reference,
+// "user:<subject>", "apikey:<key id>" or "group:<group name>".
+
+type ACLGrant struct {
+	Grantee     string   `bson:"grantee" json:"grantee"`
+	Permissions []string `bson:"permissions" json:"permissions"`
+}
+
+// Permissions of ACL grants, write allows managing the grants of the file
+const (
+	PermissionRead   = "read"
+	PermissionWrite  = "write"
+	PermissionDelete = "delete"
+)
+
+var Permissions = []string{PermissionRead, PermissionWrite, PermissionDelete}
+
+// FileACL, owner and grants of a Video File as exposed by the acl endpoints
+
+type FileACL struct {
+	FileId string     `json:"fileid"`
+	Owner  string     `json:"owner,omitempty"`
+	Grants []ACLGrant `json:"grants"`
+}
+
+// FileACLRequest, replacing the grants of a file. Owner transfers the file and is only accepted from
+// its owner or an admin.
+
+type FileACLRequest struct {
+	Owner  *string    `json:"owner"`
+	Grants []ACLGrant `json:"grants" binding:"required"`
+}
+
+// Processing states of a Video File, driven by the post-upload jobs
+const (
+	ProcessingStatusPending    = "pending"
+	ProcessingStatusProcessing = "processing"
+	ProcessingStatusReady      = "ready"
+	ProcessingStatusFailed     = "failed"
+	// Malware was found in the upload, the file is kept for inspection but can't be downloaded or processed
+	ProcessingStatusQuarantined = "quarantined"
+)
+
+// ScanResult, outcome of scanning the uploaded bytes for malware
+
+type ScanResult struct {
+	Status    string             `bson:"status" json:"status"` // one of ScanStatus* constants
+	Signature string             `bson:"signature,omitempty" json:"signature,omitempty"`
+	Engine    string             `bson:"engine,omitempty" json:"engine,omitempty"`
+	Error     string             `bson:"error,omitempty" json:"error,omitempty"` // why the scan failed, file was accepted unscanned
+	ScannedAt primitive.DateTime `bson:"scanned_at" json:"scanned_at"`
 }
 
+const (
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+	ScanStatusFailed   = "failed"
+)
+
 type VideoFilesDataResponse struct {
-	FileId    string             `json:"fileid,omitempty"`
-	Name      string             `json:"name"`
-	Size      int                `json:"size"`
-	CreatedAt primitive.DateTime `json:"created_at"`
+	FileId     string             `json:"fileid,omitempty"`
+	Name       string             `json:"name"`
+	Size       int                `json:"size"`
+	CreatedAt  primitive.DateTime `json:"created_at"`
+	Collection string             `json:"collection,omitempty"`
 }
 
 type VideoFileData struct {
 	Name          string
 	FileDataBytes []byte
 	FileMimeType  string
+	Offset        int64 // position of FileDataBytes in the file, 0 unless a range was read
+	TotalSize     int64 // size of the whole file
+}
+
+// ByteRange, a single range of a Range request header, bytes=Start-End or bytes=-Suffix
+type ByteRange struct {
+	Start  int64
+	End    int64 // last byte included, -1 up to the end of the file
+	Suffix int64 // > 0 for the last Suffix bytes of the file, Start and End are ignored
+}
+
+// Resolve, offset and length of the range in a file of size bytes, false when no byte of the range
+// is in the file
+func (br *ByteRange) Resolve(size int64) (int64, int64, bool) {
+	if br.Suffix > 0 {
+		if size == 0 {
+			return 0, 0, false
+		}
+		if br.Suffix > size {
+			return 0, size, true
+		}
+		return size - br.Suffix, br.Suffix, true
+	}
+	if br.Start >= size {
+		return 0, 0, false
+	}
+	end := br.End
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	return br.Start, end - br.Start + 1, true
+}
+
+// ParseByteRange, the byte range of a Range header with a single range. Headers with several ranges, other
+// units or bad syntax give nil, the whole file is sent then as the header may be ignored.
+
+func ParseByteRange(header string) *ByteRange {
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return nil
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return nil
+		}
+		return &ByteRange{Suffix: suffix}
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil
+	}
+	end := int64(-1)
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return nil
+		}
+	}
+	return &ByteRange{Start: start, End: end}
+}
+
+// Job, unit of asynchronous work stored in the Jobs collection and leased by workers
+
+type Job struct {
+	JobId       string             `bson:"_id,omitempty" json:"id"`
+	Type        string             `bson:"type" json:"type"`
+	FileId      string             `bson:"file_id,omitempty" json:"fileid,omitempty"`
+	Payload     map[string]string  `bson:"payload,omitempty" json:"payload,omitempty"`
+	Status      string             `bson:"status" json:"status"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	MaxAttempts int                `bson:"max_attempts" json:"max_attempts"`
+	LastError   string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	RunAt       primitive.DateTime `bson:"run_at" json:"run_at"`
+	Tenant      string             `bson:"tenant,omitempty" json:"-"` // Namespace of FileId
+	LeaseOwner  string             `bson:"lease_owner,omitempty" json:"-"`
+	LeaseUntil  primitive.DateTime `bson:"lease_until,omitempty" json:"-"`
+	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
+	UpdatedAt   primitive.DateTime `bson:"updated_at" json:"updated_at"`
+}
+
+// Job states, a failed attempt moves the job back to pending until MaxAttempts is reached,
+// after that it is parked in the dead state for manual inspection
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusDead      = "dead"
+)
+
+// Post-upload job types. Thumbnailing and packaging are not implemented yet, they need a media toolchain
+// (ffmpeg) which the server image doesn't ship.
+const (
+	JobTypeProbe           = "probe"
+	JobTypeRehash          = "rehash"
+	JobTypeWebhookDelivery = "webhook.deliver"
+)
+
+// File lifecycle events published to webhooks
+const (
+	EventFileCreated           = "file.created"
+	EventFileDeleted           = "file.deleted"
+	EventFileDuplicateRejected = "file.duplicate_rejected"
+	EventFileProcessed         = "file.processed"
+	EventFileQuarantined       = "file.quarantined" // stored instead of file.created when malware was found
+)
+
+var WebhookEventTypes = []string{EventFileCreated, EventFileDeleted, EventFileDuplicateRejected, EventFileProcessed, EventFileQuarantined}
+
+// Event, catalogue change recorded in the outbox collection in the same transaction as the change itself.
+// Seq is assigned in commit order and is the resume cursor of the change feed, the same envelope is
+// POSTed to webhook receivers.
+
+type Event struct {
+	Seq       int64              `bson:"_id" json:"seq"`
+	EventId   string             `bson:"event_id" json:"id"`
+	Type      string             `bson:"type" json:"type"`
+	FileId    string             `bson:"file_id,omitempty" json:"fileid,omitempty"`
+	Tenant    string             `bson:"tenant,omitempty" json:"tenant,omitempty"` // Only the tenant's change feed and webhooks see the event
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	Data      bson.M             `bson:"data" json:"data"`
+
+	Published  bool               `bson:"published" json:"-"` // Handed over to the webhook publisher by the outbox relay
+	ClaimOwner string             `bson:"claim_owner,omitempty" json:"-"`
+	ClaimUntil primitive.DateTime `bson:"claim_until,omitempty" json:"-"`
+}
+
+// Webhook, subscription of an external receiver to lifecycle events. Secret is the HMAC-SHA256 key
+// of the X-Webhook-Signature header, it is only revealed when the webhook is created.
+
+type Webhook struct {
+	WebhookId string             `bson:"_id,omitempty" json:"id"`
+	URL       string             `bson:"url" json:"url"`
+	Events    []string           `bson:"events" json:"events"`
+	Secret    string             `bson:"secret" json:"secret,omitempty"`
+	Active    bool               `bson:"active" json:"active"`
+	Tenant    string             `bson:"tenant,omitempty" json:"tenant,omitempty"` // Receives the events of this tenant only
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	UpdatedAt primitive.DateTime `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookRequest, body of webhook create and update calls, nil fields are left unchanged on update
+
+type WebhookRequest struct {
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Secret *string   `json:"secret"`
+	Active *bool     `json:"active"`
+}
+
+// WebhookDelivery, persistent log entry of an event sent to a single webhook
+
+type WebhookDelivery struct {
+	DeliveryId string                   `bson:"_id,omitempty" json:"id"`
+	WebhookId  string                   `bson:"webhook_id" json:"webhook_id"`
+	EventId    string                   `bson:"event_id" json:"event_id"`
+	EventType  string                   `bson:"event_type" json:"event_type"`
+	Payload    string                   `bson:"payload" json:"payload"`
+	Status     string                   `bson:"status" json:"status"`
+	JobId      string                   `bson:"job_id,omitempty" json:"job_id,omitempty"`
+	Attempts   []WebhookDeliveryAttempt `bson:"attempts" json:"attempts"`
+	CreatedAt  primitive.DateTime       `bson:"created_at" json:"created_at"`
+	UpdatedAt  primitive.DateTime       `bson:"updated_at" json:"updated_at"`
+}
+
+type WebhookDeliveryAttempt struct {
+	At           primitive.DateTime `bson:"at" json:"at"`
+	ResponseCode int                `bson:"response_code,omitempty" json:"response_code,omitempty"`
+	Error        string             `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs   int64              `bson:"duration_ms" json:"duration_ms"`
+}
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusSucceeded = "succeeded"
+	DeliveryStatusFailed    = "failed"
+)
+
+// Principal, authenticated caller of a request
+
+type Principal struct {
+	Id     string   `json:"id"`
+	Type   string   `json:"type"` // one of PrincipalType* constants
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes"`
+	Roles  []string `json:"roles,omitempty"`  // roles claimed by a bearer token
+	Groups []string `json:"groups,omitempty"` // groups claimed by a bearer token, usable as ACL grantees
+	Tenant string   `json:"tenant"`           // namespace of everything the principal sees, empty for DefaultTenant
+
+	FileId     string `json:"fileid,omitempty"`     // file a share principal is restricted to
+	Collection string `json:"collection,omitempty"` // collection an upload share principal's file goes into
+	SharedBy   string `json:"shared_by,omitempty"`  // reference of the principal which minted the share
+}
+
+const (
+	PrincipalTypeAPIKey = "apikey"
+	PrincipalTypeUser   = "user"  // subject of an OIDC bearer token
+	PrincipalTypeShare  = "share" // holder of a signed URL
+
+	GroupRefPrefix = "group:"
+)
+
+// Permission scopes, admin implies all the others
+const (
+	ScopeFilesRead   = "files:read"
+	ScopeFilesWrite  = "files:write"
+	ScopeFilesDelete = "files:delete"
+	ScopeAdmin       = "admin"
+)
+
+var Scopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeFilesDelete, ScopeAdmin}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrDuplicateFile, storing a file failed because the tenant already has a file with the same hash
+var ErrDuplicateFile = errors.New("file with the same hash exists")
+
+// DefaultTenant, tenant of callers without one: disabled auth, legacy API keys and tokens without tenant claim.
+// Its data lives in the unprefixed collections, so a single-tenant deployment keeps its data when tenancy is used.
+const DefaultTenant = "default"
+
+var (
+	ErrInvalidTenant = errors.New("tenant must be 1-32 characters of [a-z0-9_-] starting with a letter or digit")
+	tenantPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+)
+
+// ValidateTenant, tenants become part of collection names so only a safe subset of characters is accepted
+func ValidateTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+var (
+	ErrInvalidCollection = errors.New("collection must be 1-64 characters of [A-Za-z0-9_.-] starting with a letter or digit")
+	collectionPattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+)
+
+// ValidateCollection, collections are file labels within a tenant, used in query params of the file list
+func ValidateCollection(collection string) error {
+	if !collectionPattern.MatchString(collection) {
+		return ErrInvalidCollection
+	}
+	return nil
+}
+
+func NormalizeTenant(tenant string) string {
+	if tenant == "" {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+// TenantOf, tenant of the principal, DefaultTenant for a nil principal
+func TenantOf(principal *Principal) string {
+	if principal == nil {
+		return DefaultTenant
+	}
+	return NormalizeTenant(principal.Tenant)
+}
+
+// Ref, reference of the principal as stored in file owners and ACL grants
+func (p *Principal) Ref() string {
+	return p.Type + ":" + p.Id
+}
+
+// Refs, the principal's own reference followed by the references of its groups
+func (p *Principal) Refs() []string {
+	refs := []string{p.Ref()}
+	for _, group := range p.Groups {
+		refs = append(refs, GroupRefPrefix+group)
+	}
+	return refs
+}
+
+// OwnerRef, reference recorded as owner of what the principal stores, files uploaded through a signed URL
+// belong to the principal which minted it
+func (p *Principal) OwnerRef() string {
+	if p.SharedBy != "" {
+		return p.SharedBy
+	}
+	return p.Ref()
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, granted := range p.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey, stored API key. Only the SHA256 hash of the secret part is kept, the full key
+// "<prefix>_<id>_<secret>" is shown once when it is minted.
+
+type APIKey struct {
+	KeyId      string             `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	SecretHash string             `bson:"secret_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	Tenant     string             `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	CreatedAt  primitive.DateTime `bson:"created_at" json:"created_at"`
+	RevokedAt  primitive.DateTime `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+}
+
+// Quota, limits of a usage counter, 0 means unlimited
+
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes" mapstructure:"maxBytes"`
+	MaxFiles int64 `json:"max_files" mapstructure:"maxFiles"`
+}
+
+// QuotaLimits, quotas of every tenant and of every principal within its tenant, Tenants overrides the
+// tenant quota of single tenants
+
+type QuotaLimits struct {
+	Tenant  Quota
+	User    Quota
+	Tenants map[string]Quota
+}
+
+// TenantQuota, quota of the tenant's total usage
+func (ql *QuotaLimits) TenantQuota(tenant string) Quota {
+	if quota, found := ql.Tenants[tenant]; found {
+		return quota
+	}
+	return ql.Tenant
+}
+
+// RateLimits, throttling of clients, keyed by principal or by client address when auth is disabled.
+// 0 disables a limit.
+
+type RateLimits struct {
+	RequestsPerSecond       float64 // per principal, or client address when auth is disabled
+	RequestBurst            int
+	ClientRequestsPerSecond float64 // per client address, before authentication
+	ClientRequestBurst      int
+	BytesPerSecond          int64 // request and response body bandwidth
+	BytesBurst              int64
+	MaxConcurrentUploads    int // across all clients
+	UploadRetryAfter        time.Duration
+}
+
+// BodyLimits, request body size limits. Routes maps "<METHOD> <route path>" to the limit replacing Default,
+// MimeTypes limits uploaded files per media type and MinFileBytes rejects smaller uploads. 0 is unlimited.
+
+type BodyLimits struct {
+	Default      int64
+	Routes       map[string]int64
+	MimeTypes    map[string]int64
+	MinFileBytes int64
+}
+
+// RouteLimit, body limit of a route, route keys are matched case-insensitive
+func (bl *BodyLimits) RouteLimit(method string, path string) int64 {
+	if bl == nil {
+		return 0
+	}
+	if limit, found := bl.Routes[strings.ToLower(method+" "+path)]; found {
+		return limit
+	}
+	return bl.Default
+}
+
+// MimeTypeLimit, size limit of uploaded files of the media type, 0 when there is none
+func (bl *BodyLimits) MimeTypeLimit(mimeType string) int64 {
+	if bl == nil {
+		return 0
+	}
+	return bl.MimeTypes[strings.ToLower(mimeType)]
+}
+
+// CORSPolicy, what browsers on other origins may do with the API. AllowedOrigins are exact origins,
+// subdomain patterns like "https://*.example.com" or "*" for every origin, which excludes credentials.
+
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string      // request headers allowed besides the CORS-safelisted ones
+	ExposedHeaders   []string      // response headers scripts may read besides the CORS-safelisted ones
+	MaxAge           time.Duration // how long browsers may cache a preflight result, 0 leaves it to the browser
+	AllowCredentials bool          // cookies and Authorization headers on cross-origin requests
+}
+
+// AnyOrigin, whether every origin is allowed, the response doesn't depend on the request's origin then
+func (cp *CORSPolicy) AnyOrigin() bool {
+	for _, allowed := range cp.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrigin, whether the origin matches one of AllowedOrigins, origins are compared case-insensitive
+func (cp *CORSPolicy) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range cp.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// "https://*.example.com" matches any subdomain of example.com, but not example.com itself
+		if prefix, suffix, found := strings.Cut(allowed, "*."); found && strings.HasPrefix(origin, prefix) &&
+			strings.HasSuffix(origin, "."+suffix) {
+			if isSubdomain(origin[len(prefix) : len(origin)-len(suffix)-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSubdomain, whether s is one or more dot-separated host name labels, none of them empty
+func isSubdomain(s string) bool {
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || strings.Trim(label, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Usage, usage counter of a tenant or of a principal within a tenant, maintained on upload and delete
+
+type Usage struct {
+	UsageId string `bson:"_id" json:"-"`
+	Tenant  string `bson:"tenant" json:"tenant"`
+	Subject string `bson:"subject" json:"subject"` // UsageSubjectTenant or a principal reference
+	Bytes   int64  `bson:"bytes" json:"bytes"`
+	Files   int64  `bson:"files" json:"files"`
+}
+
+// UsageSubjectTenant, subject of the counter of a tenant's total usage
+const UsageSubjectTenant = "tenant"
+
+// UsageStatus, consumption of a usage counter against its quota
+
+type UsageStatus struct {
+	Subject  string `json:"subject"`
+	Bytes    int64  `json:"bytes"`
+	Files    int64  `json:"files"`
+	MaxBytes int64  `json:"max_bytes"` // 0 when unlimited
+	MaxFiles int64  `json:"max_files"` // 0 when unlimited
+}
+
+// UsageReport, response of GET /v1/usage, User is missing when auth is disabled
+
+type UsageReport struct {
+	Tenant      string       `json:"tenant"`
+	TenantUsage UsageStatus  `json:"tenant_usage"`
+	UserUsage   *UsageStatus `json:"user_usage,omitempty"`
+}
+
+// Share, signed URL minted for a caller without credentials. The URL carries the share id, its expiry and
+// an HMAC over both and the target, the record keeps the use count.
+
+type Share struct {
+	ShareId    string             `bson:"_id,omitempty" json:"id"`
+	Kind       string             `bson:"kind" json:"kind"` // one of ShareKind* constants
+	Tenant     string             `bson:"tenant" json:"tenant"`
+	FileId     string             `bson:"file_id,omitempty" json:"fileid,omitempty"`        // shared file of a download share
+	Collection string             `bson:"collection,omitempty" json:"collection,omitempty"` // target collection of an upload share
+	CreatedBy  string             `bson:"created_by" json:"created_by"`
+	IP         string             `bson:"ip,omitempty" json:"ip,omitempty"` // only requests from this address are accepted
+	MaxUses    int64              `bson:"max_uses" json:"max_uses"`         // 0 is unlimited
+	Uses       int64              `bson:"uses" json:"uses"`
+	ExpiresAt  primitive.DateTime `bson:"expires_at" json:"expires_at"`
+	CreatedAt  primitive.DateTime `bson:"created_at" json:"created_at"`
+}
+
+const (
+	ShareKindDownload = "download"
+	ShareKindUpload   = "upload" // one-shot, a single upload into the minter's tenant
+)
+
+// ShareRequest, minting a signed URL. Missing ExpiresIn takes the configured default, MaxDownloads is
+// ignored for upload URLs which are always single use, Collection only applies to them.
+
+type ShareRequest struct {
+	ExpiresIn    int64  `json:"expires_in"` // seconds
+	IP           string `json:"ip"`
+	MaxDownloads int64  `json:"max_downloads"`
+	Collection   string `json:"collection"`
+}
+
+type SignedURL struct {
+	Share *Share `json:"share"`
+	URL   string `json:"url"`
+}
+
+// DataKey, per-file key encrypting the file bytes, stored sealed by a master key. Rotating the master key
+// re-wraps WrappedKey only, the encrypted file bytes stay as they are.
+
+type DataKey struct {
+	Id          string             `bson:"_id" json:"id"` // "<tenant>/<file id>"
+	Tenant      string             `bson:"tenant" json:"tenant"`
+	FileId      string             `bson:"file_id" json:"fileid"`
+	MasterKeyId string             `bson:"master_key_id" json:"master_key_id"`
+	WrappedKey  []byte             `bson:"wrapped_key" json:"-"`
+	Algorithm   string             `bson:"algorithm" json:"algorithm"`   // one of DataKeyAlgorithm* constants
+	ChunkSize   int                `bson:"chunk_size" json:"chunk_size"` // plaintext bytes per sealed chunk
+	Size        int64              `bson:"size" json:"size"`             // plaintext size
+	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
+	RotatedAt   primitive.DateTime `bson:"rotated_at,omitempty" json:"rotated_at,omitempty"`
+}
+
+const DataKeyAlgorithmAES256GCMChunked = "AES-256-GCM-CHUNKED"
+
+func DataKeyId(tenant string, fileId string) string {
+	return NormalizeTenant(tenant) + "/" + fileId
 }
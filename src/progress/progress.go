@@ -0,0 +1,311 @@
+package progress
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Upload stages, in the order an upload passes them. Done, Failed and Expired are final.
+const (
+	StageWaiting        = "waiting" // progress subscribed before the upload request arrived
+	StageReceiving      = "receiving"
+	StageDuplicateCheck = "duplicate_check"
+	StageStoring        = "storing"
+	StageDone           = "done"
+	StageFailed         = "failed"
+	StageExpired        = "expired"
+)
+
+// Bytes received are reported at most once per this many bytes
+const bytesReportStep = 256 << 10
+
+var (
+	ErrInvalidUploadId = errors.New("upload id must be 1-64 characters of [A-Za-z0-9_-]")
+	ErrUploadIdInUse   = errors.New("upload id is already in use")
+	uploadIdPattern    = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Event, snapshot of an upload's progress as sent to subscribers
+type Event struct {
+	UploadId      string `json:"upload_id"`
+	Stage         string `json:"stage"`
+	BytesReceived int64  `json:"bytes_received"`
+	TotalBytes    int64  `json:"total_bytes,omitempty"` // request Content-Length, includes multipart framing
+	FileId        string `json:"fileid,omitempty"`
+	Status        int    `json:"status,omitempty"` // HTTP status of the upload response
+	Message       string `json:"message,omitempty"`
+}
+
+func (e Event) IsFinal() bool {
+	return e.Stage == StageDone || e.Stage == StageFailed || e.Stage == StageExpired
+}
+
+// Registry, progress trackers of in-flight uploads shared between the upload handler goroutine and
+// any number of progress stream goroutines. Finished trackers are kept for Retention so that a client
+// subscribing late still receives the final result.
+
+type Registry struct {
+	Retention time.Duration
+
+	mu       sync.Mutex
+	trackers map[string]*Tracker
+}
+
+func NewRegistry(retention time.Duration) *Registry {
+	return &Registry{Retention: retention, trackers: map[string]*Tracker{}}
+}
+
+func ValidateUploadId(uploadId string) error {
+	if !uploadIdPattern.MatchString(uploadId) {
+		return ErrInvalidUploadId
+	}
+	return nil
+}
+
+// Start, registering an upload. A tracker created by an early subscriber is taken over, a tracker of a
+// running or recently finished upload is not.
+
+func (r *Registry) Start(uploadId string, totalBytes int64) (*Tracker, error) {
+	if err := ValidateUploadId(uploadId); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tracker, found := r.trackers[uploadId]
+	if !found || tracker.expired() {
+		tracker = r.newTracker(uploadId)
+	}
+	if !tracker.markStarted() {
+		return nil, ErrUploadIdInUse
+	}
+	tracker.update(func(event *Event) {
+		event.Stage = StageReceiving
+		event.TotalBytes = totalBytes
+	})
+	return tracker, nil
+}
+
+// Subscribe, returning a subscription to the upload's events. Unknown uploads get a waiting tracker
+// which expires after Retention if the upload never starts.
+
+func (r *Registry) Subscribe(uploadId string) (*Subscription, error) {
+	if err := ValidateUploadId(uploadId); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	tracker, found := r.trackers[uploadId]
+	if !found {
+		tracker = r.newTracker(uploadId)
+		time.AfterFunc(r.Retention, tracker.expireIfWaiting)
+	}
+	r.mu.Unlock()
+	return tracker.subscribe(), nil
+}
+
+// newTracker, must be called with r.mu held
+func (r *Registry) newTracker(uploadId string) *Tracker {
+	tracker := &Tracker{
+		event:       Event{UploadId: uploadId, Stage: StageWaiting},
+		subscribers: map[*Subscription]struct{}{},
+	}
+	tracker.onFinish = func() {
+		time.AfterFunc(r.Retention, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.trackers[uploadId] == tracker {
+				delete(r.trackers, uploadId)
+			}
+		})
+	}
+	r.trackers[uploadId] = tracker
+	return tracker
+}
+
+// Tracker, progress of a single upload. All methods are safe on a nil Tracker, so the upload flow
+// doesn't have to care whether the client asked for progress.
+
+type Tracker struct {
+	mu           sync.Mutex
+	event        Event
+	started      bool
+	lastReported int64
+	subscribers  map[*Subscription]struct{}
+	onFinish     func()
+}
+
+// markStarted, false when the tracker already belongs to an upload
+func (t *Tracker) markStarted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started {
+		return false
+	}
+	t.started = true
+	return true
+}
+
+func (t *Tracker) expired() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.started && t.event.IsFinal()
+}
+
+func (t *Tracker) expireIfWaiting() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.started {
+		t.finishLocked(StageExpired, 0, "", "upload did not start in time")
+	}
+}
+
+func (t *Tracker) AddBytes(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.event.BytesReceived += int64(n)
+	report := t.event.BytesReceived-t.lastReported >= bytesReportStep
+	if report {
+		t.lastReported = t.event.BytesReceived
+		t.broadcast()
+	}
+	t.mu.Unlock()
+}
+
+func (t *Tracker) SetStage(stage string) {
+	if t == nil {
+		return
+	}
+	t.update(func(event *Event) {
+		event.Stage = stage
+	})
+}
+
+// Done and Fail, publishing the final result of the upload
+
+func (t *Tracker) Done(status int, fileId string) {
+	t.finish(StageDone, status, fileId, "")
+}
+
+func (t *Tracker) Fail(status int, message string) {
+	t.finish(StageFailed, status, "", message)
+}
+
+func (t *Tracker) finish(stage string, status int, fileId string, message string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finishLocked(stage, status, fileId, message)
+}
+
+func (t *Tracker) finishLocked(stage string, status int, fileId string, message string) {
+	if t.event.IsFinal() {
+		return
+	}
+	t.event.Stage = stage
+	t.event.Status = status
+	t.event.FileId = fileId
+	t.event.Message = message
+	t.broadcast()
+	t.onFinish()
+}
+
+func (t *Tracker) update(apply func(event *Event)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.event.IsFinal() {
+		return
+	}
+	apply(&t.event)
+	t.lastReported = t.event.BytesReceived
+	t.broadcast()
+}
+
+// broadcast, must be called with t.mu held
+func (t *Tracker) broadcast() {
+	for subscription := range t.subscribers {
+		subscription.push(t.event)
+	}
+}
+
+func (t *Tracker) subscribe() *Subscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	subscription := &Subscription{notify: make(chan struct{}, 1), tracker: t}
+	subscription.push(t.event)
+	if !t.event.IsFinal() {
+		t.subscribers[subscription] = struct{}{}
+	}
+	return subscription
+}
+
+// Subscription, queue of events for one subscriber. Consecutive byte count updates of the same stage are
+// coalesced, so a slow reader never blocks the upload and still sees every stage transition.
+
+type Subscription struct {
+	mu      sync.Mutex
+	queue   []Event
+	notify  chan struct{}
+	tracker *Tracker
+}
+
+// Notify, signalled whenever new events are queued
+func (s *Subscription) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// Drain, returning and clearing the queued events
+func (s *Subscription) Drain() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.queue
+	s.queue = nil
+	return events
+}
+
+func (s *Subscription) Close() {
+	s.tracker.mu.Lock()
+	delete(s.tracker.subscribers, s)
+	s.tracker.mu.Unlock()
+}
+
+func (s *Subscription) push(event Event) {
+	s.mu.Lock()
+	if last := len(s.queue) - 1; last >= 0 && s.queue[last].Stage == event.Stage && !event.IsFinal() {
+		s.queue[last] = event
+	} else {
+		s.queue = append(s.queue, event)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// WrapBody, counting the bytes read from a request body into the tracker
+func (t *Tracker) WrapBody(body io.ReadCloser) io.ReadCloser {
+	if t == nil {
+		return body
+	}
+	return &countingBody{body, t}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	tracker *Tracker
+}
+
+func (cb *countingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.tracker.AddBytes(n)
+	return n, err
+}
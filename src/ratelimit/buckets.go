@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Buckets idle that long are full again and get dropped
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Buckets, token buckets per key, e.g. per principal or client address. A rate of 0 disables the limit.
+
+type Buckets struct {
+	mu        sync.Mutex
+	rate      float64 // tokens added per second
+	burst     float64 // capacity of a bucket
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // clock of the refills, replaced in tests
+}
+
+func NewBuckets(rate float64, burst float64) *Buckets {
+	buckets := &Buckets{buckets: map[string]*bucket{}, lastSweep: time.Now(), now: time.Now}
+	buckets.SetRate(rate, burst)
+	return buckets
+}
+
+// SetRate, changing the limit, tokens already in the buckets are kept up to the new burst.
+// Without burst a bucket holds a second's worth of tokens.
+func (b *Buckets) SetRate(rate float64, burst float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if burst <= 0 {
+		burst = math.Max(rate, 1)
+	}
+	b.rate, b.burst = rate, burst
+}
+
+// Take, taking n tokens from the key's bucket when it has them, otherwise how long it takes until it has
+func (b *Buckets) Take(key string, n float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return true, 0
+	}
+	current := b.refill(key, b.now())
+	if current.tokens >= n {
+		current.tokens -= n
+		return true, 0
+	}
+	return false, b.duration(n - current.tokens)
+}
+
+// Reserve, taking n tokens even if the bucket runs into debt, returns how long the caller has to wait
+// to stay within the rate
+func (b *Buckets) Reserve(key string, n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	current := b.refill(key, b.now())
+	current.tokens -= n
+	if current.tokens >= 0 {
+		return 0
+	}
+	return b.duration(-current.tokens)
+}
+
+func (b *Buckets) refill(key string, now time.Time) *bucket {
+	if now.Sub(b.lastSweep) > sweepInterval {
+		b.sweep(now)
+	}
+	current, found := b.buckets[key]
+	if !found {
+		current = &bucket{tokens: b.burst, last: now}
+		b.buckets[key] = current
+		return current
+	}
+	current.tokens += now.Sub(current.last).Seconds() * b.rate
+	if current.tokens > b.burst {
+		current.tokens = b.burst
+	}
+	current.last = now
+	return current
+}
+
+// sweep, dropping the buckets which have refilled completely, they are recreated full on next use
+func (b *Buckets) sweep(now time.Time) {
+	for key, current := range b.buckets {
+		if current.tokens+now.Sub(current.last).Seconds()*b.rate >= b.burst {
+			delete(b.buckets, key)
+		}
+	}
+	b.lastSweep = now
+}
+
+func (b *Buckets) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestBuckets, buckets refilled by a clock which only moves when the test advances it
+func newTestBuckets(rate float64, burst float64) (*Buckets, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	buckets := NewBuckets(rate, burst)
+	buckets.now = func() time.Time { return clock.now }
+	buckets.lastSweep = clock.now
+	return buckets, clock
+}
+
+type takeStep struct {
+	advance     time.Duration
+	key         string
+	n           float64
+	wantAllowed bool
+	wantWait    time.Duration
+}
+
+func TestBucketsTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst float64
+		steps []takeStep
+	}{
+		{"burst then limited", 2, 3, []takeStep{
+			{0, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{0, "a", 1, false, 500 * time.Millisecond},
+		}},
+		{"refill after the wait", 2, 1, []takeStep{
+			{0, "a", 1, true, 0},
+			{0, "a", 1, false, 500 * time.Millisecond},
+			{250 * time.Millisecond, "a", 1, false, 250 * time.Millisecond},
+			{250 * time.Millisecond, "a", 1, true, 0},
+		}},
+		{"refill capped at burst", 10, 2, []takeStep{
+			{0, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{10 * time.Second, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{0, "a", 1, false, 100 * time.Millisecond},
+		}},
+		{"keys are independent", 1, 1, []takeStep{
+			{0, "a", 1, true, 0},
+			{0, "a", 1, false, time.Second},
+			{0, "b", 1, true, 0},
+		}},
+		{"denied take keeps the tokens", 1, 4, []takeStep{
+			{0, "a", 3, true, 0},
+			{0, "a", 2, false, time.Second},
+			{0, "a", 1, true, 0},
+		}},
+		{"without burst a second of tokens", 4, 0, []takeStep{
+			{0, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{0, "a", 1, true, 0},
+			{0, "a", 1, false, 250 * time.Millisecond},
+		}},
+		{"zero rate is unlimited", 0, 0, []takeStep{
+			{0, "a", 1000, true, 0},
+			{0, "a", 1000, true, 0},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buckets, clock := newTestBuckets(test.rate, test.burst)
+			for i, step := range test.steps {
+				clock.advance(step.advance)
+				allowed, wait := buckets.Take(step.key, step.n)
+				if allowed != step.wantAllowed || wait != step.wantWait {
+					t.Fatalf("step %d: Take = %v, %s, expected %v, %s", i, allowed, wait, step.wantAllowed, step.wantWait)
+				}
+			}
+		})
+	}
+}
+
+// TestBucketsReserveDebt, reservations beyond the tokens put the bucket into debt, which later
+// reservations and takes have to wait out
+func TestBucketsReserveDebt(t *testing.T) {
+	buckets, clock := newTestBuckets(1000, 1000)
+
+	steps := []struct {
+		advance time.Duration
+		n       float64
+		want    time.Duration
+	}{
+		{0, 1000, 0},
+		{0, 500, 500 * time.Millisecond},
+		{0, 500, time.Second},
+		{500 * time.Millisecond, 0, 500 * time.Millisecond},
+	}
+	for i, step := range steps {
+		clock.advance(step.advance)
+		if got := buckets.Reserve("a", step.n); got != step.want {
+			t.Fatalf("step %d: Reserve = %s, expected %s", i, got, step.want)
+		}
+	}
+
+	if allowed, wait := buckets.Take("a", 1); allowed || wait != 501*time.Millisecond {
+		t.Fatalf("Take in debt = %v, %s, expected false, 501ms", allowed, wait)
+	}
+	clock.advance(time.Second)
+	if allowed, _ := buckets.Take("a", 1); !allowed {
+		t.Fatal("Take after the debt was paid back denied")
+	}
+}
+
+// TestBucketsSweep, buckets which have refilled completely are dropped, the others kept
+func TestBucketsSweep(t *testing.T) {
+	buckets, clock := newTestBuckets(0.25, 16)
+
+	buckets.Take("full", 16)
+	clock.advance(50 * time.Second)
+	buckets.Take("partial", 16)
+	clock.advance(20 * time.Second)
+	buckets.Take("new", 1)
+
+	if _, found := buckets.buckets["full"]; found {
+		t.Error("refilled bucket not swept")
+	}
+	if _, found := buckets.buckets["partial"]; !found {
+		t.Error("partially refilled bucket swept")
+	}
+	if allowed, _ := buckets.Take("full", 16); !allowed {
+		t.Error("swept bucket not full when recreated")
+	}
+	if allowed, wait := buckets.Take("partial", 6); allowed || wait != 4*time.Second {
+		t.Errorf("kept bucket Take = %v, %s, expected false, 4s", allowed, wait)
+	}
+
+	// No second sweep until the interval passed again
+	clock.advance(30 * time.Second)
+	buckets.Take("other", 1)
+	if _, found := buckets.buckets["new"]; !found {
+		t.Error("swept again before the interval passed")
+	}
+}
+
+func TestBucketsSetRateKeepsTokensUpToBurst(t *testing.T) {
+	buckets, _ := newTestBuckets(1, 10)
+	buckets.Take("a", 2)
+
+	buckets.SetRate(1, 5)
+	for i := 0; i < 5; i++ {
+		if allowed, _ := buckets.Take("a", 1); !allowed {
+			t.Fatalf("take %d denied after lowering the burst", i)
+		}
+	}
+	if allowed, _ := buckets.Take("a", 1); allowed {
+		t.Fatal("bucket kept more tokens than the new burst")
+	}
+}
@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"city_os/src/models"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Bandwidth is accounted in chunks of at most this many bytes, so a large read or write is spread
+// over time instead of being delayed all at once
+const bandwidthChunk = 32 << 10
+
+// Limiter, request rate and bandwidth limits per key, request rate limits per client address and the
+// global cap on concurrent uploads. Configure may be called at any time to apply changed limits.
+
+type Limiter struct {
+	requests  *Buckets
+	clients   *Buckets
+	bandwidth *Buckets
+
+	mu                   sync.Mutex
+	activeUploads        int
+	maxConcurrentUploads int
+	uploadRetryAfter     time.Duration
+}
+
+func NewLimiter(limits models.RateLimits) *Limiter {
+	limiter := &Limiter{requests: NewBuckets(0, 0), clients: NewBuckets(0, 0), bandwidth: NewBuckets(0, 0)}
+	limiter.Configure(limits)
+	return limiter
+}
+
+func (l *Limiter) Configure(limits models.RateLimits) {
+	l.requests.SetRate(limits.RequestsPerSecond, float64(limits.RequestBurst))
+	l.clients.SetRate(limits.ClientRequestsPerSecond, float64(limits.ClientRequestBurst))
+	l.bandwidth.SetRate(float64(limits.BytesPerSecond), float64(limits.BytesBurst))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxConcurrentUploads = limits.MaxConcurrentUploads
+	l.uploadRetryAfter = limits.UploadRetryAfter
+}
+
+// AllowRequest, counting a request of the key, how long until the next one is allowed when it isn't
+func (l *Limiter) AllowRequest(key string) (bool, time.Duration) {
+	return l.requests.Take(key, 1)
+}
+
+// AllowClientRequest, counting a request of the client address, whoever it authenticates as
+func (l *Limiter) AllowClientRequest(clientIP string) (bool, time.Duration) {
+	return l.clients.Take(clientIP, 1)
+}
+
+// StartUpload, taking one of the concurrent upload slots, the caller has to call FinishUpload once done.
+// Without a free slot it returns false and the time after which the client should retry.
+func (l *Limiter) StartUpload() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxConcurrentUploads > 0 && l.activeUploads >= l.maxConcurrentUploads {
+		return false, l.uploadRetryAfter
+	}
+	l.activeUploads++
+	return true, 0
+}
+
+func (l *Limiter) FinishUpload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.activeUploads--
+}
+
+// ThrottleBytes, waiting until n bytes of the key fit into its bandwidth, returns early with the context's
+// error when it is cancelled
+func (l *Limiter) ThrottleBytes(ctx context.Context, key string, n int) error {
+	delay := l.bandwidth.Reserve(key, float64(n))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader, body reader limited to the key's bandwidth
+func (l *Limiter) Reader(ctx context.Context, key string, reader io.ReadCloser) io.ReadCloser {
+	return &throttledReader{ReadCloser: reader, limiter: l, ctx: ctx, key: key}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	limiter *Limiter
+	ctx     context.Context
+	key     string
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthChunk {
+		p = p[:bandwidthChunk]
+	}
+	n, err := tr.ReadCloser.Read(p)
+	if n > 0 {
+		if throttleErr := tr.limiter.ThrottleBytes(tr.ctx, tr.key, n); throttleErr != nil {
+			return n, throttleErr
+		}
+	}
+	return n, err
+}
+
+// Write, writing p in chunks, each one after the key's bandwidth allows it
+func (l *Limiter) Write(ctx context.Context, key string, writer io.Writer, p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > bandwidthChunk {
+			chunk = chunk[:bandwidthChunk]
+		}
+		if err := l.ThrottleBytes(ctx, key, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
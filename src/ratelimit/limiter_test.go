@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"city_os/src/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterConcurrentUploads(t *testing.T) {
+	limiter := NewLimiter(models.RateLimits{MaxConcurrentUploads: 2, UploadRetryAfter: 5 * time.Second})
+
+	for i := 0; i < 2; i++ {
+		if started, _ := limiter.StartUpload(); !started {
+			t.Fatalf("upload %d rejected below the cap", i)
+		}
+	}
+	if started, retryAfter := limiter.StartUpload(); started || retryAfter != 5*time.Second {
+		t.Fatalf("StartUpload at the cap = %v, %s, expected false, 5s", started, retryAfter)
+	}
+
+	limiter.FinishUpload()
+	if started, _ := limiter.StartUpload(); !started {
+		t.Fatal("upload rejected after a slot was freed")
+	}
+
+	// A lowered cap applies to the uploads in progress
+	limiter.Configure(models.RateLimits{MaxConcurrentUploads: 1, UploadRetryAfter: time.Second})
+	limiter.FinishUpload()
+	if started, retryAfter := limiter.StartUpload(); started || retryAfter != time.Second {
+		t.Fatalf("StartUpload over the lowered cap = %v, %s, expected false, 1s", started, retryAfter)
+	}
+	limiter.FinishUpload()
+	if started, _ := limiter.StartUpload(); !started {
+		t.Fatal("upload rejected below the lowered cap")
+	}
+}
+
+func TestLimiterConcurrentUploadsUnlimited(t *testing.T) {
+	limiter := NewLimiter(models.RateLimits{})
+	for i := 0; i < 100; i++ {
+		if started, _ := limiter.StartUpload(); !started {
+			t.Fatalf("upload %d rejected without a cap", i)
+		}
+	}
+}
+
+// TestLimiterConcurrentUploadsRace, uploads starting at the same time never get more slots than the cap
+func TestLimiterConcurrentUploadsRace(t *testing.T) {
+	const workers = 50
+	limiter := NewLimiter(models.RateLimits{MaxConcurrentUploads: 5})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	started := 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := limiter.StartUpload(); ok {
+				mu.Lock()
+				started++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 5 {
+		t.Fatalf("%d uploads started, expected 5", started)
+	}
+}
+
+func TestLimiterClientAndPrincipalLimitsAreSeparate(t *testing.T) {
+	limiter := NewLimiter(models.RateLimits{
+		RequestsPerSecond: 1, RequestBurst: 1,
+		ClientRequestsPerSecond: 1, ClientRequestBurst: 2,
+	})
+
+	if allowed, _ := limiter.AllowRequest("user:alice"); !allowed {
+		t.Fatal("first request of the principal denied")
+	}
+	if allowed, _ := limiter.AllowRequest("user:alice"); allowed {
+		t.Fatal("request over the principal's burst allowed")
+	}
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.AllowClientRequest("192.0.2.1"); !allowed {
+			t.Fatalf("client request %d denied within its burst", i)
+		}
+	}
+	if allowed, _ := limiter.AllowClientRequest("192.0.2.1"); allowed {
+		t.Fatal("request over the client's burst allowed")
+	}
+}
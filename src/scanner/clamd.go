@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"bufio"
+	"city_os/src/models"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	engineName       = "clamd"
+	defaultChunkSize = 64 << 10
+)
+
+var ErrScanFailed = errors.New("scan failed")
+
+// ClamdScanner, IScanner talking to a ClamAV daemon with the INSTREAM command: the file is streamed as
+// chunks prefixed with their big-endian uint32 length and terminated by an empty chunk, clamd answers
+// "stream: OK" or "stream: <signature> FOUND". Every scan uses its own connection.
+
+type ClamdScanner struct {
+	Network   string // "tcp" or "unix"
+	Address   string
+	Timeout   time.Duration // of a whole scan, including the connect
+	ChunkSize int
+}
+
+// NewClamdScanner, scanner of a clamd address given as tcp://host:port or unix:///path/to/clamd.sock
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	network, path, found := strings.Cut(address, "://")
+	if !found || (network != "tcp" && network != "unix") || path == "" {
+		return nil, fmt.Errorf("clamd address %q must be tcp://host:port or unix:///path", address)
+	}
+	return &ClamdScanner{Network: network, Address: path, Timeout: timeout, ChunkSize: defaultChunkSize}, nil
+}
+
+func (cs *ClamdScanner) Scan(fileData io.Reader) (*models.ScanResult, error) {
+	reply, err := cs.command("zINSTREAM\x00", func(conn net.Conn) error {
+		return streamChunks(conn, fileData, cs.chunkSize())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := models.ScanResult{Engine: engineName, ScannedAt: primitive.NewDateTimeFromTime(time.Now())}
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		result.Status = models.ScanStatusClean
+	case strings.HasSuffix(reply, " FOUND"):
+		result.Status = models.ScanStatusInfected
+		result.Signature = strings.TrimSuffix(reply, " FOUND")
+	default:
+		// e.g. "INSTREAM size limit exceeded. ERROR"
+		return nil, fmt.Errorf("%w: clamd replied %q", ErrScanFailed, reply)
+	}
+	return &result, nil
+}
+
+// Ping, checking clamd is up and answering
+func (cs *ClamdScanner) Ping() error {
+	reply, err := cs.command("zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("%w: clamd replied %q to PING", ErrScanFailed, reply)
+	}
+	return nil
+}
+
+// command, sending a null terminated command and its payload, returns the null terminated reply
+func (cs *ClamdScanner) command(command string, writePayload func(conn net.Conn) error) (string, error) {
+	conn, err := net.DialTimeout(cs.Network, cs.Address, cs.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+	}
+	defer conn.Close()
+	if cs.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(cs.Timeout))
+	}
+
+	if _, err = conn.Write([]byte(command)); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+	}
+	if writePayload != nil {
+		if err = writePayload(conn); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading clamd reply: %s", ErrScanFailed, err.Error())
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+func streamChunks(conn net.Conn, data io.Reader, chunkSize int) error {
+	writer := bufio.NewWriterSize(conn, chunkSize+4)
+	size := make([]byte, 4)
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(data, chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, writeErr := writer.Write(size); writeErr != nil {
+				return writeErr
+			}
+			if _, writeErr := writer.Write(chunk[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := writer.Write(size); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (cs *ClamdScanner) chunkSize() int {
+	if cs.ChunkSize <= 0 {
+		return defaultChunkSize
+	}
+	return cs.ChunkSize
+}
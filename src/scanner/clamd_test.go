@@ -0,0 +1,184 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"city_os/src/models"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd, clamd speaking INSTREAM on a local port: files containing signature are reported infected and
+// streams longer than maxSize get the size limit error, only once the whole stream was read so the client
+// sees the reply rather than a reset connection. With hang set it never answers.
+
+type fakeClamd struct {
+	signature []byte
+	maxSize   int
+	hang      bool
+
+	received chan []byte // streamed file of every scan
+}
+
+func startFakeClamd(t *testing.T, clamd *fakeClamd) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clamd.received = make(chan []byte, 10)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go clamd.serve(conn)
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+func (fc *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+	if command == "zPING\x00" {
+		_, _ = conn.Write([]byte("PONG\x00"))
+		return
+	}
+
+	var stream bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, size); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(size)
+		if length == 0 {
+			break
+		}
+		if _, err := io.CopyN(&stream, reader, int64(length)); err != nil {
+			return
+		}
+	}
+	fc.received <- stream.Bytes()
+
+	if fc.maxSize > 0 && stream.Len() > fc.maxSize {
+		_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+		return
+	}
+
+	if fc.hang {
+		time.Sleep(time.Second)
+		return
+	}
+	if len(fc.signature) > 0 && bytes.Contains(stream.Bytes(), fc.signature) {
+		_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		return
+	}
+	_, _ = conn.Write([]byte("stream: OK\x00"))
+}
+
+func TestClamdScannerScan(t *testing.T) {
+	signature := []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR")
+	tests := []struct {
+		name          string
+		clamd         fakeClamd
+		file          []byte
+		wantStatus    string
+		wantSignature string
+		wantErr       bool
+		wantErrText   string
+	}{
+		{
+			name:       "clean",
+			clamd:      fakeClamd{signature: signature},
+			file:       bytes.Repeat([]byte("video"), 1000),
+			wantStatus: models.ScanStatusClean,
+		},
+		{
+			name:          "infected",
+			clamd:         fakeClamd{signature: signature},
+			file:          append(bytes.Repeat([]byte("video"), 1000), signature...),
+			wantStatus:    models.ScanStatusInfected,
+			wantSignature: "Eicar-Test-Signature",
+		},
+		{
+			name:        "size limit exceeded",
+			clamd:       fakeClamd{signature: signature, maxSize: 1024},
+			file:        bytes.Repeat([]byte("video"), 1000),
+			wantErr:     true,
+			wantErrText: "size limit exceeded",
+		},
+		{
+			name:        "timeout",
+			clamd:       fakeClamd{hang: true},
+			file:        []byte("video"),
+			wantErr:     true,
+			wantErrText: "timeout",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clamd := test.clamd
+			scanner, err := NewClamdScanner(startFakeClamd(t, &clamd), 200*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// several chunks per file
+			scanner.ChunkSize = 512
+
+			start := time.Now()
+			result, err := scanner.Scan(bytes.NewReader(test.file))
+			if test.wantErr {
+				if !errors.Is(err, ErrScanFailed) || (test.wantErrText != "" && !strings.Contains(err.Error(), test.wantErrText)) {
+					t.Fatalf("expected ErrScanFailed %q, got result %+v, error %v", test.wantErrText, result, err)
+				}
+				if elapsed := time.Since(start); elapsed > time.Second {
+					t.Fatalf("scan returned after %s, timeout is 200ms", elapsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != test.wantStatus || result.Signature != test.wantSignature || result.Engine != engineName {
+				t.Fatalf("got status %q signature %q engine %q, expected %q %q %q",
+					result.Status, result.Signature, result.Engine, test.wantStatus, test.wantSignature, engineName)
+			}
+			if received := <-clamd.received; !bytes.Equal(received, test.file) {
+				t.Fatalf("clamd received %d bytes, file has %d", len(received), len(test.file))
+			}
+		})
+	}
+}
+
+func TestClamdScannerPing(t *testing.T) {
+	scanner, err := NewClamdScanner(startFakeClamd(t, &fakeClamd{}), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scanner.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClamdScannerAddress(t *testing.T) {
+	for _, address := range []string{"localhost:3310", "udp://localhost:3310", "tcp://", "unix://"} {
+		if _, err := NewClamdScanner(address, time.Second); err == nil {
+			t.Errorf("address %q accepted", address)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package shutdown
+
+import (
+	"context"
+	"sync"
+)
+
+// Drain, set when the server starts shutting down. Readiness fails from then on so load balancers
+// stop sending new requests, while requests in flight are still served. Methods are safe on a nil
+// Drain, which never drains.
+
+type Drain struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func NewDrain() *Drain {
+	return &Drain{done: make(chan struct{})}
+}
+
+// Start, entering the drain phase, later calls do nothing
+func (d *Drain) Start() {
+	if d == nil {
+		return
+	}
+	d.once.Do(func() { close(d.done) })
+}
+
+func (d *Drain) Draining() bool {
+	if d == nil {
+		return false
+	}
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Context, parent which is also cancelled when draining starts, for waits which have no reason to
+// outlive the server like change feed long-polls
+func (d *Drain) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if d == nil {
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrMP4Malformed      = errors.New("mp4: malformed atom structure")
+	ErrMP4CompressedMoov = errors.New("mp4: compressed moov atoms are not supported")
+	ErrMP4OffsetOverflow = errors.New("mp4: chunk offset does not fit into stco after relocation")
+)
+
+// Container atoms on the path from moov down to the chunk offset tables
+var mp4ContainerAtomsToSeek = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// mp4Atom, position of a single atom inside a byte slice. Atoms sized 0 in their header extend to the end
+// of their parent, Size is the resolved one.
+type mp4Atom struct {
+	Type       string
+	Offset     int // offset of the atom header
+	HeaderSize int // 8, or 16 for 64-bit sized atoms
+	Size       int // full atom size including the header
+}
+
+// parseMP4Atoms, walking sibling atoms in data[start:end]
+
+func parseMP4Atoms(data []byte, start int, end int) ([]mp4Atom, error) {
+	atoms := make([]mp4Atom, 0)
+	for pos := start; pos < end; {
+		if end-pos < 8 {
+			return nil, ErrMP4Malformed
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		atomType := string(data[pos+4 : pos+8])
+		headerSize := 8
+		switch size {
+		case 0:
+			// atom extends to the end of its parent
+			size = uint64(end - pos)
+		case 1:
+			if end-pos < 16 {
+				return nil, ErrMP4Malformed
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			headerSize = 16
+		}
+		if size < uint64(headerSize) || size > uint64(end-pos) {
+			return nil, ErrMP4Malformed
+		}
+		atoms = append(atoms, mp4Atom{atomType, pos, headerSize, int(size)})
+		pos += int(size)
+	}
+	return atoms, nil
+}
+
+// MP4FastStart, relocating the moov atom in front of the media data so that players can start
+// playback before the whole file is downloaded. Chunk offsets in stco/co64 tables are shifted by the
+// size of the relocated moov atom. The second return value reports whether the data was rewritten,
+// files which are already fast-start (or have no moov/mdat at all) are returned unchanged.
+
+func MP4FastStart(data []byte) ([]byte, bool, error) {
+	atoms, err := parseMP4Atoms(data, 0, len(data))
+	if err != nil {
+		return data, false, err
+	}
+
+	moovIndex, mdatIndex := -1, -1
+	for i, atom := range atoms {
+		if atom.Type == "moov" && moovIndex == -1 {
+			moovIndex = i
+		}
+		if atom.Type == "mdat" && mdatIndex == -1 {
+			mdatIndex = i
+		}
+	}
+	if moovIndex == -1 || mdatIndex == -1 || moovIndex < mdatIndex {
+		return data, false, nil
+	}
+
+	moov := atoms[moovIndex]
+	insertAt := atoms[mdatIndex].Offset
+	moovBytes := make([]byte, moov.Size)
+	copy(moovBytes, data[moov.Offset:moov.Offset+moov.Size])
+	// A moov sized 0 extends to the end of the file, in front of mdat it has to carry its real size.
+	// 64-bit sized moov atoms keep their size, it doesn't change with the relocation.
+	if moov.HeaderSize == 8 && binary.BigEndian.Uint32(moovBytes[0:4]) == 0 {
+		if uint64(moov.Size) > math.MaxUint32 {
+			return data, false, ErrMP4Malformed
+		}
+		binary.BigEndian.PutUint32(moovBytes[0:4], uint32(moov.Size))
+	}
+
+	// Only data placed between the new moov position and the old one moves forward.
+	shift := func(offset uint64) uint64 {
+		if offset >= uint64(insertAt) && offset < uint64(moov.Offset) {
+			return offset + uint64(moov.Size)
+		}
+		return offset
+	}
+	if err = patchMP4ChunkOffsets(moovBytes, mp4Atom{"moov", 0, moov.HeaderSize, moov.Size}, shift); err != nil {
+		return data, false, err
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:insertAt]...)
+	out = append(out, moovBytes...)
+	out = append(out, data[insertAt:moov.Offset]...)
+	out = append(out, data[moov.Offset+moov.Size:]...)
+	return out, true, nil
+}
+
+// patchMP4ChunkOffsets, descending into the sample table containers and rewriting every stco/co64 entry
+
+func patchMP4ChunkOffsets(data []byte, parent mp4Atom, shift func(uint64) uint64) error {
+	children, err := parseMP4Atoms(data, parent.Offset+parent.HeaderSize, parent.Offset+parent.Size)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		switch {
+		case child.Type == "cmov":
+			return ErrMP4CompressedMoov
+		case mp4ContainerAtomsToSeek[child.Type]:
+			if err = patchMP4ChunkOffsets(data, child, shift); err != nil {
+				return err
+			}
+		case child.Type == "stco" || child.Type == "co64":
+			if err = patchMP4ChunkOffsetTable(data[child.Offset+child.HeaderSize:child.Offset+child.Size], child.Type == "co64", shift); err != nil {
+				return fmt.Errorf("%s: %w", child.Type, err)
+			}
+		}
+	}
+	return nil
+}
+
+// patchMP4ChunkOffsetTable, table layout: version(1) flags(3) entry_count(4) entries(4 or 8 bytes each)
+
+func patchMP4ChunkOffsetTable(table []byte, wide bool, shift func(uint64) uint64) error {
+	if len(table) < 8 {
+		return ErrMP4Malformed
+	}
+	entrySize := 4
+	if wide {
+		entrySize = 8
+	}
+	count := int(binary.BigEndian.Uint32(table[4:8]))
+	if count < 0 || len(table)-8 < count*entrySize {
+		return ErrMP4Malformed
+	}
+	for i := 0; i < count; i++ {
+		entry := table[8+i*entrySize : 8+(i+1)*entrySize]
+		if wide {
+			binary.BigEndian.PutUint64(entry, shift(binary.BigEndian.Uint64(entry)))
+			continue
+		}
+		offset := shift(uint64(binary.BigEndian.Uint32(entry)))
+		if offset > math.MaxUint32 {
+			return ErrMP4OffsetOverflow
+		}
+		binary.BigEndian.PutUint32(entry, uint32(offset))
+	}
+	return nil
+}
+
+// MP4Duration, reading the presentation duration from the movie header (moov/mvhd)
+
+func MP4Duration(data []byte) (time.Duration, error) {
+	atoms, err := parseMP4Atoms(data, 0, len(data))
+	if err != nil {
+		return 0, err
+	}
+	for _, atom := range atoms {
+		if atom.Type != "moov" {
+			continue
+		}
+		children, err := parseMP4Atoms(data, atom.Offset+atom.HeaderSize, atom.Offset+atom.Size)
+		if err != nil {
+			return 0, err
+		}
+		for _, child := range children {
+			if child.Type != "mvhd" {
+				continue
+			}
+			mvhd := data[child.Offset+child.HeaderSize : child.Offset+child.Size]
+			var timescale, duration uint64
+			switch {
+			case len(mvhd) >= 32 && mvhd[0] == 1:
+				timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+				duration = binary.BigEndian.Uint64(mvhd[24:32])
+			case len(mvhd) >= 20 && mvhd[0] == 0:
+				timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+				duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+			default:
+				return 0, ErrMP4Malformed
+			}
+			if timescale == 0 {
+				return 0, ErrMP4Malformed
+			}
+			return time.Duration(duration/timescale*uint64(time.Second)) + time.Duration(duration%timescale*uint64(time.Second)/timescale), nil
+		}
+	}
+	return 0, ErrMP4Malformed
+}
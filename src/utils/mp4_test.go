@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// atom, an atom with a 32-bit size
+func atom(atomType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, atomType...), body...)
+}
+
+// atomSizedZero, an atom extending to the end of its parent
+func atomSizedZero(atomType string, payload ...[]byte) []byte {
+	out := atom(atomType, payload...)
+	binary.BigEndian.PutUint32(out, 0)
+	return out
+}
+
+// atom64, an atom with a 64-bit size
+func atom64(atomType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, 1)
+	out = append(out, atomType...)
+	out = binary.BigEndian.AppendUint64(out, uint64(16+len(body)))
+	return append(out, body...)
+}
+
+func chunkOffsetTable(atomType string, offsets ...uint64) []byte {
+	table := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(offsets)))
+	for _, offset := range offsets {
+		if atomType == "co64" {
+			table = binary.BigEndian.AppendUint64(table, offset)
+		} else {
+			table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		}
+	}
+	return atom(atomType, table)
+}
+
+// sampleTable, moov/trak/mdia/minf/stbl down to the chunk offset table
+func sampleTable(table []byte) []byte {
+	return atom("trak", atom("mdia", atom("minf", atom("stbl", table))))
+}
+
+// chunkOffsets, entries of every stco/co64 table of the file's moov
+func chunkOffsets(t *testing.T, data []byte) []uint64 {
+	t.Helper()
+	var offsets []uint64
+	var walk func(start int, end int)
+	walk = func(start int, end int) {
+		atoms, err := parseMP4Atoms(data, start, end)
+		if err != nil {
+			t.Fatalf("output isn't a valid atom structure: %v", err)
+		}
+		for _, atom := range atoms {
+			table := data[atom.Offset+atom.HeaderSize : atom.Offset+atom.Size]
+			switch {
+			case mp4ContainerAtomsToSeek[atom.Type]:
+				walk(atom.Offset+atom.HeaderSize, atom.Offset+atom.Size)
+			case atom.Type == "stco":
+				for i := 0; i < int(binary.BigEndian.Uint32(table[4:8])); i++ {
+					offsets = append(offsets, uint64(binary.BigEndian.Uint32(table[8+i*4:])))
+				}
+			case atom.Type == "co64":
+				for i := 0; i < int(binary.BigEndian.Uint32(table[4:8])); i++ {
+					offsets = append(offsets, binary.BigEndian.Uint64(table[8+i*8:]))
+				}
+			}
+		}
+	}
+	walk(0, len(data))
+	return offsets
+}
+
+func TestMP4FastStartShiftsChunkOffsets(t *testing.T) {
+	ftyp := atom("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	chunks := [][]byte{[]byte("first chunk of samples"), []byte("second chunk"), []byte("third and last chunk")}
+	mdatPayload := bytes.Join(chunks, nil)
+
+	tests := []struct {
+		name string
+		// file, the test file, its tables hold the offsets computed for its mdat header size
+		file       func(offsets func(mdatHeader int) []uint64) []byte
+		mdatHeader int
+	}{
+		{
+			name:       "stco",
+			mdatHeader: 8,
+			file: func(offsets func(int) []uint64) []byte {
+				moov := atom("moov", sampleTable(chunkOffsetTable("stco", offsets(8)...)))
+				return bytes.Join([][]byte{ftyp, atom("mdat", mdatPayload), moov}, nil)
+			},
+		},
+		{
+			name:       "co64",
+			mdatHeader: 8,
+			file: func(offsets func(int) []uint64) []byte {
+				moov := atom("moov", sampleTable(chunkOffsetTable("co64", offsets(8)...)))
+				return bytes.Join([][]byte{ftyp, atom("mdat", mdatPayload), moov}, nil)
+			},
+		},
+		{
+			name:       "several tracks",
+			mdatHeader: 8,
+			file: func(offsets func(int) []uint64) []byte {
+				all := offsets(8)
+				moov := atom("moov", sampleTable(chunkOffsetTable("stco", all[:1]...)), sampleTable(chunkOffsetTable("co64", all[1:]...)))
+				return bytes.Join([][]byte{ftyp, atom("mdat", mdatPayload), moov}, nil)
+			},
+		},
+		{
+			name:       "moov sized 0",
+			mdatHeader: 8,
+			file: func(offsets func(int) []uint64) []byte {
+				moov := atomSizedZero("moov", sampleTable(chunkOffsetTable("stco", offsets(8)...)))
+				return bytes.Join([][]byte{ftyp, atom("mdat", mdatPayload), moov}, nil)
+			},
+		},
+		{
+			name:       "64-bit sized moov",
+			mdatHeader: 8,
+			file: func(offsets func(int) []uint64) []byte {
+				moov := atom64("moov", sampleTable(chunkOffsetTable("co64", offsets(8)...)))
+				return bytes.Join([][]byte{ftyp, atom("mdat", mdatPayload), moov}, nil)
+			},
+		},
+		{
+			name:       "64-bit sized mdat",
+			mdatHeader: 16,
+			file: func(offsets func(int) []uint64) []byte {
+				moov := atom("moov", sampleTable(chunkOffsetTable("stco", offsets(16)...)))
+				return bytes.Join([][]byte{ftyp, atom64("mdat", mdatPayload), moov}, nil)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// every chunk, and one offset into ftyp in front of mdat which must not move
+			offsets := func(mdatHeader int) []uint64 {
+				offsets := []uint64{uint64(8)}
+				position := len(ftyp) + mdatHeader
+				for _, chunk := range chunks {
+					offsets = append(offsets, uint64(position))
+					position += len(chunk)
+				}
+				return offsets
+			}
+			data := test.file(offsets)
+			original := append([]byte(nil), data...)
+
+			out, rewritten, err := MP4FastStart(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rewritten {
+				t.Fatal("moov behind mdat wasn't relocated")
+			}
+			if !bytes.Equal(data, original) {
+				t.Fatal("input was modified")
+			}
+			if len(out) != len(data) {
+				t.Fatalf("output has %d bytes, input %d", len(out), len(data))
+			}
+
+			atoms, err := parseMP4Atoms(out, 0, len(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(atoms) != 3 || atoms[0].Type != "ftyp" || atoms[1].Type != "moov" || atoms[2].Type != "mdat" {
+				t.Fatalf("unexpected atom order %+v", atoms)
+			}
+			moovSize := uint64(atoms[1].Size)
+
+			got := chunkOffsets(t, out)
+			want := offsets(test.mdatHeader)
+			if len(got) != len(want) {
+				t.Fatalf("%d chunk offsets, expected %d", len(got), len(want))
+			}
+			if got[0] != want[0] {
+				t.Errorf("offset in front of mdat moved from %d to %d", want[0], got[0])
+			}
+			for i, chunk := range chunks {
+				if got[i+1] != want[i+1]+moovSize {
+					t.Errorf("chunk %d offset %d, expected %d shifted by moov size %d", i, got[i+1], want[i+1], moovSize)
+				}
+				if !bytes.Equal(out[got[i+1]:got[i+1]+uint64(len(chunk))], chunk) {
+					t.Errorf("chunk %d offset doesn't point at its samples", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMP4FastStartUnchanged(t *testing.T) {
+	ftyp := atom("ftyp", []byte("isom"))
+	moov := atom("moov", sampleTable(chunkOffsetTable("stco", 100)))
+	tests := map[string][]byte{
+		"already fast-start": bytes.Join([][]byte{ftyp, moov, atom("mdat", []byte("samples"))}, nil),
+		"without moov":       bytes.Join([][]byte{ftyp, atom("mdat", []byte("samples"))}, nil),
+		"without mdat":       bytes.Join([][]byte{ftyp, moov}, nil),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, rewritten, err := MP4FastStart(data)
+			if err != nil || rewritten || !bytes.Equal(out, data) {
+				t.Fatalf("expected the file unchanged, got rewritten %v, error %v", rewritten, err)
+			}
+		})
+	}
+}
+
+func TestMP4FastStartRejects(t *testing.T) {
+	ftyp := atom("ftyp", []byte("isom"))
+	mdat := atom("mdat", []byte("samples"))
+	truncatedMoov := atom("moov", sampleTable(chunkOffsetTable("stco", 20)))
+	binary.BigEndian.PutUint32(truncatedMoov, uint32(len(truncatedMoov)+10))
+	shortTable := atom("stco", binary.BigEndian.AppendUint32(make([]byte, 4), 3), make([]byte, 4))
+	smallLargeSize := append(append(binary.BigEndian.AppendUint32(nil, 1), "moov"...), make([]byte, 8)...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "compressed moov", data: bytes.Join([][]byte{ftyp, mdat, atom("moov", atom("cmov", []byte("zlib")))}, nil), wantErr: ErrMP4CompressedMoov},
+		{name: "atom beyond the end", data: bytes.Join([][]byte{ftyp, mdat, truncatedMoov}, nil), wantErr: ErrMP4Malformed},
+		{name: "table shorter than its count", data: bytes.Join([][]byte{ftyp, mdat, atom("moov", sampleTable(shortTable))}, nil), wantErr: ErrMP4Malformed},
+		{name: "64-bit size below the header size", data: bytes.Join([][]byte{ftyp, mdat, smallLargeSize}, nil), wantErr: ErrMP4Malformed},
+		{name: "trailing bytes", data: bytes.Join([][]byte{ftyp, mdat, []byte("abc")}, nil), wantErr: ErrMP4Malformed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, rewritten, err := MP4FastStart(test.data)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+			if rewritten || !bytes.Equal(out, test.data) {
+				t.Fatal("the file has to be returned unchanged on errors")
+			}
+		})
+	}
+}
+
+func TestPatchMP4ChunkOffsetTable(t *testing.T) {
+	shift := func(offset uint64) uint64 { return offset + 1000 }
+	tests := []struct {
+		name    string
+		wide    bool
+		offsets []uint64
+		want    []uint64
+		wantErr error
+	}{
+		{name: "stco", offsets: []uint64{0, 48, 4096}, want: []uint64{1000, 1048, 5096}},
+		{name: "co64", wide: true, offsets: []uint64{0, math.MaxUint32}, want: []uint64{1000, math.MaxUint32 + 1000}},
+		{name: "stco overflow", offsets: []uint64{8, math.MaxUint32 - 10}, wantErr: ErrMP4OffsetOverflow},
+		{name: "empty", offsets: nil, want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomType := "stco"
+			if test.wide {
+				atomType = "co64"
+			}
+			table := chunkOffsetTable(atomType, test.offsets...)[8:]
+			err := patchMP4ChunkOffsetTable(table, test.wide, shift)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range test.want {
+				var got uint64
+				if test.wide {
+					got = binary.BigEndian.Uint64(table[8+i*8:])
+				} else {
+					got = uint64(binary.BigEndian.Uint32(table[8+i*4:]))
+				}
+				if got != want {
+					t.Errorf("entry %d: %d, expected %d", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"hash"
 )
 
 func ToBson(v interface{}) (*bson.D, error) {
@@ -20,6 +21,19 @@ func ToBson(v interface{}) (*bson.D, error) {
 	return &doc, nil
 }
 
+func ToBsonM(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	err = bson.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
 func ToSHA256(data []byte) (hash string, error error) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -31,3 +45,9 @@ func ToSHA256(data []byte) (hash string, error error) {
 	h.Write(data)
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
+
+// NewFileHasher, hash of ToSHA256 for data which is streamed, hex encoded Sum of the written bytes
+// equals ToSHA256 of them
+func NewFileHasher() hash.Hash {
+	return sha1.New()
+}